@@ -0,0 +1,387 @@
+// Package catalog is an embedded database of every ingest and every file
+// copied, kept in a single append-only file and queried in memory.
+package catalog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/autofileingest/internal/checksum"
+)
+
+// Ingest results
+const (
+	ResultRunning = "running"
+	ResultSuccess = "success"
+	// ResultPartial means some files failed
+	ResultPartial = "partial"
+	ResultFailed  = "failed"
+)
+
+// File statuses
+const (
+	StatusCopied  = "copied"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// Ingest is one offload of a device
+type Ingest struct {
+	ID             int64     `json:"id"`
+	DeviceName     string    `json:"device_name"`
+	DeviceIdentity string    `json:"device_identity"`
+	Label          string    `json:"label"`
+	Operator       string    `json:"operator"`
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	Result         string    `json:"result"`
+	Error          string    `json:"error,omitempty"`
+	Files          int       `json:"files"`
+	FailedFiles    int       `json:"failed_files"`
+	SkippedFiles   int       `json:"skipped_files"`
+	Bytes          int64     `json:"bytes"`
+	// Roll is the camera roll assigned to the card, such as A001
+	Roll string `json:"roll,omitempty"`
+}
+
+// File is one file of an ingest
+type File struct {
+	ID         int64  `json:"id"`
+	IngestID   int64  `json:"ingest_id"`
+	SourcePath string `json:"source_path"`
+	Size       int64  `json:"size"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	// Checksums maps algorithm names to hex digests
+	Checksums map[string]string `json:"checksums,omitempty"`
+	Copies    []Copy            `json:"copies"`
+	// Tokens parsed from the file name
+	Client     string `json:"client,omitempty"`
+	Project    string `json:"project,omitempty"`
+	Camera     string `json:"camera,omitempty"`
+	ClipNumber string `json:"clip_number,omitempty"`
+	Roll       string `json:"roll,omitempty"`
+	// Span is the first segment of the spanned clip the file belongs to,
+	// SpanPart its position in the clip
+	Span     string `json:"span,omitempty"`
+	SpanPart int    `json:"span_part,omitempty"`
+	// Media metadata
+	MediaType  string    `json:"media_type,omitempty"`
+	Extension  string    `json:"extension,omitempty"`
+	RecordedAt time.Time `json:"recorded_at"`
+	Completed  time.Time `json:"completed"`
+	// Verified is when all copies had last been checked by the scrubber
+	Verified time.Time `json:"verified"`
+}
+
+// Copy is the location of a file on one destination
+type Copy struct {
+	Destination string `json:"destination"`
+	// RelPath is the slash-separated path within the destination
+	RelPath string `json:"rel_path"`
+	// Location is the path or URL shown in logs and reports
+	Location string `json:"location"`
+	// Verified is when the scrubber last hashed this copy
+	Verified time.Time `json:"verified"`
+}
+
+// Algorithm picks a recorded hash to check the file with, preferring the
+// order of preferred, else the first supported one by name. It returns ""
+// when none is supported.
+func (f File) Algorithm(preferred []string) string {
+	for _, name := range preferred {
+		if _, ok := f.Checksums[name]; ok {
+			return name
+		}
+	}
+	var names []string
+	for name := range f.Checksums {
+		if checksum.IsSupported(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// record is one line of the catalog file. Later records of the same ID
+// replace earlier ones.
+type record struct {
+	Ingest *Ingest `json:"ingest,omitempty"`
+	File   *File   `json:"file,omitempty"`
+}
+
+// Catalog is safe for concurrent use
+type Catalog struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	ingests map[int64]Ingest
+	files   map[int64]File
+	// byIngest lists the file IDs of each ingest in the order added
+	byIngest   map[int64][]int64
+	lastIngest int64
+	lastFile   int64
+	// stale counts the records in the file replaced by later ones
+	stale int
+}
+
+// Open loads the catalog at path, creating it if needed. A record cut
+// short by a crash is ignored.
+func Open(path string) (*Catalog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create catalog folder: %w", err)
+	}
+
+	c := &Catalog{
+		path:     path,
+		ingests:  map[int64]Ingest{},
+		files:    map[int64]File{},
+		byIngest: map[int64][]int64{},
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c.file = f
+	return c, nil
+}
+
+// load replays the catalog file
+func (c *Catalog) load() error {
+	f, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	torn := false
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			torn = true
+			continue
+		}
+		c.apply(r)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read catalog %s: %w", c.path, err)
+	}
+
+	// Rewrite a torn file so new records start on a line of their own, and
+	// one mostly made of replaced records
+	if torn || c.isStale() {
+		return c.rewrite()
+	}
+	return nil
+}
+
+// apply adds a record to the in-memory state
+func (c *Catalog) apply(r record) {
+	if r.Ingest != nil {
+		if _, ok := c.ingests[r.Ingest.ID]; ok {
+			c.stale++
+		}
+		c.ingests[r.Ingest.ID] = *r.Ingest
+		if r.Ingest.ID > c.lastIngest {
+			c.lastIngest = r.Ingest.ID
+		}
+	}
+	if r.File != nil {
+		if _, ok := c.files[r.File.ID]; ok {
+			c.stale++
+		} else {
+			c.byIngest[r.File.IngestID] = append(c.byIngest[r.File.IngestID], r.File.ID)
+		}
+		c.files[r.File.ID] = *r.File
+		if r.File.ID > c.lastFile {
+			c.lastFile = r.File.ID
+		}
+	}
+}
+
+// append writes a record and applies it. Callers hold c.mu.
+func (c *Catalog) append(r record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := c.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	c.apply(r)
+	return nil
+}
+
+// Close closes the catalog file
+func (c *Catalog) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.file.Close()
+}
+
+// PutIngest adds an ingest, or replaces the one with the same ID. A new
+// ingest gets the next ID.
+func (c *Catalog) PutIngest(ingest Ingest) (Ingest, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ingest.ID == 0 {
+		ingest.ID = c.lastIngest + 1
+	}
+	if err := c.append(record{Ingest: &ingest}); err != nil {
+		return Ingest{}, err
+	}
+	return ingest, nil
+}
+
+// PutFile adds a file, or replaces the one with the same ID. A new file
+// gets the next ID.
+func (c *Catalog) PutFile(file File) (File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if file.ID == 0 {
+		file.ID = c.lastFile + 1
+	}
+	if err := c.append(record{File: &file}); err != nil {
+		return File{}, err
+	}
+	return file, nil
+}
+
+// Ingest returns an ingest by ID
+func (c *Catalog) Ingest(id int64) (Ingest, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ingest, ok := c.ingests[id]
+	return ingest, ok
+}
+
+// File returns a file by ID
+func (c *Catalog) File(id int64) (File, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	file, ok := c.files[id]
+	return file, ok
+}
+
+// Compact rewrites the catalog with only the latest version of each record
+func (c *Catalog) Compact() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.compact()
+}
+
+// CompactIfStale compacts the catalog once replaced records outnumber the
+// current ones, so records updated over and over, such as by the scrubber,
+// don't grow the file without bound. It reports whether it compacted.
+func (c *Catalog) CompactIfStale() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.isStale() {
+		return false, nil
+	}
+	return true, c.compact()
+}
+
+// isStale reports whether most records in the file have been replaced
+func (c *Catalog) isStale() bool {
+	return c.stale > len(c.ingests)+len(c.files)
+}
+
+// compact rewrites the catalog and reopens it. Callers hold c.mu.
+func (c *Catalog) compact() error {
+	if err := c.file.Close(); err != nil {
+		return err
+	}
+	if err := c.rewrite(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	c.file = f
+	return nil
+}
+
+// rewrite replaces the catalog file with the in-memory state
+func (c *Catalog) rewrite() error {
+	temp := c.path + ".tmp"
+	f, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, ingest := range c.sortedIngests() {
+		ingest := ingest
+		if err := enc.Encode(record{Ingest: &ingest}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	for _, file := range c.sortedFiles() {
+		file := file
+		if err := enc.Encode(record{File: &file}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, c.path); err != nil {
+		return err
+	}
+	c.stale = 0
+	return nil
+}
+
+// sortedIngests returns the ingests in ID order
+func (c *Catalog) sortedIngests() []Ingest {
+	ingests := make([]Ingest, 0, len(c.ingests))
+	for _, ingest := range c.ingests {
+		ingests = append(ingests, ingest)
+	}
+	sort.Slice(ingests, func(i, j int) bool { return ingests[i].ID < ingests[j].ID })
+	return ingests
+}
+
+// sortedFiles returns the files in ID order
+func (c *Catalog) sortedFiles() []File {
+	files := make([]File, 0, len(c.files))
+	for _, file := range c.files {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+	return files
+}
@@ -0,0 +1,187 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestCatalog(t *testing.T, path string) *Catalog {
+	t.Helper()
+	c, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open catalog: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// seed records two ingests of a card and one of a sound recorder
+func seed(t *testing.T, c *Catalog) (Ingest, Ingest, Ingest) {
+	t.Helper()
+	day := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+
+	put := func(ingest Ingest, files ...File) Ingest {
+		ingest, err := c.PutIngest(ingest)
+		if err != nil {
+			t.Fatalf("PutIngest failed: %v", err)
+		}
+		for _, f := range files {
+			f.IngestID = ingest.ID
+			if _, err := c.PutFile(f); err != nil {
+				t.Fatalf("PutFile failed: %v", err)
+			}
+		}
+		return ingest
+	}
+
+	lunch := put(Ingest{DeviceIdentity: "1234-ABCD", Label: "A001", Operator: "sam", Start: day.Add(3 * time.Hour), Result: ResultSuccess},
+		File{SourcePath: "/media/A001/Nike_Ad_ACam_001.mov", Client: "Ad", Project: "Nike", Camera: "ACam", MediaType: "video", Status: StatusCopied,
+			Checksums: map[string]string{"xxh64": "aaaa"}, Copies: []Copy{{Destination: "primary", RelPath: "Ad/Nike/ACam/001.mov"}},
+			Completed: day.Add(3 * time.Hour)},
+		File{SourcePath: "/media/A001/Nike_Ad_ACam_002.mov", Client: "Ad", Project: "Nike", Camera: "ACam", MediaType: "video", Status: StatusFailed,
+			Completed: day.Add(3 * time.Hour)})
+	wrap := put(Ingest{DeviceIdentity: "1234-ABCD", Label: "A001", Operator: "sam", Start: day.Add(9 * time.Hour), Result: ResultPartial},
+		File{SourcePath: "/media/A001/Nike_Ad_ACam_003.mov", Client: "Ad", Project: "Nike", Camera: "ACam", MediaType: "video", Status: StatusCopied,
+			Copies: []Copy{{Destination: "primary"}, {Destination: "nas"}}, Completed: day.Add(9 * time.Hour)})
+	sound := put(Ingest{DeviceIdentity: "5678-EF01", Label: "ZOOM", Operator: "alex", Start: day.Add(10 * time.Hour), Result: ResultSuccess},
+		File{SourcePath: "/media/ZOOM/ZOOM0001.WAV", MediaType: "audio", Status: StatusCopied, Completed: day.Add(10 * time.Hour)})
+	return lunch, wrap, sound
+}
+
+func TestCatalog_Queries(t *testing.T) {
+	c := openTestCatalog(t, filepath.Join(t.TempDir(), "catalog.jsonl"))
+	lunch, wrap, sound := seed(t, c)
+
+	ingests := c.Ingests(IngestQuery{DeviceIdentity: "1234-ABCD"})
+	if len(ingests) != 2 || ingests[0].ID != wrap.ID || ingests[1].ID != lunch.ID {
+		t.Errorf("Expected both card ingests newest first, got %+v", ingests)
+	}
+	if got := c.Ingests(IngestQuery{Operator: "ALEX"}); len(got) != 1 || got[0].ID != sound.ID {
+		t.Errorf("Expected the sound ingest for operator alex, got %+v", got)
+	}
+	if got := c.Ingests(IngestQuery{Label: "a0*", Result: ResultPartial}); len(got) != 1 || got[0].ID != wrap.ID {
+		t.Errorf("Expected the partial wrap ingest, got %+v", got)
+	}
+	if got := c.Ingests(IngestQuery{Since: wrap.Start, Limit: 1}); len(got) != 1 || got[0].ID != sound.ID {
+		t.Errorf("Expected only the newest ingest, got %+v", got)
+	}
+
+	if got := c.Files(FileQuery{IngestID: lunch.ID}); len(got) != 2 {
+		t.Errorf("Expected 2 files in the lunch ingest, got %d", len(got))
+	}
+	if got := c.Files(FileQuery{Camera: "acam", Status: StatusCopied}); len(got) != 2 {
+		t.Errorf("Expected 2 copied ACam files, got %d", len(got))
+	}
+	if got := c.Files(FileQuery{MediaType: "audio"}); len(got) != 1 || got[0].IngestID != sound.ID {
+		t.Errorf("Expected the WAV file, got %+v", got)
+	}
+	if got := c.Files(FileQuery{Digest: "AAAA"}); len(got) != 1 || !strings.HasSuffix(got[0].SourcePath, "001.mov") {
+		t.Errorf("Expected a file by digest, got %+v", got)
+	}
+	if got := c.Files(FileQuery{Name: "*_003.MOV", Destination: "nas"}); len(got) != 1 {
+		t.Errorf("Expected the mirrored clip by name, got %+v", got)
+	}
+	if got := c.Files(FileQuery{Until: wrap.Start}); len(got) != 2 {
+		t.Errorf("Expected 2 files completed before the wrap ingest, got %d", len(got))
+	}
+}
+
+func TestCatalog_PersistsAndCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "catalog.jsonl")
+	c, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open catalog: %v", err)
+	}
+	lunch, _, _ := seed(t, c)
+
+	// Finishing an ingest replaces its record
+	lunch.End = lunch.Start.Add(time.Hour)
+	lunch.Files = 1
+	if _, err := c.PutIngest(lunch); err != nil {
+		t.Fatalf("PutIngest failed: %v", err)
+	}
+	c.Close()
+
+	// A crash in the middle of a write leaves a torn line
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"ingest":{"id":9`)
+	f.Close()
+
+	c = openTestCatalog(t, path)
+	got, ok := c.Ingest(lunch.ID)
+	if !ok || got.Files != 1 || !got.End.Equal(lunch.End) {
+		t.Fatalf("Expected the updated ingest after reopening, got %+v", got)
+	}
+	if len(c.Ingests(IngestQuery{})) != 3 || len(c.Files(FileQuery{})) != 4 {
+		t.Fatalf("Expected 3 ingests and 4 files, got %d and %d",
+			len(c.Ingests(IngestQuery{})), len(c.Files(FileQuery{})))
+	}
+
+	added, err := c.PutIngest(Ingest{Label: "B001"})
+	if err != nil || added.ID != 4 {
+		t.Fatalf("Expected the next ingest ID to be 4, got %d (%v)", added.ID, err)
+	}
+
+	if err := c.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 8 {
+		t.Errorf("Expected 8 records after compacting, got %d", lines)
+	}
+	if file, ok := c.File(1); !ok || file.IngestID != lunch.ID {
+		t.Errorf("Expected file 1 to survive compaction, got %+v", file)
+	}
+}
+
+func TestFile_Algorithm(t *testing.T) {
+	file := File{Checksums: map[string]string{"sha256": "aa", "md5": "bb", "crc99": "cc"}}
+
+	if got := file.Algorithm([]string{"xxh64", "sha256"}); got != "sha256" {
+		t.Errorf("Expected the preferred sha256, got %q", got)
+	}
+	if got := file.Algorithm(nil); got != "md5" {
+		t.Errorf("Expected the first supported algorithm by name, got %q", got)
+	}
+	if got := (File{Checksums: map[string]string{"crc99": "cc"}}).Algorithm(nil); got != "" {
+		t.Errorf("Expected no algorithm when none is supported, got %q", got)
+	}
+}
+
+func TestCatalog_CompactIfStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.jsonl")
+	c := openTestCatalog(t, path)
+	file, err := c.PutFile(File{IngestID: 1, SourcePath: "/card/001.mov"})
+	if err != nil {
+		t.Fatalf("PutFile failed: %v", err)
+	}
+
+	lines := func() int {
+		data, _ := ioutil.ReadFile(path)
+		return strings.Count(string(data), "\n")
+	}
+
+	file.Verified = time.Now()
+	c.PutFile(file)
+	if compacted, err := c.CompactIfStale(); err != nil || compacted {
+		t.Fatalf("Expected no compaction with one replaced record, got %v (%v)", compacted, err)
+	}
+
+	c.PutFile(file)
+	if compacted, err := c.CompactIfStale(); err != nil || !compacted {
+		t.Fatalf("Expected compaction once replaced records outnumber current ones, got %v (%v)", compacted, err)
+	}
+	if n := lines(); n != 1 {
+		t.Errorf("Expected one record left, got %d", n)
+	}
+
+	// Records appended after compacting still land in the file
+	c.PutFile(file)
+	if n := lines(); n != 2 {
+		t.Errorf("Expected appends after compacting, got %d records", n)
+	}
+}
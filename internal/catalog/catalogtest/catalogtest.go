@@ -0,0 +1,108 @@
+// Package catalogtest sets up local destinations and a catalog recording
+// the files on them, for tests of packages checking stored files against
+// the catalog.
+package catalogtest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/autofileingest/internal/catalog"
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/logger"
+)
+
+// Fixture is a catalog of files stored on temporary local destinations
+type Fixture struct {
+	// Roots holds the folder of each destination by name
+	Roots   map[string]string
+	Config  *config.Config
+	Log     *logger.Logger
+	Catalog *catalog.Catalog
+	// CatalogPath is the file Catalog is kept in
+	CatalogPath string
+	// Stored is the modification and completion time of the files added,
+	// two days ago
+	Stored time.Time
+	names  []string
+}
+
+// New creates empty local destinations with the given names, hashed with
+// SHA-256
+func New(t *testing.T, destinations ...string) *Fixture {
+	t.Helper()
+	f := &Fixture{
+		Roots:  map[string]string{},
+		Stored: time.Now().Add(-48 * time.Hour),
+		names:  destinations,
+	}
+	f.Config = &config.Config{
+		Logging:  config.LoggingConfig{ServerLogPath: t.TempDir(), LogLevel: "debug"},
+		Transfer: config.TransferConfig{HashAlgorithms: []string{checksum.SHA256}},
+	}
+	for _, name := range destinations {
+		f.Roots[name] = t.TempDir()
+		f.Config.Destinations = append(f.Config.Destinations, config.DestinationConfig{Name: name, Path: f.Roots[name]})
+	}
+
+	var err error
+	f.Log, err = logger.NewLogger(f.Config)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	t.Cleanup(func() { f.Log.Close() })
+
+	f.CatalogPath = filepath.Join(t.TempDir(), "catalog.jsonl")
+	f.Catalog, err = catalog.Open(f.CatalogPath)
+	if err != nil {
+		t.Fatalf("Failed to open catalog: %v", err)
+	}
+	t.Cleanup(func() { f.Catalog.Close() })
+	return f
+}
+
+// Path returns the local path of a file on a destination
+func (f *Fixture) Path(destination, relPath string) string {
+	return filepath.Join(f.Roots[destination], filepath.FromSlash(relPath))
+}
+
+// Write stores a file on a destination, modified at Stored
+func (f *Fixture) Write(t *testing.T, destination, relPath, content string) {
+	t.Helper()
+	path := f.Path(destination, relPath)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	os.Chtimes(path, f.Stored, f.Stored)
+}
+
+// Add stores a clip on every destination and records it in the catalog
+func (f *Fixture) Add(t *testing.T, relPath, content string) catalog.File {
+	t.Helper()
+	var copies []catalog.Copy
+	for _, name := range f.names {
+		f.Write(t, name, relPath, content)
+		copies = append(copies, catalog.Copy{Destination: name, RelPath: relPath, Location: f.Path(name, relPath)})
+	}
+
+	digests, _ := checksum.Sum(strings.NewReader(content), []string{checksum.SHA256})
+	file, err := f.Catalog.PutFile(catalog.File{
+		IngestID:   1,
+		SourcePath: "/media/card/" + filepath.Base(relPath),
+		Size:       int64(len(content)),
+		Status:     catalog.StatusCopied,
+		Checksums:  digests,
+		Copies:     copies,
+		Completed:  f.Stored,
+	})
+	if err != nil {
+		t.Fatalf("Failed to record %s: %v", relPath, err)
+	}
+	return file
+}
@@ -0,0 +1,162 @@
+package catalog
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IngestQuery selects ingests. Zero fields match every ingest.
+type IngestQuery struct {
+	DeviceIdentity string
+	// Label is a glob matched against the device label
+	Label    string
+	Operator string
+	Result   string
+	// Since and Until bound the start time
+	Since time.Time
+	Until time.Time
+	// Limit caps the number of results, 0 for no limit
+	Limit int
+}
+
+func (q IngestQuery) matches(i Ingest) bool {
+	switch {
+	case q.DeviceIdentity != "" && i.DeviceIdentity != q.DeviceIdentity:
+		return false
+	case q.Label != "" && !globMatch(q.Label, i.Label):
+		return false
+	case q.Operator != "" && !strings.EqualFold(i.Operator, q.Operator):
+		return false
+	case q.Result != "" && i.Result != q.Result:
+		return false
+	case !q.Since.IsZero() && i.Start.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !i.Start.Before(q.Until):
+		return false
+	}
+	return true
+}
+
+// Ingests returns the matching ingests, newest first
+func (c *Catalog) Ingests(q IngestQuery) []Ingest {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var found []Ingest
+	for _, ingest := range c.ingests {
+		if q.matches(ingest) {
+			found = append(found, ingest)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ID > found[j].ID })
+	if q.Limit > 0 && len(found) > q.Limit {
+		found = found[:q.Limit]
+	}
+	return found
+}
+
+// FileQuery selects files. Zero fields match every file.
+type FileQuery struct {
+	IngestID int64
+	Client   string
+	Project  string
+	Camera   string
+	Roll     string
+	// MediaType is video, audio, image, raw, proxy or sidecar
+	MediaType string
+	Status    string
+	// Name is a glob matched against the source file name
+	Name string
+	// Digest matches any recorded hash
+	Digest string
+	// Destination selects files with a copy on the named destination
+	Destination string
+	// Since and Until bound the completion time
+	Since time.Time
+	Until time.Time
+	// Limit caps the number of results, 0 for no limit
+	Limit int
+}
+
+func (q FileQuery) matches(f File) bool {
+	switch {
+	case q.IngestID != 0 && f.IngestID != q.IngestID:
+		return false
+	case q.Client != "" && !strings.EqualFold(f.Client, q.Client):
+		return false
+	case q.Project != "" && !strings.EqualFold(f.Project, q.Project):
+		return false
+	case q.Camera != "" && !strings.EqualFold(f.Camera, q.Camera):
+		return false
+	case q.Roll != "" && !strings.EqualFold(f.Roll, q.Roll):
+		return false
+	case q.MediaType != "" && f.MediaType != q.MediaType:
+		return false
+	case q.Status != "" && f.Status != q.Status:
+		return false
+	case q.Name != "" && !globMatch(q.Name, filepath.Base(f.SourcePath)):
+		return false
+	case q.Digest != "" && !hasDigest(f, q.Digest):
+		return false
+	case q.Destination != "" && !hasCopyOn(f, q.Destination):
+		return false
+	case !q.Since.IsZero() && f.Completed.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !f.Completed.Before(q.Until):
+		return false
+	}
+	return true
+}
+
+// Files returns the matching files in the order they were added
+func (c *Catalog) Files(q FileQuery) []File {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var found []File
+	if q.IngestID != 0 {
+		for _, id := range c.byIngest[q.IngestID] {
+			if file := c.files[id]; q.matches(file) {
+				found = append(found, file)
+			}
+		}
+	} else {
+		for _, file := range c.files {
+			if q.matches(file) {
+				found = append(found, file)
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ID < found[j].ID })
+	if q.Limit > 0 && len(found) > q.Limit {
+		found = found[:q.Limit]
+	}
+	return found
+}
+
+// globMatch matches a glob case-insensitively
+func globMatch(pattern, name string) bool {
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return ok
+}
+
+func hasDigest(f File, digest string) bool {
+	for _, d := range f.Checksums {
+		if strings.EqualFold(d, digest) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasCopyOn(f File, destination string) bool {
+	for _, c := range f.Copies {
+		if c.Destination == destination {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Supported algorithm names, as used in config, logs and MHL manifests
+const (
+	XXH64  = "xxh64"
+	MD5    = "md5"
+	SHA1   = "sha1"
+	SHA256 = "sha256"
+)
+
+// Factory creates a new hash for an algorithm
+type Factory func() hash.Hash
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{
+		XXH64:  func() hash.Hash { return NewXXH64() },
+		MD5:    md5.New,
+		SHA1:   sha1.New,
+		SHA256: sha256.New,
+	}
+)
+
+// Register adds or replaces a hash algorithm
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[strings.ToLower(name)] = factory
+}
+
+// New returns a new hash for the named algorithm
+func New(name string) (hash.Hash, error) {
+	registryMu.RLock()
+	factory, ok := registry[strings.ToLower(name)]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", name)
+	}
+	return factory(), nil
+}
+
+// IsSupported reports whether an algorithm is registered
+func IsSupported(name string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	_, ok := registry[strings.ToLower(name)]
+	return ok
+}
+
+// Supported returns the registered algorithm names in sorted order
+func Supported() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Digests maps algorithm names to lowercase hex digests
+type Digests map[string]string
+
+// String formats digests as "algo:hex" pairs in algorithm order
+func (d Digests) String() string {
+	names := make([]string, 0, len(d))
+	for name := range d {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ":" + d[name]
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Match compares the algorithms both sets have in common. It reports
+// whether any algorithm was compared and whether all compared digests agree.
+func (d Digests) Match(other Digests) (compared, equal bool) {
+	equal = true
+	for name, value := range d {
+		if otherValue, ok := other[name]; ok {
+			compared = true
+			if !strings.EqualFold(value, otherValue) {
+				equal = false
+			}
+		}
+	}
+	return compared, equal
+}
+
+// Set computes several digests over the same data in one pass
+type Set struct {
+	names  []string
+	hashes []hash.Hash
+}
+
+// NewSet creates a Set for the given algorithms
+func NewSet(names []string) (*Set, error) {
+	if len(names) == 0 {
+		names = []string{SHA256}
+	}
+
+	s := &Set{}
+	for _, name := range names {
+		h, err := New(name)
+		if err != nil {
+			return nil, err
+		}
+		s.names = append(s.names, strings.ToLower(name))
+		s.hashes = append(s.hashes, h)
+	}
+	return s, nil
+}
+
+// Write feeds data to every hash in the set
+func (s *Set) Write(p []byte) (int, error) {
+	for _, h := range s.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Writers returns one writer per algorithm so callers can hash concurrently
+func (s *Set) Writers() []io.Writer {
+	writers := make([]io.Writer, len(s.hashes))
+	for i, h := range s.hashes {
+		writers[i] = h
+	}
+	return writers
+}
+
+// Sums returns the current digests
+func (s *Set) Sums() Digests {
+	digests := make(Digests, len(s.hashes))
+	for i, h := range s.hashes {
+		digests[s.names[i]] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+	return digests
+}
+
+// Sum hashes all of r with the given algorithms
+func Sum(r io.Reader, names []string) (Digests, error) {
+	s, err := NewSet(names)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(s, r); err != nil {
+		return nil, err
+	}
+	return s.Sums(), nil
+}
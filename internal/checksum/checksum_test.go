@@ -0,0 +1,115 @@
+package checksum
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestXXH64(t *testing.T) {
+	long := make([]byte, 1000)
+	for i := range long {
+		long[i] = byte((i*7 + 3) % 251)
+	}
+
+	tests := []struct {
+		name     string
+		input    []byte
+		expected string
+	}{
+		{"empty", []byte(""), "ef46db3751d8e999"},
+		{"single byte", []byte("a"), "d24ec4f1a98c6e5b"},
+		{"short", []byte("abc"), "44bc2cf5ad770999"},
+		{"over one stripe", []byte("Nobody inspects the spammish repetition"), "fbcea83c8a378bf1"},
+		{"many stripes", long, "021f7a7424085ea4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewXXH64()
+			h.Write(tt.input)
+			if got := fmt.Sprintf("%x", h.Sum(nil)); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+
+			// Streaming in odd-sized pieces must give the same digest
+			h.Reset()
+			for p := tt.input; len(p) > 0; {
+				n := 13
+				if n > len(p) {
+					n = len(p)
+				}
+				h.Write(p[:n])
+				p = p[n:]
+			}
+			if got := fmt.Sprintf("%x", h.Sum(nil)); got != tt.expected {
+				t.Errorf("Streaming: expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSum_MultipleAlgorithms(t *testing.T) {
+	digests, err := Sum(strings.NewReader("abc"), []string{XXH64, MD5, SHA1, SHA256})
+	if err != nil {
+		t.Fatalf("Sum failed: %v", err)
+	}
+
+	expected := Digests{
+		XXH64:  "44bc2cf5ad770999",
+		MD5:    "900150983cd24fb0d6963f7d28e17f72",
+		SHA1:   "a9993e364706816aba3e25717850c26c9cd0d89d",
+		SHA256: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	}
+	for name, value := range expected {
+		if digests[name] != value {
+			t.Errorf("Expected %s=%s, got %s", name, value, digests[name])
+		}
+	}
+}
+
+func TestNew_Unsupported(t *testing.T) {
+	if _, err := New("crc32"); err == nil {
+		t.Errorf("Expected error for unsupported algorithm")
+	}
+	if IsSupported("crc32") {
+		t.Errorf("crc32 should not be supported")
+	}
+	if !IsSupported("SHA256") {
+		t.Errorf("Algorithm names should be case-insensitive")
+	}
+}
+
+func TestDigests_Match(t *testing.T) {
+	a := Digests{MD5: "abc", XXH64: "def"}
+
+	if compared, equal := a.Match(Digests{MD5: "ABC"}); !compared || !equal {
+		t.Errorf("Expected case-insensitive match on common algorithm")
+	}
+	if compared, equal := a.Match(Digests{XXH64: "000"}); !compared || equal {
+		t.Errorf("Expected mismatch to be reported")
+	}
+	if compared, _ := a.Match(Digests{SHA1: "abc"}); compared {
+		t.Errorf("Expected no comparison without common algorithms")
+	}
+
+	if got := a.String(); got != "md5:abc, xxh64:def" {
+		t.Errorf("Unexpected string form: %s", got)
+	}
+}
+
+func TestSet_Writers(t *testing.T) {
+	s, err := NewSet([]string{MD5, SHA256})
+	if err != nil {
+		t.Fatalf("NewSet failed: %v", err)
+	}
+	for _, w := range s.Writers() {
+		w.Write([]byte("abc"))
+	}
+
+	direct, _ := Sum(bytes.NewReader([]byte("abc")), []string{MD5, SHA256})
+	if compared, equal := s.Sums().Match(direct); !compared || !equal {
+		t.Errorf("Per-writer hashing should match Sum")
+	}
+}
@@ -0,0 +1,132 @@
+package checksum
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+const (
+	prime64_1 uint64 = 11400714785074694791
+	prime64_2 uint64 = 14029467366897019727
+	prime64_3 uint64 = 1609587929392839161
+	prime64_4 uint64 = 9650029242287828579
+	prime64_5 uint64 = 2870177450012600261
+)
+
+// xxh64 is a streaming implementation of XXH64 with a zero seed
+type xxh64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	mem            [32]byte
+	n              int
+}
+
+// NewXXH64 returns a new XXH64 hash. Sum emits the canonical big-endian
+// digest, matching xxhsum and the xxh64 format used in MHL files.
+func NewXXH64() hash.Hash64 {
+	d := &xxh64{}
+	d.Reset()
+	return d
+}
+
+func (d *xxh64) Reset() {
+	var seed uint64
+	d.v1 = seed + prime64_1 + prime64_2
+	d.v2 = seed + prime64_2
+	d.v3 = seed
+	d.v4 = seed - prime64_1
+	d.total = 0
+	d.n = 0
+}
+
+func (d *xxh64) Size() int      { return 8 }
+func (d *xxh64) BlockSize() int { return 32 }
+
+func (d *xxh64) Write(b []byte) (int, error) {
+	length := len(b)
+	d.total += uint64(length)
+
+	// Not enough for a full stripe yet
+	if d.n+len(b) < 32 {
+		d.n += copy(d.mem[d.n:], b)
+		return length, nil
+	}
+
+	// Complete the buffered stripe first
+	if d.n > 0 {
+		c := copy(d.mem[d.n:], b)
+		d.v1 = xxhRound(d.v1, binary.LittleEndian.Uint64(d.mem[0:8]))
+		d.v2 = xxhRound(d.v2, binary.LittleEndian.Uint64(d.mem[8:16]))
+		d.v3 = xxhRound(d.v3, binary.LittleEndian.Uint64(d.mem[16:24]))
+		d.v4 = xxhRound(d.v4, binary.LittleEndian.Uint64(d.mem[24:32]))
+		b = b[c:]
+		d.n = 0
+	}
+
+	for len(b) >= 32 {
+		d.v1 = xxhRound(d.v1, binary.LittleEndian.Uint64(b[0:8]))
+		d.v2 = xxhRound(d.v2, binary.LittleEndian.Uint64(b[8:16]))
+		d.v3 = xxhRound(d.v3, binary.LittleEndian.Uint64(b[16:24]))
+		d.v4 = xxhRound(d.v4, binary.LittleEndian.Uint64(b[24:32]))
+		b = b[32:]
+	}
+
+	d.n = copy(d.mem[:], b)
+	return length, nil
+}
+
+func (d *xxh64) Sum(b []byte) []byte {
+	var out [8]byte
+	binary.BigEndian.PutUint64(out[:], d.Sum64())
+	return append(b, out[:]...)
+}
+
+func (d *xxh64) Sum64() uint64 {
+	var h uint64
+	if d.total >= 32 {
+		h = bits.RotateLeft64(d.v1, 1) + bits.RotateLeft64(d.v2, 7) +
+			bits.RotateLeft64(d.v3, 12) + bits.RotateLeft64(d.v4, 18)
+		h = xxhMergeRound(h, d.v1)
+		h = xxhMergeRound(h, d.v2)
+		h = xxhMergeRound(h, d.v3)
+		h = xxhMergeRound(h, d.v4)
+	} else {
+		h = d.v3 + prime64_5
+	}
+	h += d.total
+
+	p := d.mem[:d.n]
+	for len(p) >= 8 {
+		h ^= xxhRound(0, binary.LittleEndian.Uint64(p))
+		h = bits.RotateLeft64(h, 27)*prime64_1 + prime64_4
+		p = p[8:]
+	}
+	if len(p) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(p)) * prime64_1
+		h = bits.RotateLeft64(h, 23)*prime64_2 + prime64_3
+		p = p[4:]
+	}
+	for _, c := range p {
+		h ^= uint64(c) * prime64_5
+		h = bits.RotateLeft64(h, 11) * prime64_1
+	}
+
+	h ^= h >> 33
+	h *= prime64_2
+	h ^= h >> 29
+	h *= prime64_3
+	h ^= h >> 32
+	return h
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	acc += input * prime64_2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime64_1
+}
+
+func xxhMergeRound(acc, val uint64) uint64 {
+	acc ^= xxhRound(0, val)
+	return acc*prime64_1 + prime64_4
+}
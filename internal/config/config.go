@@ -3,20 +3,293 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/mhl"
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the application configuration
 type Config struct {
-	DestinationPath string          `yaml:"destination_path"`
-	AutoMount       AutoMountConfig `yaml:"auto_mount"`
-	Logging         LoggingConfig   `yaml:"logging"`
-	Transfer        TransferConfig  `yaml:"transfer"`
-	Parsing         ParsingConfig   `yaml:"parsing"`
-	Email           EmailConfig     `yaml:"email"`
-	DeviceDetection DeviceConfig    `yaml:"device_detection"`
-	Performance     PerfConfig      `yaml:"performance"`
+	DestinationPath string              `yaml:"destination_path"`
+	Destinations    []DestinationConfig `yaml:"destinations"`
+	AutoMount       AutoMountConfig     `yaml:"auto_mount"`
+	Logging         LoggingConfig       `yaml:"logging"`
+	Transfer        TransferConfig      `yaml:"transfer"`
+	Parsing         ParsingConfig       `yaml:"parsing"`
+	Email           EmailConfig         `yaml:"email"`
+	DeviceDetection DeviceConfig        `yaml:"device_detection"`
+	Performance     PerfConfig          `yaml:"performance"`
+	MHL             MHLConfig           `yaml:"mhl"`
+
+	// DestinationGuard protects destination_path when no destinations list
+	// is configured
+	DestinationGuard MountGuardConfig `yaml:"destination_guard"`
+	// Permissions applies to local destinations without their own
+	Permissions PermissionsConfig `yaml:"permissions"`
+
+	// StateDir holds the indexes and records kept between ingests
+	StateDir string `yaml:"state_dir"`
+	// Dedup skips files already ingested earlier
+	Dedup DedupConfig `yaml:"dedup"`
+	// Catalog records every ingest and file in a database
+	Catalog CatalogConfig `yaml:"catalog"`
+	// Scrub re-hashes stored files against the catalog
+	Scrub ScrubConfig `yaml:"scrub"`
+	// Reconcile compares the catalog with the destination trees
+	Reconcile ReconcileConfig `yaml:"reconcile"`
+	// Rolls assigns camera roll IDs such as A001 to cards
+	Rolls RollConfig `yaml:"rolls"`
+}
+
+// CatalogConfig enables the ingest catalog
+type CatalogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Path overrides the catalog location within state_dir
+	Path string `yaml:"path"`
+	// Operator is recorded with every ingest
+	Operator string `yaml:"operator"`
+}
+
+// ScrubConfig schedules re-hashing of stored files against the hashes
+// recorded in the catalog
+type ScrubConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval is the number of hours after which a file is checked again
+	Interval int `yaml:"interval"`
+	// Start and End limit scrubbing to a time of day, formatted as HH:MM.
+	// Windows ending before they start wrap past midnight.
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	// Rate caps the bytes read per second, 0 for unlimited
+	Rate int64 `yaml:"rate"`
+	// MaxBytes caps the bytes read per run, 0 for no cap
+	MaxBytes int64 `yaml:"max_bytes"`
+	// Restore replaces missing and corrupted copies from an intact mirror
+	Restore bool `yaml:"restore"`
+}
+
+// ReconcileConfig controls reconciliation of the catalog with files moved
+// or renamed on the destinations
+type ReconcileConfig struct {
+	// AutoRelink updates records of moved files to their new paths
+	AutoRelink bool `yaml:"auto_relink"`
+}
+
+// DefaultScrubInterval checks every file monthly
+const DefaultScrubInterval = 30 * 24
+
+func (s *ScrubConfig) validate(catalog bool) error {
+	if !s.Enabled {
+		return nil
+	}
+	if !catalog {
+		return fmt.Errorf("scrub requires catalog.enabled, which records the hashes")
+	}
+	if s.Interval < 1 {
+		s.Interval = DefaultScrubInterval
+	}
+	if (s.Start == "") != (s.End == "") {
+		return fmt.Errorf("scrub: start and end must be set together")
+	}
+	if s.Start != "" {
+		if _, err := ParseClock(s.Start); err != nil {
+			return fmt.Errorf("scrub: %w", err)
+		}
+		if _, err := ParseClock(s.End); err != nil {
+			return fmt.Errorf("scrub: %w", err)
+		}
+	}
+	if s.Rate < 0 || s.MaxBytes < 0 {
+		return fmt.Errorf("scrub: rate and max_bytes can't be negative")
+	}
+	return nil
+}
+
+// CatalogPath returns the location of the catalog
+func (c *Config) CatalogPath() string {
+	if c.Catalog.Path != "" {
+		return c.Catalog.Path
+	}
+	return filepath.Join(c.stateDir(), "catalog.jsonl")
+}
+
+// RollConfig numbers the cards of each camera as rolls, A001, A002, B001...
+type RollConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Cameras maps parsed camera tokens to roll letters. Other cameras use
+	// the first letter of their token.
+	Cameras map[string]string `yaml:"cameras"`
+	// Digits of the roll number, 3 by default
+	Digits int `yaml:"digits"`
+	// PerDay restarts numbering every shoot day
+	PerDay bool `yaml:"per_day"`
+	// Marker is RollMarkerCard or RollMarkerServer
+	Marker string `yaml:"marker"`
+	// Path overrides the counter file within state_dir
+	Path string `yaml:"path"`
+}
+
+// Roll markers for rolls.marker
+const (
+	// RollMarkerCard writes the roll to a file on the card, falling back
+	// to the server record when the card is read-only
+	RollMarkerCard = "card"
+	// RollMarkerServer only records the roll of a card in state_dir
+	RollMarkerServer = "server"
+)
+
+// DefaultRollDigits gives rolls like A001
+const DefaultRollDigits = 3
+
+func (r *RollConfig) validate() error {
+	if r.Digits < 1 {
+		r.Digits = DefaultRollDigits
+	}
+	switch r.Marker {
+	case "":
+		r.Marker = RollMarkerCard
+	case RollMarkerCard, RollMarkerServer:
+	default:
+		return fmt.Errorf("rolls.marker must be %q or %q", RollMarkerCard, RollMarkerServer)
+	}
+	return nil
+}
+
+// RollsPath returns the location of the roll counters
+func (c *Config) RollsPath() string {
+	if c.Rolls.Path != "" {
+		return c.Rolls.Path
+	}
+	return filepath.Join(c.stateDir(), "rolls.json")
+}
+
+// DefaultStateDir is used when state_dir isn't set
+const DefaultStateDir = "/var/lib/media-ingest"
+
+// DedupConfig enables the content index of every file ever ingested.
+// Files whose content is already on every destination aren't copied again.
+type DedupConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Action is DedupSkip or DedupHardlink
+	Action string `yaml:"action"`
+	// IndexPath overrides the index location within state_dir
+	IndexPath string `yaml:"index_path"`
+}
+
+// Dedup actions for dedup.action
+const (
+	// DedupSkip leaves duplicates out of the ingest
+	DedupSkip = "skip"
+	// DedupHardlink links the planned path to the existing copy on local
+	// destinations, so the clip also appears where this ingest would put it
+	DedupHardlink = "hardlink"
+)
+
+// DedupIndexPath returns the location of the dedup index
+func (c *Config) DedupIndexPath() string {
+	if c.Dedup.IndexPath != "" {
+		return c.Dedup.IndexPath
+	}
+	return filepath.Join(c.stateDir(), "dedup-index.jsonl")
+}
+
+// CardRecordsDir returns the folder holding what was copied from each
+// card, for incremental ingests
+func (c *Config) CardRecordsDir() string {
+	return filepath.Join(c.stateDir(), "cards")
+}
+
+// stateDir returns state_dir or its default
+func (c *Config) stateDir() string {
+	if c.StateDir == "" {
+		return DefaultStateDir
+	}
+	return c.StateDir
+}
+
+// DestinationConfig describes one copy target. Every file is written to all
+// destinations from a single read of the source.
+type DestinationConfig struct {
+	Name string `yaml:"name"`
+	// Type selects the storage backend; defaults to DestinationLocal
+	Type            string `yaml:"type"`
+	Path            string `yaml:"path"`
+	FolderStructure string `yaml:"folder_structure"`
+	UnmatchedFolder string `yaml:"unmatched_folder"`
+	// Prefix is prepended to every file path and may use the parser tokens
+	Prefix string       `yaml:"prefix"`
+	S3     S3Config     `yaml:"s3"`
+	SFTP   SFTPConfig   `yaml:"sftp"`
+	WebDAV WebDAVConfig `yaml:"webdav"`
+	// Guard refuses ingests while a local destination isn't mounted
+	Guard MountGuardConfig `yaml:"guard"`
+	// Permissions sets ownership and modes of created files and folders
+	Permissions PermissionsConfig `yaml:"permissions"`
+}
+
+// PermissionsConfig sets the ownership and modes of files and folders
+// created on a local destination. Unset fields leave the system defaults,
+// including default ACLs, alone.
+type PermissionsConfig struct {
+	// Owner and Group are names or numeric ids
+	Owner string `yaml:"owner"`
+	Group string `yaml:"group"`
+	// Umask is an octal mask such as "0002" applied to files (0666) and
+	// folders (0777)
+	Umask string `yaml:"umask"`
+}
+
+// UmaskBits returns the parsed umask and whether one is set
+func (p PermissionsConfig) UmaskBits() (os.FileMode, bool) {
+	if p.Umask == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(p.Umask, 8, 32)
+	if err != nil || v > 0777 {
+		return 0, false
+	}
+	return os.FileMode(v), true
+}
+
+func (p PermissionsConfig) validate() error {
+	if _, ok := p.UmaskBits(); p.Umask != "" && !ok {
+		return fmt.Errorf("permissions.umask must be an octal mask such as 0002, got %q", p.Umask)
+	}
+	return nil
+}
+
+// MountGuardConfig describes how to tell that a local destination is the
+// intended storage and not an empty folder left by a failed mount
+type MountGuardConfig struct {
+	// RequireMount requires the destination path to be a mount point
+	RequireMount bool `yaml:"require_mount"`
+	// FilesystemUUID requires the path to be on the filesystem with this UUID
+	FilesystemUUID string `yaml:"filesystem_uuid"`
+	// Sentinel is a file, relative to the destination path, that must exist
+	Sentinel string `yaml:"sentinel"`
+}
+
+// Enabled reports whether any check is configured
+func (g MountGuardConfig) Enabled() bool {
+	return g.RequireMount || g.FilesystemUUID != "" || g.Sentinel != ""
+}
+
+// S3Config configures an S3-compatible object storage destination
+type S3Config struct {
+	Endpoint     string `yaml:"endpoint"`
+	Region       string `yaml:"region"`
+	Bucket       string `yaml:"bucket"`
+	AccessKey    string `yaml:"access_key"`
+	SecretKey    string `yaml:"secret_key"`
+	SessionToken string `yaml:"session_token"`
+	// PathStyle addresses buckets as endpoint/bucket instead of bucket.endpoint
+	PathStyle bool  `yaml:"path_style"`
+	PartSize  int64 `yaml:"part_size"`
 }
 
 type AutoMountConfig struct {
@@ -37,25 +310,226 @@ type TransferConfig struct {
 	VerifyChecksums  bool     `yaml:"verify_checksums"`
 	MaxRetries       int      `yaml:"max_retries"`
 	PriorityPrefixes []string `yaml:"priority_prefixes"`
+	FastCopy         bool     `yaml:"fast_copy"`
+	CacheHints       bool     `yaml:"cache_hints"`
+
+	// AdaptiveWorkers measures throughput at the start of an ingest and
+	// settles on the fastest worker count up to MaxWorkers
+	AdaptiveWorkers bool `yaml:"adaptive_workers"`
+	// ReadOrder is the order files are read in within each priority class
+	ReadOrder string `yaml:"read_order"`
+	// PriorityRules give files numeric priorities; higher goes first
+	PriorityRules []PriorityRuleConfig `yaml:"priority_rules"`
+	// NewestFirst reads the most recently recorded files of each priority first
+	NewestFirst bool `yaml:"newest_first"`
+
+	VerifyMode         string `yaml:"verify_mode"`
+	VerifyDirectIO     bool   `yaml:"verify_direct_io"`
+	VerifySourceReread bool   `yaml:"verify_source_reread"`
+
+	// HashAlgorithms lists the digests computed per file; the first one is
+	// used for verification
+	HashAlgorithms []string `yaml:"hash_algorithms"`
+
+	// SpaceReserve is the number of bytes left free on every destination
+	SpaceReserve int64 `yaml:"space_reserve"`
+	// WhenFull decides what happens to an ingest that doesn't fit
+	WhenFull string `yaml:"when_full"`
+	// SpaceCheckInterval is how often, in seconds, free space is checked
+	// again while an ingest waits for room
+	SpaceCheckInterval int `yaml:"space_check_interval"`
+	// SpaceWait is how long, in seconds, a queued or paused ingest waits
+	// for space before giving up
+	SpaceWait int `yaml:"space_wait"`
+
+	// PreserveMode copies the source permission bits to local copies
+	PreserveMode bool `yaml:"preserve_mode"`
+	// PreserveXattrs copies user extended attributes to local copies
+	PreserveXattrs bool `yaml:"preserve_xattrs"`
+
+	// Throttle limits the bandwidth used by ingests
+	Throttle ThrottleConfig `yaml:"throttle"`
+
+	// Incremental copies only the files added or changed on a card since
+	// it was last ingested
+	Incremental bool `yaml:"incremental"`
+}
+
+// ThrottleConfig limits transfer bandwidth. Rates are in bytes per second
+// and 0 means unlimited. Rate is shared by all devices; Devices limits
+// each matching device on top of that.
+type ThrottleConfig struct {
+	Rate     int64                  `yaml:"rate"`
+	Schedule []ThrottleWindow       `yaml:"schedule"`
+	Devices  []DeviceThrottleConfig `yaml:"devices"`
+}
+
+// ThrottleWindow replaces the rate between two times of day, such as full
+// speed overnight. Windows ending before they start wrap past midnight.
+type ThrottleWindow struct {
+	// Start and End are local times formatted as HH:MM
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	Rate  int64  `yaml:"rate"`
+}
+
+// DeviceThrottleConfig limits devices whose name or label matches a glob
+type DeviceThrottleConfig struct {
+	Match    string           `yaml:"match"`
+	Rate     int64            `yaml:"rate"`
+	Schedule []ThrottleWindow `yaml:"schedule"`
+}
+
+// ParseClock parses an HH:MM time of day into minutes after midnight
+func ParseClock(s string) (int, error) {
+	var hour, minute int
+	if n, err := fmt.Sscanf(s, "%d:%d", &hour, &minute); err != nil || n != 2 {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return hour*60 + minute, nil
+}
+
+func (t ThrottleConfig) validate() error {
+	if err := validateSchedule("transfer.throttle", t.Rate, t.Schedule); err != nil {
+		return err
+	}
+	for i, dev := range t.Devices {
+		name := fmt.Sprintf("transfer.throttle.devices[%d]", i)
+		if dev.Match == "" {
+			return fmt.Errorf("%s: match is required", name)
+		}
+		if _, err := filepath.Match(dev.Match, ""); err != nil {
+			return fmt.Errorf("%s: invalid match %q: %w", name, dev.Match, err)
+		}
+		if err := validateSchedule(name, dev.Rate, dev.Schedule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateSchedule(name string, rate int64, schedule []ThrottleWindow) error {
+	if rate < 0 {
+		return fmt.Errorf("%s: rate can't be negative", name)
+	}
+	for i, w := range schedule {
+		if w.Rate < 0 {
+			return fmt.Errorf("%s.schedule[%d]: rate can't be negative", name, i)
+		}
+		if _, err := ParseClock(w.Start); err != nil {
+			return fmt.Errorf("%s.schedule[%d]: %w", name, i, err)
+		}
+		if _, err := ParseClock(w.End); err != nil {
+			return fmt.Errorf("%s.schedule[%d]: %w", name, i, err)
+		}
+	}
+	return nil
+}
+
+// Verification modes for transfer.verify_mode
+const (
+	// VerifyModeCache hashes the destination through the still-open file,
+	// which is fast but usually served from the page cache
+	VerifyModeCache = "cache"
+	// VerifyModeReadback flushes the destination, drops its cached pages
+	// and re-reads it from storage
+	VerifyModeReadback = "readback"
+)
+
+// PriorityRuleConfig gives a priority to files meeting every condition
+// set. The first matching rule wins; files matching none get priority 0.
+type PriorityRuleConfig struct {
+	Priority int `yaml:"priority"`
+	// Extensions such as ".wav", compared case-insensitively
+	Extensions []string `yaml:"extensions"`
+	// Glob is matched against the file name
+	Glob string `yaml:"glob"`
+	// Regex is matched against the full source path
+	Regex string `yaml:"regex"`
+	// MinSize and MaxSize bound the file size in bytes, 0 for no bound
+	MinSize int64 `yaml:"min_size"`
+	MaxSize int64 `yaml:"max_size"`
+	// MediaType is one of MediaTypes
+	MediaType string `yaml:"media_type"`
+	// Camera is compared with the camera parsed from the file name
+	Camera string `yaml:"camera"`
+}
+
+// MediaTypes lists the values of priority_rules media_type
+var MediaTypes = []string{"video", "audio", "image", "raw", "proxy", "sidecar"}
+
+func (r PriorityRuleConfig) validate() error {
+	if r.Glob != "" {
+		if _, err := filepath.Match(r.Glob, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", r.Glob, err)
+		}
+	}
+	if r.Regex != "" {
+		if _, err := regexp.Compile(r.Regex); err != nil {
+			return fmt.Errorf("invalid regex %q: %w", r.Regex, err)
+		}
+	}
+	if r.MinSize < 0 || r.MaxSize < 0 || (r.MaxSize > 0 && r.MaxSize < r.MinSize) {
+		return fmt.Errorf("invalid size range %d-%d", r.MinSize, r.MaxSize)
+	}
+	if r.MediaType != "" {
+		for _, t := range MediaTypes {
+			if strings.EqualFold(r.MediaType, t) {
+				return nil
+			}
+		}
+		return fmt.Errorf("media_type must be one of %s", strings.Join(MediaTypes, ", "))
+	}
+	return nil
 }
 
+// Read orders for transfer.read_order
+const (
+	// ReadOrderScan reads files in the order the scan found them
+	ReadOrderScan = "scan"
+	// ReadOrderPath reads files sorted by path
+	ReadOrderPath = "path"
+	// ReadOrderPhysical reads files by their position on the source disk,
+	// falling back to path order where that's unknown
+	ReadOrderPhysical = "physical"
+)
+
+// Actions for transfer.when_full
+const (
+	// WhenFullRefuse fails an ingest that doesn't fit before copying anything
+	WhenFullRefuse = "refuse"
+	// WhenFullQueue holds an ingest until enough space has been freed
+	WhenFullQueue = "queue"
+)
+
+// DefaultSpaceCheckInterval is used when transfer.space_check_interval is unset
+const DefaultSpaceCheckInterval = 30
+
+// DefaultSpaceWait is used when transfer.space_wait is unset
+const DefaultSpaceWait = 4 * 60 * 60
+
 type ParsingConfig struct {
 	Pattern         string `yaml:"pattern"`
 	FolderStructure string `yaml:"folder_structure"`
 	UnmatchedFolder string `yaml:"unmatched_folder"`
+	// GroupSpans keeps the segments of spanned clips together
+	GroupSpans bool `yaml:"group_spans"`
 }
 
 type EmailConfig struct {
-	Enabled    bool     `yaml:"enabled"`
-	SMTPHost   string   `yaml:"smtp_host"`
-	SMTPPort   int      `yaml:"smtp_port"`
-	UseTLS     bool     `yaml:"use_tls"`
-	Username   string   `yaml:"username"`
-	Password   string   `yaml:"password"`
-	From       string   `yaml:"from"`
-	To         []string `yaml:"to"`
-	Subject    string   `yaml:"subject"`
-	AttachLog  bool     `yaml:"attach_log"`
+	Enabled   bool     `yaml:"enabled"`
+	SMTPHost  string   `yaml:"smtp_host"`
+	SMTPPort  int      `yaml:"smtp_port"`
+	UseTLS    bool     `yaml:"use_tls"`
+	Username  string   `yaml:"username"`
+	Password  string   `yaml:"password"`
+	From      string   `yaml:"from"`
+	To        []string `yaml:"to"`
+	Subject   string   `yaml:"subject"`
+	AttachLog bool     `yaml:"attach_log"`
 }
 
 type DeviceConfig struct {
@@ -71,6 +545,21 @@ type PerfConfig struct {
 	ColoredOutput    bool `yaml:"colored_output"`
 }
 
+type MHLConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Format       string `yaml:"format"`
+	CreatorName  string `yaml:"creator_name"`
+	CreatorEmail string `yaml:"creator_email"`
+	CreatorRole  string `yaml:"creator_role"`
+	Location     string `yaml:"location"`
+	Comment      string `yaml:"comment"`
+
+	// ExtendSourceHistory appends a verification generation to ascmhl
+	// histories found on the source media. It writes to the card, so it
+	// needs Enabled.
+	ExtendSourceHistory bool `yaml:"extend_source_history"`
+}
+
 // Load reads and parses the configuration file
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -93,10 +582,17 @@ func Load(path string) (*Config, error) {
 
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
-	if c.DestinationPath == "" {
+	if c.DestinationPath == "" && len(c.Destinations) == 0 {
 		return fmt.Errorf("destination_path is required")
 	}
 
+	if err := c.validateDestinations(); err != nil {
+		return err
+	}
+	if err := c.Permissions.validate(); err != nil {
+		return err
+	}
+
 	if c.Transfer.MaxWorkers < 1 {
 		c.Transfer.MaxWorkers = 1
 	}
@@ -105,6 +601,87 @@ func (c *Config) Validate() error {
 		c.Transfer.BufferSize = 1048576 // 1MB default
 	}
 
+	switch c.Transfer.VerifyMode {
+	case "":
+		c.Transfer.VerifyMode = VerifyModeCache
+	case VerifyModeCache, VerifyModeReadback:
+	default:
+		return fmt.Errorf("transfer.verify_mode must be %q or %q", VerifyModeCache, VerifyModeReadback)
+	}
+
+	switch c.Transfer.ReadOrder {
+	case "":
+		c.Transfer.ReadOrder = ReadOrderScan
+	case ReadOrderScan, ReadOrderPath, ReadOrderPhysical:
+	default:
+		return fmt.Errorf("transfer.read_order must be %q, %q or %q", ReadOrderScan, ReadOrderPath, ReadOrderPhysical)
+	}
+
+	switch c.Transfer.WhenFull {
+	case "":
+		c.Transfer.WhenFull = WhenFullRefuse
+	case WhenFullRefuse, WhenFullQueue:
+	default:
+		return fmt.Errorf("transfer.when_full must be %q or %q", WhenFullRefuse, WhenFullQueue)
+	}
+
+	if c.Transfer.SpaceReserve < 0 {
+		return fmt.Errorf("transfer.space_reserve can't be negative")
+	}
+	if c.Transfer.SpaceCheckInterval < 1 {
+		c.Transfer.SpaceCheckInterval = DefaultSpaceCheckInterval
+	}
+	if c.Transfer.SpaceWait < 1 {
+		c.Transfer.SpaceWait = DefaultSpaceWait
+	}
+
+	for i, rule := range c.Transfer.PriorityRules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("transfer.priority_rules[%d]: %w", i, err)
+		}
+	}
+
+	if err := c.Transfer.Throttle.validate(); err != nil {
+		return err
+	}
+
+	if len(c.Transfer.HashAlgorithms) == 0 {
+		c.Transfer.HashAlgorithms = []string{checksum.SHA256}
+	}
+	for i, name := range c.Transfer.HashAlgorithms {
+		if !checksum.IsSupported(name) {
+			return fmt.Errorf("transfer.hash_algorithms: unsupported algorithm %q (supported: %s)",
+				name, strings.Join(checksum.Supported(), ", "))
+		}
+		c.Transfer.HashAlgorithms[i] = strings.ToLower(name)
+	}
+
+	if c.StateDir == "" {
+		c.StateDir = DefaultStateDir
+	}
+	switch c.Dedup.Action {
+	case "":
+		c.Dedup.Action = DedupSkip
+	case DedupSkip, DedupHardlink:
+	default:
+		return fmt.Errorf("dedup.action must be %q or %q", DedupSkip, DedupHardlink)
+	}
+
+	if err := c.Scrub.validate(c.Catalog.Enabled); err != nil {
+		return err
+	}
+	if err := c.Rolls.validate(); err != nil {
+		return err
+	}
+
+	if c.MHL.Enabled {
+		if err := c.validateMHL(); err != nil {
+			return err
+		}
+	} else if c.MHL.ExtendSourceHistory {
+		return fmt.Errorf("mhl.extend_source_history requires mhl.enabled")
+	}
+
 	if c.Parsing.Pattern == "" {
 		return fmt.Errorf("parsing.pattern is required")
 	}
@@ -120,3 +697,191 @@ func (c *Config) Validate() error {
 
 	return nil
 }
+
+// validateMHL checks that manifests can be generated from the configured hashes
+func (c *Config) validateMHL() error {
+	switch c.MHL.Format {
+	case "":
+		c.MHL.Format = mhl.FormatASC
+	case mhl.FormatASC, mhl.FormatLegacy:
+	default:
+		return fmt.Errorf("mhl.format must be %q or %q", mhl.FormatASC, mhl.FormatLegacy)
+	}
+
+	if !c.Transfer.VerifyChecksums {
+		return fmt.Errorf("mhl is enabled but transfer.verify_checksums is off")
+	}
+
+	for _, name := range c.Transfer.HashAlgorithms {
+		for _, supported := range mhl.SupportedAlgorithms {
+			if name == supported {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("mhl requires one of these transfer.hash_algorithms: %s",
+		strings.Join(mhl.SupportedAlgorithms, ", "))
+}
+
+// validateDestinations checks the destinations list and keeps
+// destination_path pointing at the primary destination
+func (c *Config) validateDestinations() error {
+	seen := map[string]bool{}
+	for i := range c.Destinations {
+		dest := &c.Destinations[i]
+		if dest.Name == "" {
+			dest.Name = fmt.Sprintf("destination%d", i+1)
+		}
+		if seen[dest.Name] {
+			return fmt.Errorf("destinations: duplicate name %q", dest.Name)
+		}
+		seen[dest.Name] = true
+
+		dest.Type = strings.ToLower(dest.Type)
+		switch dest.Type {
+		case "", DestinationLocal:
+			dest.Type = DestinationLocal
+			if dest.Path == "" {
+				return fmt.Errorf("destinations[%d]: path is required", i)
+			}
+			if c.DestinationPath == "" {
+				c.DestinationPath = dest.Path
+			}
+		case DestinationS3:
+			if err := dest.S3.validate(); err != nil {
+				return fmt.Errorf("destinations[%d]: %w", i, err)
+			}
+		case DestinationWebDAV:
+			if dest.WebDAV.URL == "" {
+				return fmt.Errorf("destinations[%d]: webdav.url is required", i)
+			}
+		case DestinationSFTP:
+			if dest.Path == "" {
+				return fmt.Errorf("destinations[%d]: path is required", i)
+			}
+			if err := dest.SFTP.validate(); err != nil {
+				return fmt.Errorf("destinations[%d]: %w", i, err)
+			}
+		}
+
+		switch dest.Type {
+		case DestinationS3, DestinationWebDAV, DestinationSFTP:
+			if dest.Guard.Enabled() {
+				return fmt.Errorf("destinations[%d]: guard only applies to local destinations", i)
+			}
+			if dest.Permissions != (PermissionsConfig{}) {
+				return fmt.Errorf("destinations[%d]: permissions only apply to local destinations", i)
+			}
+		}
+		if err := dest.Permissions.validate(); err != nil {
+			return fmt.Errorf("destinations[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// SFTPConfig configures an SFTP destination. Path is the remote folder.
+// Connections use the system ssh client with key authentication only.
+type SFTPConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	User string `yaml:"user"`
+	// KeyFile is the private key used to log in
+	KeyFile string `yaml:"key_file"`
+	// KnownHostsFile must list the host key; unknown hosts are refused
+	KnownHostsFile string `yaml:"known_hosts"`
+}
+
+// validate checks an SFTP destination and fills in defaults
+func (s *SFTPConfig) validate() error {
+	if s.Host == "" {
+		return fmt.Errorf("sftp.host is required")
+	}
+	if s.User == "" {
+		return fmt.Errorf("sftp.user is required")
+	}
+	if s.KeyFile == "" {
+		return fmt.Errorf("sftp.key_file is required")
+	}
+	if s.Port == 0 {
+		s.Port = 22
+	}
+	return nil
+}
+
+// WebDAVConfig configures a WebDAV destination
+type WebDAVConfig struct {
+	// URL is the collection files are uploaded into
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Minimum and default part sizes for S3 multipart uploads
+const (
+	S3MinPartSize     = 5 * 1024 * 1024
+	S3DefaultPartSize = 16 * 1024 * 1024
+)
+
+// validate checks an S3 destination and fills in defaults
+func (s *S3Config) validate() error {
+	if s.Bucket == "" {
+		return fmt.Errorf("s3.bucket is required")
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.Endpoint == "" {
+		s.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.Region)
+	}
+	if s.PartSize == 0 {
+		s.PartSize = S3DefaultPartSize
+	}
+	if s.PartSize < S3MinPartSize {
+		return fmt.Errorf("s3.part_size must be at least %d bytes", S3MinPartSize)
+	}
+	return nil
+}
+
+// Destination types
+const (
+	// DestinationLocal is a local or mounted folder
+	DestinationLocal = "local"
+	// DestinationS3 is a bucket on any S3-compatible object storage
+	DestinationS3 = "s3"
+	// DestinationSFTP is a folder on a host reachable over SSH
+	DestinationSFTP = "sftp"
+	// DestinationWebDAV is a collection on a WebDAV server
+	DestinationWebDAV = "webdav"
+)
+
+// GetDestinations returns every destination with defaults applied. Without a
+// destinations list, destination_path is the single "primary" destination.
+func (c *Config) GetDestinations() []DestinationConfig {
+	destinations := c.Destinations
+	if len(destinations) == 0 {
+		destinations = []DestinationConfig{{
+			Name:  "primary",
+			Path:  c.DestinationPath,
+			Guard: c.DestinationGuard,
+		}}
+	}
+
+	resolved := make([]DestinationConfig, len(destinations))
+	for i, dest := range destinations {
+		if dest.Type == "" {
+			dest.Type = DestinationLocal
+		}
+		if dest.FolderStructure == "" {
+			dest.FolderStructure = c.Parsing.FolderStructure
+		}
+		if dest.UnmatchedFolder == "" {
+			dest.UnmatchedFolder = c.Parsing.UnmatchedFolder
+		}
+		if dest.Type == DestinationLocal && dest.Permissions == (PermissionsConfig{}) {
+			dest.Permissions = c.Permissions
+		}
+		resolved[i] = dest
+	}
+	return resolved
+}
@@ -0,0 +1,187 @@
+// Package dedup keeps a persistent index of the content of every file ever
+// ingested, so cards that are inserted again aren't copied twice.
+package dedup
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/autofileingest/internal/checksum"
+)
+
+// Algorithm is the hash identifying file content in the index
+const Algorithm = checksum.SHA256
+
+// prehashBlock is the amount hashed from each end of a file by Prehash
+const prehashBlock = 64 << 10
+
+// Entry records one ingested file and where its copies were written
+type Entry struct {
+	Size    int64  `json:"size"`
+	Prehash string `json:"prehash"`
+	// Digest is the Algorithm hash of the whole file
+	Digest   string    `json:"digest"`
+	Source   string    `json:"source"`
+	Copies   []Copy    `json:"copies"`
+	Ingested time.Time `json:"ingested"`
+}
+
+// Copy is the location of a file on one destination
+type Copy struct {
+	Destination string `json:"destination"`
+	// RelPath is the slash-separated path within the destination
+	RelPath string `json:"rel_path"`
+	// Location is the path or URL shown in logs and reports
+	Location string `json:"location"`
+}
+
+// key groups entries that may have the same content
+type key struct {
+	size    int64
+	prehash string
+}
+
+// Index is an append-only file of entries, one JSON object per line,
+// loaded into memory when opened
+type Index struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries map[key][]Entry
+	count   int
+}
+
+// Open loads the index at path, creating it if needed. Lines that can't
+// be parsed, such as one cut short by a crash, are ignored.
+func Open(path string) (*Index, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create index folder: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{file: f, entries: map[key][]Entry{}}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Digest == "" {
+			continue
+		}
+		idx.insert(e)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read index %s: %w", path, err)
+	}
+
+	// Start a fresh line if the last write was cut short
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			f.Write([]byte("\n"))
+		}
+	}
+	return idx, nil
+}
+
+// Close closes the index file
+func (idx *Index) Close() error {
+	return idx.file.Close()
+}
+
+// Len returns the number of entries
+func (idx *Index) Len() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.count
+}
+
+// Lookup returns the entries with a given size and prehash, newest first.
+// Their digests still have to be compared to confirm a match.
+func (idx *Index) Lookup(size int64, prehash string) []Entry {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	found := idx.entries[key{size, prehash}]
+	matches := make([]Entry, len(found))
+	for i, e := range found {
+		matches[len(found)-1-i] = e
+	}
+	return matches
+}
+
+// Add appends an entry to the index
+func (idx *Index) Add(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if _, err := idx.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	idx.insert(e)
+	return nil
+}
+
+func (idx *Index) insert(e Entry) {
+	k := key{e.Size, e.Prehash}
+	idx.entries[k] = append(idx.entries[k], e)
+	idx.count++
+}
+
+// Prehash hashes the size and the first and last 64KiB of a file, which
+// tells most different files apart without reading them whole
+func Prehash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := checksum.NewXXH64()
+	fmt.Fprintf(h, "%d:", info.Size())
+	if _, err := io.CopyN(h, f, prehashBlock); err != nil && err != io.EOF {
+		return "", err
+	}
+	if tail := info.Size() - prehashBlock; tail > prehashBlock {
+		if _, err := io.Copy(h, io.NewSectionReader(f, tail, prehashBlock)); err != nil {
+			return "", err
+		}
+	} else if tail > 0 {
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%016x", h.Sum64()), nil
+}
+
+// Digest hashes a whole file with Algorithm
+func Digest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	digests, err := checksum.Sum(f, []string{Algorithm})
+	if err != nil {
+		return "", err
+	}
+	return digests[Algorithm], nil
+}
@@ -0,0 +1,111 @@
+package dedup
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndex_PersistsEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "dedup-index.jsonl")
+
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	entry := Entry{
+		Size:     42,
+		Prehash:  "00000000deadbeef",
+		Digest:   "abc",
+		Source:   "/media/card/A001.mov",
+		Copies:   []Copy{{Destination: "primary", RelPath: "Nike/Ad/ACam/A001.mov", Location: "/mnt/raid/Nike/Ad/ACam/A001.mov"}},
+		Ingested: time.Now(),
+	}
+	if err := idx.Add(entry); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	idx.Close()
+
+	// Simulate a crash in the middle of the next write
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"size":7,"prehash":"`)
+	f.Close()
+
+	idx, err = Open(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if idx.Len() != 1 {
+		t.Fatalf("Expected 1 entry after reopening, got %d", idx.Len())
+	}
+	if err := idx.Add(Entry{Size: 7, Prehash: "p", Digest: "d"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	idx.Close()
+
+	idx, err = Open(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer idx.Close()
+
+	found := idx.Lookup(42, "00000000deadbeef")
+	if len(found) != 1 || found[0].Copies[0].Location != entry.Copies[0].Location {
+		t.Errorf("Expected the stored entry, got %+v", found)
+	}
+	if len(idx.Lookup(7, "p")) != 1 {
+		t.Error("Expected the entry written after the truncated line to survive")
+	}
+	if len(idx.Lookup(42, "other")) != 0 {
+		t.Error("Expected no entries for a different prehash")
+	}
+}
+
+func TestPrehash(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return path
+	}
+
+	large := bytes.Repeat([]byte("x"), 3*prehashBlock)
+	changedEnd := append([]byte{}, large...)
+	changedEnd[len(changedEnd)-1] = 'y'
+	changedMiddle := append([]byte{}, large...)
+	changedMiddle[len(changedMiddle)/2] = 'y'
+
+	sum := func(path string) string {
+		h, err := Prehash(path)
+		if err != nil {
+			t.Fatalf("Prehash failed: %v", err)
+		}
+		return h
+	}
+
+	base := sum(write("large", large))
+	if sum(write("copy", large)) != base {
+		t.Error("Expected identical files to have the same prehash")
+	}
+	if sum(write("end", changedEnd)) == base {
+		t.Error("Expected a change in the last block to change the prehash")
+	}
+	// Only a full hash tells these apart
+	if sum(write("middle", changedMiddle)) != base {
+		t.Error("Expected the middle of the file to be left out of the prehash")
+	}
+	if sum(write("short", large[:100])) == sum(write("shorter", large[:99])) {
+		t.Error("Expected the size to be part of the prehash")
+	}
+
+	d1, _ := Digest(filepath.Join(dir, "large"))
+	d2, _ := Digest(filepath.Join(dir, "middle"))
+	if d1 == "" || d1 == d2 {
+		t.Errorf("Expected different full digests, got %q and %q", d1, d2)
+	}
+}
@@ -0,0 +1,192 @@
+package device
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/autofileingest/internal/catalog"
+	"github.com/autofileingest/internal/email"
+	"github.com/autofileingest/internal/reconcile"
+	"github.com/autofileingest/internal/scrub"
+	"github.com/autofileingest/internal/transfer"
+)
+
+// Catalog returns the ingest catalog, or nil when it's disabled
+func (m *Manager) Catalog() *catalog.Catalog {
+	return m.catalog
+}
+
+// StartScrubber starts checking stored files against the catalog in the
+// background when scrub is enabled. Problems are emailed.
+func (m *Manager) StartScrubber() {
+	if !m.config.Scrub.Enabled || m.catalog == nil || m.scrubber != nil {
+		return
+	}
+
+	notifier := email.NewNotifier(m.config)
+	m.scrubber = scrub.New(m.config, m.catalog, m.logger)
+	m.scrubber.Start(scrubCheckInterval, func(report scrub.Report) {
+		if err := notifier.SendScrubReport(report); err != nil {
+			m.logger.Error("Failed to send scrub report: %v", err)
+		}
+	})
+}
+
+// scrubCheckInterval is how often the scrubber looks for due files
+const scrubCheckInterval = 10 * time.Minute
+
+// Reconcile compares the catalog with the files on the local destinations,
+// relinking moved files when reconcile.auto_relink is set
+func (m *Manager) Reconcile() (reconcile.Report, error) {
+	if m.catalog == nil {
+		return reconcile.Report{}, fmt.Errorf("reconcile requires catalog.enabled")
+	}
+	report, err := reconcile.Run(m.config, m.catalog, m.logger, m.config.Reconcile.AutoRelink)
+	if err != nil {
+		m.logger.Error("Reconciliation failed: %v", err)
+		return report, err
+	}
+	return report, nil
+}
+
+// Close stops the scrubber and releases the catalog
+func (m *Manager) Close() error {
+	if m.scrubber != nil {
+		m.scrubber.Stop()
+		m.scrubber = nil
+	}
+	if m.catalog == nil {
+		return nil
+	}
+	return m.catalog.Close()
+}
+
+// beginIngest records the start of an ingest in the catalog
+func (m *Manager) beginIngest(device *Device) catalog.Ingest {
+	ingest := catalog.Ingest{
+		DeviceName:     device.Name,
+		DeviceIdentity: device.Identity(),
+		Label:          device.Label,
+		Operator:       m.config.Catalog.Operator,
+		Start:          time.Now(),
+		Result:         catalog.ResultRunning,
+	}
+	if m.catalog == nil {
+		return ingest
+	}
+
+	ingest, err := m.catalog.PutIngest(ingest)
+	if err != nil {
+		m.logger.DeviceError(device.Name, "Failed to record ingest in catalog: %v", err)
+	}
+	return ingest
+}
+
+// finishIngest records the files and result of an ingest in the catalog.
+// transferMgr is nil when the ingest ended before any transfer started.
+func (m *Manager) finishIngest(device *Device, ingest catalog.Ingest, transferMgr *transfer.Manager, ingestErr error) {
+	if m.catalog == nil || ingest.ID == 0 {
+		return
+	}
+
+	if transferMgr != nil {
+		stats := transferMgr.GetStats()
+		for _, t := range transferMgr.GetProcessedTransfers() {
+			m.putCatalogFile(device, catalogFile(ingest.ID, t))
+		}
+		for _, skipped := range stats.Skipped {
+			m.putCatalogFile(device, skippedFile(ingest.ID, skipped))
+		}
+
+		ingest.Files = stats.ProcessedFiles - stats.FailedFiles
+		ingest.FailedFiles = stats.FailedFiles
+		ingest.SkippedFiles = stats.SkippedFiles
+		ingest.Bytes = stats.TransferredBytes
+	}
+
+	ingest.End = time.Now()
+	switch {
+	case ingestErr != nil:
+		ingest.Result = catalog.ResultFailed
+		ingest.Error = ingestErr.Error()
+	case ingest.FailedFiles > 0:
+		ingest.Result = catalog.ResultPartial
+	default:
+		ingest.Result = catalog.ResultSuccess
+	}
+
+	if _, err := m.catalog.PutIngest(ingest); err != nil {
+		m.logger.DeviceError(device.Name, "Failed to record ingest in catalog: %v", err)
+	}
+}
+
+func (m *Manager) putCatalogFile(device *Device, file catalog.File) {
+	if _, err := m.catalog.PutFile(file); err != nil {
+		m.logger.DeviceError(device.Name, "Failed to record %s in catalog: %v", file.SourcePath, err)
+	}
+}
+
+// skippedFile converts a skipped duplicate to a catalog file. Linked
+// duplicates are recorded at their new paths, so they count as stored there.
+func skippedFile(ingestID int64, skipped transfer.SkippedFile) catalog.File {
+	file := catalog.File{
+		IngestID:   ingestID,
+		SourcePath: skipped.SourcePath,
+		Size:       skipped.Size,
+		Status:     catalog.StatusSkipped,
+		Checksums:  skipped.Checksums,
+		MediaType:  transfer.MediaType(skipped.SourcePath),
+		Extension:  strings.ToLower(filepath.Ext(skipped.SourcePath)),
+		Completed:  time.Now(),
+	}
+	if !skipped.Linked {
+		file.Copies = []catalog.Copy{{Location: skipped.Existing}}
+		return file
+	}
+	for _, c := range skipped.Copies {
+		file.Copies = append(file.Copies, catalog.Copy{Destination: c.Destination, RelPath: c.RelPath, Location: c.Path})
+	}
+	return file
+}
+
+// catalogFile converts a transfer to a catalog file
+func catalogFile(ingestID int64, t transfer.FileTransfer) catalog.File {
+	file := catalog.File{
+		IngestID:   ingestID,
+		SourcePath: t.SourcePath,
+		Size:       t.Size,
+		Status:     catalog.StatusCopied,
+		Checksums:  t.Checksums,
+		MediaType:  transfer.MediaType(t.SourcePath),
+		Extension:  strings.ToLower(filepath.Ext(t.SourcePath)),
+		Completed:  t.Completed,
+	}
+	if info := t.FileInfo; info != nil && info.Matched {
+		file.Client = info.Client
+		file.Project = info.ProjectName
+		file.Camera = info.Camera
+		file.ClipNumber = info.ClipNumber
+	}
+	if t.FileInfo != nil {
+		file.Roll = t.FileInfo.Roll
+		if span := t.FileInfo.Span; span != nil {
+			file.Span = span.Lead
+			file.SpanPart = span.Part
+		}
+	}
+	if meta, err := os.Stat(t.SourcePath); err == nil {
+		file.RecordedAt = meta.ModTime()
+	}
+
+	for _, c := range t.Copies {
+		file.Copies = append(file.Copies, catalog.Copy{Destination: c.Destination, RelPath: c.RelPath, Location: c.Path})
+		if c.Err != nil && file.Status == catalog.StatusCopied {
+			file.Status = catalog.StatusFailed
+			file.Error = c.Err.Error()
+		}
+	}
+	return file
+}
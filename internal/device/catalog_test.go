@@ -0,0 +1,91 @@
+package device
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/autofileingest/internal/catalog"
+	"github.com/autofileingest/internal/catalog/catalogtest"
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/parser"
+	"github.com/autofileingest/internal/transfer"
+)
+
+func TestCatalogFile(t *testing.T) {
+	file := catalogFile(7, transfer.FileTransfer{
+		SourcePath: "/media/A001/Nike_Ad_ACam_001.MOV",
+		Size:       1024,
+		FileInfo:   &parser.FileInfo{Matched: true, Client: "Ad", ProjectName: "Nike", Camera: "ACam", ClipNumber: "001"},
+		Checksums:  map[string]string{"xxh64": "aaaa"},
+		Copies: []transfer.DestinationCopy{
+			{Destination: "primary", RelPath: "Ad/Nike/ACam/001.MOV", Path: "/mnt/raid/Ad/Nike/ACam/001.MOV"},
+			{Destination: "nas", RelPath: "Ad/Nike/ACam/001.MOV", Err: errors.New("checksum mismatch")},
+		},
+	})
+
+	if file.IngestID != 7 || file.Project != "Nike" || file.Camera != "ACam" || file.ClipNumber != "001" {
+		t.Errorf("Expected the parsed tokens to be recorded, got %+v", file)
+	}
+	if file.MediaType != "video" || file.Extension != ".mov" || file.Checksums["xxh64"] != "aaaa" {
+		t.Errorf("Unexpected media metadata: %+v", file)
+	}
+	if file.Status != catalog.StatusFailed || file.Error != "checksum mismatch" {
+		t.Errorf("Expected a failed copy to fail the file, got %s %q", file.Status, file.Error)
+	}
+	if len(file.Copies) != 2 || file.Copies[0].Location != "/mnt/raid/Ad/Nike/ACam/001.MOV" {
+		t.Errorf("Unexpected copies: %+v", file.Copies)
+	}
+}
+
+func TestSkippedFile_RecordsLinkedPaths(t *testing.T) {
+	linked := skippedFile(7, transfer.SkippedFile{
+		SourcePath: "/media/A002/Nike_Ad_ACam_001.MOV",
+		Existing:   "/mnt/raid/Ad/Nike/ACam/001.MOV",
+		Linked:     true,
+		Size:       1024,
+		Copies: []transfer.DestinationCopy{
+			{Destination: "primary", RelPath: "Ad/Nike/ACam/001_v2.MOV", Path: "/mnt/raid/Ad/Nike/ACam/001_v2.MOV"},
+		},
+	})
+	if linked.Status != catalog.StatusSkipped || linked.Size != 1024 {
+		t.Errorf("Expected a skipped record of the content, got %+v", linked)
+	}
+	if len(linked.Copies) != 1 || linked.Copies[0].Destination != "primary" || linked.Copies[0].RelPath != "Ad/Nike/ACam/001_v2.MOV" {
+		t.Errorf("Expected the linked path to be recorded, got %+v", linked.Copies)
+	}
+
+	skipped := skippedFile(7, transfer.SkippedFile{SourcePath: "/media/A002/Nike_Ad_ACam_001.MOV", Existing: "/mnt/raid/Ad/Nike/ACam/001.MOV"})
+	if len(skipped.Copies) != 1 || skipped.Copies[0].Destination != "" || skipped.Copies[0].Location != "/mnt/raid/Ad/Nike/ACam/001.MOV" {
+		t.Errorf("Expected only the existing location for an unlinked duplicate, got %+v", skipped.Copies)
+	}
+}
+
+func TestManager_StartScrubberRunsPass(t *testing.T) {
+	f := catalogtest.New(t, "raid")
+	clip := f.Add(t, "Ad/Nike/ACam/001.mov", "clip data")
+	f.Catalog.Close()
+
+	cfg := f.Config
+	cfg.Parsing = config.ParsingConfig{Pattern: "^([^_]+)_([^_]+)_(ACam|BCam|CCam)_(.+)$"}
+	cfg.Catalog = config.CatalogConfig{Enabled: true, Path: f.CatalogPath}
+	cfg.Scrub = config.ScrubConfig{Enabled: true, Interval: 24}
+
+	m := NewManager(cfg, f.Log)
+	if m == nil {
+		t.Fatal("Failed to create manager")
+	}
+	m.StartScrubber()
+	defer m.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if file, ok := m.Catalog().File(clip.ID); ok && !file.Verified.IsZero() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the started scrubber to check the due file")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -8,9 +8,14 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/autofileingest/internal/catalog"
 	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/email"
 	"github.com/autofileingest/internal/logger"
+	"github.com/autofileingest/internal/mhl"
 	"github.com/autofileingest/internal/parser"
+	"github.com/autofileingest/internal/roll"
+	"github.com/autofileingest/internal/scrub"
 	"github.com/autofileingest/internal/transfer"
 )
 
@@ -32,6 +37,8 @@ type Device struct {
 	Filesystem string
 	Size       int64
 	Label      string
+	// UUID is the filesystem UUID or volume serial number
+	UUID string
 }
 
 // Manager handles device operations (platform-agnostic)
@@ -42,6 +49,16 @@ type Manager struct {
 	detector       DeviceDetector
 	activeDevices  map[string]*Device
 	mu             sync.RWMutex
+
+	// throttle is shared by all devices, deviceThrottles limit each ingest
+	throttle        *transfer.RateLimiter
+	deviceThrottles map[string]*transfer.RateLimiter
+
+	// catalog records every ingest when enabled
+	catalog  *catalog.Catalog
+	scrubber *scrub.Scrubber
+	// rolls numbers the cards of each camera when enabled
+	rolls *roll.Allocator
 }
 
 // NewManager creates a new device manager with platform-specific detector
@@ -52,6 +69,12 @@ func NewManager(cfg *config.Config, log *logger.Logger) *Manager {
 		return nil
 	}
 
+	throttle, err := transfer.NewScheduledRateLimiter(cfg.Transfer.Throttle.Rate, cfg.Transfer.Throttle.Schedule)
+	if err != nil {
+		log.Error("Failed to create bandwidth limiter: %v", err)
+		return nil
+	}
+
 	// Create platform-specific detector
 	var detector DeviceDetector
 	if runtime.GOOS == "windows" {
@@ -60,13 +83,93 @@ func NewManager(cfg *config.Config, log *logger.Logger) *Manager {
 		detector = NewLinuxDetector(cfg, log)
 	}
 
+	var cat *catalog.Catalog
+	if cfg.Catalog.Enabled {
+		cat, err = catalog.Open(cfg.CatalogPath())
+		if err != nil {
+			log.Error("Failed to open catalog: %v", err)
+			return nil
+		}
+	}
+
+	var rolls *roll.Allocator
+	if cfg.Rolls.Enabled {
+		rolls, err = roll.Open(cfg.RollsPath(), cfg.Rolls.Digits, cfg.Rolls.PerDay)
+		if err != nil {
+			log.Error("Failed to open roll counters: %v", err)
+			return nil
+		}
+	}
+
 	return &Manager{
-		config:        cfg,
-		logger:        log,
-		parser:        p,
-		detector:      detector,
-		activeDevices: make(map[string]*Device),
+		config:          cfg,
+		logger:          log,
+		parser:          p,
+		detector:        detector,
+		activeDevices:   make(map[string]*Device),
+		throttle:        throttle,
+		deviceThrottles: make(map[string]*transfer.RateLimiter),
+		catalog:         cat,
+		rolls:           rolls,
+	}
+}
+
+// SetGlobalRate changes the bandwidth shared by all devices, in bytes per
+// second (0 for unlimited), overriding the schedule until ClearGlobalRate.
+// Running ingests slow down or speed up at once.
+func (m *Manager) SetGlobalRate(rate int64) {
+	m.throttle.Override(rate)
+}
+
+// ClearGlobalRate returns the shared bandwidth to the configured rate and
+// schedule
+func (m *Manager) ClearGlobalRate() {
+	m.throttle.ClearOverride()
+}
+
+// SetDeviceRate changes the bandwidth of a device being ingested,
+// overriding its schedule until ClearDeviceRate
+func (m *Manager) SetDeviceRate(deviceName string, rate int64) error {
+	limiter, err := m.ingestThrottle(deviceName)
+	if err != nil {
+		return err
 	}
+	limiter.Override(rate)
+	return nil
+}
+
+// ClearDeviceRate returns a device being ingested to its profile's rate and
+// schedule
+func (m *Manager) ClearDeviceRate(deviceName string) error {
+	limiter, err := m.ingestThrottle(deviceName)
+	if err != nil {
+		return err
+	}
+	limiter.ClearOverride()
+	return nil
+}
+
+// ingestThrottle returns the limiter of a device being ingested
+func (m *Manager) ingestThrottle(deviceName string) (*transfer.RateLimiter, error) {
+	m.mu.RLock()
+	limiter, ok := m.deviceThrottles[deviceName]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("device %s is not being ingested", deviceName)
+	}
+	return limiter, nil
+}
+
+// deviceThrottle creates the limiter of a device from the first matching
+// throttle profile, unlimited if none matches
+func (m *Manager) deviceThrottle(device *Device) (*transfer.RateLimiter, error) {
+	profile, ok := transfer.DeviceThrottle(m.config.Transfer.Throttle, device.Name, device.Label)
+	if !ok {
+		return transfer.NewRateLimiter(0), nil
+	}
+	m.logger.DeviceInfo(device.Name, "Using throttle profile %q", profile.Match)
+	return transfer.NewScheduledRateLimiter(profile.Rate, profile.Schedule)
 }
 
 // DetectDevices scans for available devices
@@ -90,7 +193,7 @@ func (m *Manager) GetDeviceInfo(devicePath string) (*Device, error) {
 }
 
 // ProcessDevice handles the complete ingest workflow for a device
-func (m *Manager) ProcessDevice(device *Device) error {
+func (m *Manager) ProcessDevice(device *Device) (err error) {
 	m.mu.Lock()
 	m.activeDevices[device.Name] = device
 	m.mu.Unlock()
@@ -109,6 +212,10 @@ func (m *Manager) ProcessDevice(device *Device) error {
 	}
 	defer m.logger.CloseDeviceLog(device.Name)
 
+	var transferMgr *transfer.Manager
+	ingest := m.beginIngest(device)
+	defer func() { m.finishIngest(device, ingest, transferMgr, err) }()
+
 	// Scan for files
 	files, err := m.scanFiles(device.MountPath)
 	if err != nil {
@@ -118,13 +225,48 @@ func (m *Manager) ProcessDevice(device *Device) error {
 
 	m.logger.DeviceInfo(device.Name, "Found %d files to transfer", len(files))
 
+	// Only copy what changed since the card was last ingested
+	var incremental *incrementalIngest
+	if m.config.Transfer.Incremental {
+		incremental, files = m.startIncremental(device, files)
+	}
+
 	if len(files) == 0 {
+		if incremental != nil {
+			incremental.finish(m, device.Name, nil, nil)
+		}
 		m.logger.DeviceInfo(device.Name, "No files to transfer")
 		return nil
 	}
 
 	// Create transfer manager
-	transferMgr := transfer.NewManager(m.config, m.logger, m.parser)
+	transferMgr = transfer.NewManager(m.config, m.logger, m.parser)
+	if m.rolls != nil {
+		ingest.Roll = m.assignRoll(device, files)
+		transferMgr.SetRoll(ingest.Roll)
+	}
+	transferMgr.SetNotifier(email.NewNotifier(m.config))
+
+	// Throttle with the shared limiter and the device's own
+	limiter, err := m.deviceThrottle(device)
+	if err != nil {
+		m.logger.DeviceError(device.Name, "Invalid throttle profile: %v", err)
+		return err
+	}
+	m.mu.Lock()
+	m.deviceThrottles[device.Name] = limiter
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.deviceThrottles, device.Name)
+		m.mu.Unlock()
+	}()
+	transferMgr.SetRateLimiters(m.throttle, limiter)
+
+	// Verify against hashes the camera or offload tool left on the media
+	if m.config.Transfer.VerifyChecksums {
+		transferMgr.LoadSourceManifests(device.Name, device.MountPath)
+	}
 
 	// Start transfer
 	if err := transferMgr.TransferFiles(device.Name, files); err != nil {
@@ -134,8 +276,11 @@ func (m *Manager) ProcessDevice(device *Device) error {
 
 	// Get final statistics
 	stats := transferMgr.GetStats()
-	m.logger.DeviceSuccess(device.Name, "Transfer complete: %d/%d files transferred",
-		stats.ProcessedFiles-stats.FailedFiles, stats.TotalFiles)
+	if incremental != nil {
+		incremental.finish(m, device.Name, transferMgr.GetCompletedTransfers(), stats.Skipped)
+	}
+	m.logger.DeviceSuccess(device.Name, "Transfer complete: %d/%d files transferred, %d skipped",
+		stats.ProcessedFiles-stats.FailedFiles, stats.TotalFiles, stats.SkippedFiles)
 
 	return nil
 }
@@ -149,6 +294,15 @@ func (m *Manager) scanFiles(rootPath string) ([]string, error) {
 			return err
 		}
 
+		// ASC MHL histories are verified and extended, not copied as clips
+		if info.IsDir() && info.Name() == mhl.ASCHistoryDir {
+			return filepath.SkipDir
+		}
+		// The roll marker belongs to the card
+		if info.Name() == roll.MarkerName {
+			return nil
+		}
+
 		if !info.IsDir() {
 			files = append(files, path)
 		}
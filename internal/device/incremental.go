@@ -0,0 +1,212 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/autofileingest/internal/jsonfile"
+	"github.com/autofileingest/internal/transfer"
+)
+
+// Identity identifies a card across insertions by its filesystem UUID,
+// label and size. Reformatting a card gives it a new identity. Cards
+// without a UUID have none, since a label alone isn't unique.
+func (d *Device) Identity() string {
+	if d.UUID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s-%s-%d", d.UUID, d.Label, d.Size)
+}
+
+// cardFile is the state of a file when it was copied from a card
+type cardFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// cardRecord lists the files copied from a card, by path relative to its
+// mount point
+type cardRecord struct {
+	Identity string              `json:"identity"`
+	Label    string              `json:"label"`
+	Updated  time.Time           `json:"updated"`
+	Files    map[string]cardFile `json:"files"`
+}
+
+// cardDelta is what changed on a card since its last ingest
+type cardDelta struct {
+	New       []string
+	Changed   []string
+	Deleted   []string
+	Unchanged int
+	// seen holds the current state of the new and changed files
+	seen map[string]cardFile
+}
+
+// unsafeFileChars are replaced in record file names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// cardRecordPath returns the record file of a card identity
+func cardRecordPath(dir, identity string) string {
+	return filepath.Join(dir, unsafeFileChars.ReplaceAllString(identity, "_")+".json")
+}
+
+// loadCardRecord reads a card record, returning an empty one for a card
+// that hasn't been ingested before
+func loadCardRecord(path, identity string) (*cardRecord, error) {
+	record := &cardRecord{Identity: identity, Files: map[string]cardFile{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return record, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("invalid card record %s: %w", path, err)
+	}
+	if record.Files == nil {
+		record.Files = map[string]cardFile{}
+	}
+	return record, nil
+}
+
+// save writes the record to path
+func (r *cardRecord) save(path string) error {
+	r.Updated = time.Now()
+	return jsonfile.Save(path, r)
+}
+
+// delta compares the files on the card with the record. It returns the
+// files to copy: those not copied before or changed since.
+func (r *cardRecord) delta(root string, files []string) (cardDelta, []string) {
+	delta := cardDelta{seen: map[string]cardFile{}}
+	present := map[string]bool{}
+	var toCopy []string
+
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Let the transfer report it
+			toCopy = append(toCopy, path)
+			continue
+		}
+		rel := relativePath(root, path)
+		present[rel] = true
+
+		current := cardFile{Size: info.Size(), ModTime: info.ModTime()}
+		previous, ok := r.Files[rel]
+		switch {
+		case !ok:
+			delta.New = append(delta.New, rel)
+		case previous.Size != current.Size || !previous.ModTime.Equal(current.ModTime):
+			delta.Changed = append(delta.Changed, rel)
+		default:
+			delta.Unchanged++
+			continue
+		}
+		delta.seen[rel] = current
+		toCopy = append(toCopy, path)
+	}
+
+	for rel := range r.Files {
+		if !present[rel] {
+			delta.Deleted = append(delta.Deleted, rel)
+		}
+	}
+	sort.Strings(delta.Deleted)
+	return delta, toCopy
+}
+
+// update records the files copied by an ingest and forgets those deleted
+// from the card. Files skipped as already ingested count as copied.
+func (r *cardRecord) update(root string, delta cardDelta, completed []transfer.FileTransfer, skipped []transfer.SkippedFile) {
+	for _, rel := range delta.Deleted {
+		delete(r.Files, rel)
+	}
+	for _, t := range completed {
+		if !t.Succeeded() {
+			continue
+		}
+		rel := relativePath(root, t.SourcePath)
+		if state, ok := delta.seen[rel]; ok {
+			r.Files[rel] = state
+		}
+	}
+	for _, s := range skipped {
+		rel := relativePath(root, s.SourcePath)
+		if state, ok := delta.seen[rel]; ok {
+			r.Files[rel] = state
+		}
+	}
+}
+
+// relativePath returns the slash-separated path of a file on a card
+func relativePath(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// logDelta lists what changed on a card in the ingest log
+func (m *Manager) logDelta(deviceName string, delta cardDelta) {
+	m.logger.DeviceInfo(deviceName, "Incremental ingest: %d new, %d changed, %d deleted on card, %d already copied",
+		len(delta.New), len(delta.Changed), len(delta.Deleted), delta.Unchanged)
+	for _, rel := range delta.New {
+		m.logger.DeviceInfo(deviceName, "  New: %s", rel)
+	}
+	for _, rel := range delta.Changed {
+		m.logger.DeviceInfo(deviceName, "  Changed: %s", rel)
+	}
+	for _, rel := range delta.Deleted {
+		m.logger.DeviceInfo(deviceName, "  Deleted on card: %s", rel)
+	}
+}
+
+// incrementalIngest tracks the record of a card during an ingest
+type incrementalIngest struct {
+	root   string
+	path   string
+	record *cardRecord
+	delta  cardDelta
+}
+
+// startIncremental loads the record of a card and narrows files to the
+// ones to copy. Without a card identity or a readable record it returns
+// nil and every file is copied.
+func (m *Manager) startIncremental(device *Device, files []string) (*incrementalIngest, []string) {
+	identity := device.Identity()
+	if identity == "" {
+		m.logger.DeviceInfo(device.Name, "Card has no filesystem UUID, copying every file")
+		return nil, files
+	}
+
+	path := cardRecordPath(m.config.CardRecordsDir(), identity)
+	record, err := loadCardRecord(path, identity)
+	if err != nil {
+		m.logger.DeviceError(device.Name, "Failed to read card record, copying every file: %v", err)
+		return nil, files
+	}
+	record.Label = device.Label
+
+	delta, toCopy := record.delta(device.MountPath, files)
+	m.logDelta(device.Name, delta)
+	return &incrementalIngest{root: device.MountPath, path: path, record: record, delta: delta}, toCopy
+}
+
+// finish saves what was copied so the next ingest of the card skips it
+func (inc *incrementalIngest) finish(m *Manager, deviceName string, completed []transfer.FileTransfer, skipped []transfer.SkippedFile) {
+	inc.record.update(inc.root, inc.delta, completed, skipped)
+	if err := inc.record.save(inc.path); err != nil {
+		m.logger.DeviceError(deviceName, "Failed to save card record: %v", err)
+	}
+}
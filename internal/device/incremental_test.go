@@ -0,0 +1,110 @@
+package device
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/autofileingest/internal/transfer"
+)
+
+func writeCardFile(t *testing.T, root, rel, content string) string {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", rel, err)
+	}
+	return path
+}
+
+// copied marks transfers as completed on one destination
+func copied(paths ...string) []transfer.FileTransfer {
+	var transfers []transfer.FileTransfer
+	for _, path := range paths {
+		transfers = append(transfers, transfer.FileTransfer{
+			SourcePath: path,
+			Copies:     []transfer.DestinationCopy{{Destination: "primary"}},
+		})
+	}
+	return transfers
+}
+
+func TestCardRecord_Delta(t *testing.T) {
+	card := t.TempDir()
+	recordPath := cardRecordPath(filepath.Join(t.TempDir(), "cards"), "1234-ABCD-A001-64000000000")
+
+	// Lunch offload
+	clip1 := writeCardFile(t, card, "DCIM/100GOPRO/GX010001.MP4", "clip one")
+	clip2 := writeCardFile(t, card, "DCIM/100GOPRO/GX010002.MP4", "clip two")
+	failed := writeCardFile(t, card, "DCIM/100GOPRO/GX010003.MP4", "clip three")
+
+	record, err := loadCardRecord(recordPath, "1234-ABCD-A001-64000000000")
+	if err != nil {
+		t.Fatalf("Failed to load new record: %v", err)
+	}
+	delta, files := record.delta(card, []string{clip1, clip2, failed})
+	if len(delta.New) != 3 || len(files) != 3 {
+		t.Fatalf("Expected 3 new files on first ingest, got %+v", delta)
+	}
+
+	results := copied(clip1, clip2, failed)
+	results[2].Copies[0].Err = errors.New("checksum mismatch")
+	record.update(card, delta, results, nil)
+	if err := record.save(recordPath); err != nil {
+		t.Fatalf("Failed to save record: %v", err)
+	}
+
+	// Wrap offload: one clip deleted, one re-recorded, one new
+	os.Remove(clip1)
+	writeCardFile(t, card, "DCIM/100GOPRO/GX010002.MP4", "clip two, longer")
+	os.Chtimes(clip2, time.Now(), time.Now().Add(time.Hour))
+	clip4 := writeCardFile(t, card, "DCIM/100GOPRO/GX010004.MP4", "clip four")
+
+	record, err = loadCardRecord(recordPath, "1234-ABCD-A001-64000000000")
+	if err != nil {
+		t.Fatalf("Failed to reload record: %v", err)
+	}
+	delta, files = record.delta(card, []string{clip2, failed, clip4})
+
+	if got := strings.Join(delta.New, " "); got != "DCIM/100GOPRO/GX010003.MP4 DCIM/100GOPRO/GX010004.MP4" {
+		t.Errorf("Unexpected new files: %s", got)
+	}
+	if got := strings.Join(delta.Changed, " "); got != "DCIM/100GOPRO/GX010002.MP4" {
+		t.Errorf("Unexpected changed files: %s", got)
+	}
+	if got := strings.Join(delta.Deleted, " "); got != "DCIM/100GOPRO/GX010001.MP4" {
+		t.Errorf("Unexpected deleted files: %s", got)
+	}
+	if len(files) != 3 {
+		t.Errorf("Expected 3 files to copy, got %d", len(files))
+	}
+
+	// After recording the second offload nothing is left to copy
+	record.update(card, delta, nil, []transfer.SkippedFile{{SourcePath: clip4}})
+	record.update(card, delta, copied(clip2, failed), nil)
+	delta, files = record.delta(card, []string{clip2, failed, clip4})
+	if len(files) != 0 || delta.Unchanged != 3 || len(delta.Deleted) != 0 {
+		t.Errorf("Expected nothing to copy, got %d files (%+v)", len(files), delta)
+	}
+}
+
+func TestDevice_Identity(t *testing.T) {
+	d := &Device{Label: "A001", Size: 64000000000}
+	if d.Identity() != "" {
+		t.Error("Expected no identity without a UUID")
+	}
+	d.UUID = "1234-ABCD"
+	if d.Identity() != "1234-ABCD-A001-64000000000" {
+		t.Errorf("Unexpected identity %s", d.Identity())
+	}
+	if path := cardRecordPath("/state/cards", "12/34 AB"); path != filepath.Join("/state/cards", "12_34_AB.json") {
+		t.Errorf("Unexpected record path %s", path)
+	}
+}
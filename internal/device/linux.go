@@ -108,6 +108,12 @@ func (l *LinuxDetector) GetDeviceInfo(devicePath string) (*Device, error) {
 		device.Label = strings.TrimSpace(string(output))
 	}
 
+	// Get filesystem UUID
+	cmd = exec.Command("blkid", "-s", "UUID", "-o", "value", devicePath)
+	if output, err := cmd.Output(); err == nil {
+		device.UUID = strings.TrimSpace(string(output))
+	}
+
 	// Get size
 	cmd = exec.Command("blockdev", "--getsize64", devicePath)
 	if output, err := cmd.Output(); err == nil {
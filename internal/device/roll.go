@@ -0,0 +1,69 @@
+package device
+
+import (
+	"time"
+
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/roll"
+)
+
+// assignRoll works out the roll of a card: the one left on it by an earlier
+// ingest, a roll-like label such as A012, or the next roll of the camera
+// most of its files were shot on. It returns "" when none can be found.
+func (m *Manager) assignRoll(device *Device, files []string) string {
+	now := time.Now()
+	identity := device.Identity()
+
+	r, ok := roll.ReadMarker(device.MountPath)
+	if !ok {
+		r, ok = m.rolls.Card(identity)
+	}
+	if !ok {
+		r, ok = roll.Parse(device.Label)
+	}
+
+	var err error
+	if ok {
+		r, err = m.rolls.Claim(r, identity, now)
+	} else {
+		camera := m.cardCamera(files)
+		if camera == "" {
+			m.logger.DeviceInfo(device.Name, "No camera found for roll numbering, leaving {roll} empty")
+			return ""
+		}
+		r, err = m.rolls.Next(camera, identity, now)
+	}
+	if err != nil {
+		m.logger.DeviceError(device.Name, "Failed to save roll counters: %v", err)
+	}
+
+	if m.config.Rolls.Marker == config.RollMarkerCard {
+		if err := roll.WriteMarker(device.MountPath, r); err != nil {
+			m.logger.DeviceInfo(device.Name, "Failed to write roll marker to card, keeping it on the server: %v", err)
+		}
+	}
+	m.logger.DeviceInfo(device.Name, "Roll %s", r.ID)
+	return r.ID
+}
+
+// cardCamera returns the roll letter of the camera most files were
+// parsed with
+func (m *Manager) cardCamera(files []string) string {
+	counts := map[string]int{}
+	best := ""
+	for _, path := range files {
+		info := m.parser.Parse(path)
+		if !info.Matched {
+			continue
+		}
+		letter := roll.CameraLetter(info.Camera, m.config.Rolls.Cameras)
+		if letter == "" {
+			continue
+		}
+		counts[letter]++
+		if counts[letter] > counts[best] || (counts[letter] == counts[best] && letter < best) {
+			best = letter
+		}
+	}
+	return best
+}
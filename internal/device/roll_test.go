@@ -0,0 +1,62 @@
+package device
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/logger"
+	"github.com/autofileingest/internal/parser"
+	"github.com/autofileingest/internal/roll"
+)
+
+func TestManager_AssignRoll(t *testing.T) {
+	cfg := &config.Config{
+		Parsing: config.ParsingConfig{Pattern: "^([^_]+)_([^_]+)_(ACam|BCam|CCam)_(.+)$"},
+		Logging: config.LoggingConfig{ServerLogPath: t.TempDir(), LogLevel: "debug"},
+		Rolls:   config.RollConfig{Enabled: true, Digits: 3, Marker: config.RollMarkerCard},
+	}
+	log, err := logger.NewLogger(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer log.Close()
+	p, err := parser.NewParser(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	rolls, err := roll.Open(filepath.Join(t.TempDir(), "rolls.json"), 3, false)
+	if err != nil {
+		t.Fatalf("Failed to open roll counters: %v", err)
+	}
+	m := &Manager{config: cfg, logger: log, parser: p, rolls: rolls}
+
+	card := t.TempDir()
+	files := []string{
+		writeCardFile(t, card, "Nike_Ad_BCam_001.mov", "b"),
+		writeCardFile(t, card, "Nike_Ad_BCam_002.mov", "b"),
+		writeCardFile(t, card, "Nike_Ad_ACam_001.mov", "a"),
+	}
+	device := &Device{Name: "sdb1", Label: "NO NAME", MountPath: card}
+
+	if got := m.assignRoll(device, files); got != "B001" {
+		t.Fatalf("Expected B001 from the camera of most files, got %q", got)
+	}
+	// The marker left on the card keeps its roll when inserted again
+	if got := m.assignRoll(device, files); got != "B001" {
+		t.Errorf("Expected the card to keep B001, got %q", got)
+	}
+
+	// A roll-like label wins over the files
+	labelled := &Device{Name: "sdc1", Label: "A007", MountPath: t.TempDir()}
+	if got := m.assignRoll(labelled, files); got != "A007" {
+		t.Errorf("Expected the label A007, got %q", got)
+	}
+	if got := m.assignRoll(&Device{Name: "sdd1", MountPath: t.TempDir()}, files[2:]); got != "A008" {
+		t.Errorf("Expected A008 after the labelled card, got %q", got)
+	}
+
+	if got := m.assignRoll(&Device{Name: "sde1", MountPath: t.TempDir()}, []string{filepath.Join(card, "random.mp4")}); got != "" {
+		t.Errorf("Expected no roll without a camera, got %q", got)
+	}
+}
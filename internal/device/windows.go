@@ -126,6 +126,8 @@ func (w *WindowsDetector) GetDeviceInfo(drivePath string) (*Device, error) {
 	if ret != 0 {
 		device.Label = syscall.UTF16ToString(volumeNameBuffer[:])
 		device.Filesystem = syscall.UTF16ToString(fileSystemNameBuffer[:])
+		// Formatted like the UUID blkid reports for FAT and exFAT
+		device.UUID = fmt.Sprintf("%04X-%04X", volumeSerialNumber>>16, volumeSerialNumber&0xFFFF)
 	}
 
 	// Get disk size
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"net/smtp"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/scrub"
 	"github.com/autofileingest/internal/transfer"
 )
 
@@ -35,6 +37,99 @@ func (n *Notifier) SendTransferComplete(deviceName string, stats transfer.Transf
 	return n.sendEmail(subject, body, logPath)
 }
 
+// NotifyLowSpace sends a notification when an ingest is refused, queued or
+// paused because a destination is running out of space
+func (n *Notifier) NotifyLowSpace(deviceName, event string, shortfalls []transfer.SpaceShortfall) error {
+	if !n.config.Email.Enabled {
+		return nil
+	}
+
+	subject := fmt.Sprintf("Media Ingest: destination full, ingest %s - %s", event, deviceName)
+	return n.sendEmail(subject, n.buildLowSpaceBody(deviceName, event, shortfalls), "")
+}
+
+// NotifyDestinationUnavailable sends a notification when an ingest is
+// refused because a destination failed its mount guard
+func (n *Notifier) NotifyDestinationUnavailable(deviceName, destination string, reason error) error {
+	if !n.config.Email.Enabled {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("Media Ingest Refused - %s\n", deviceName))
+	buf.WriteString(strings.Repeat("=", 50) + "\n\n")
+	buf.WriteString(fmt.Sprintf("Destination %s is not available, so nothing was copied:\n\n", destination))
+	buf.WriteString(fmt.Sprintf("  %v\n\n", reason))
+	buf.WriteString("Check that the storage is mounted, then reconnect the device.\n\n")
+	buf.WriteString(fmt.Sprintf("Time: %s\n", time.Now().Format("2006-01-02 15:04:05")))
+	buf.WriteString("This is an automated message from Media Ingest Server.\n")
+
+	subject := fmt.Sprintf("Media Ingest: destination %s unavailable - %s", destination, deviceName)
+	return n.sendEmail(subject, buf.String(), "")
+}
+
+// SendScrubReport sends the problems found by an integrity scrub
+func (n *Notifier) SendScrubReport(report scrub.Report) error {
+	if !n.config.Email.Enabled {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("Media Ingest Integrity Scrub\n")
+	buf.WriteString(strings.Repeat("=", 50) + "\n\n")
+	buf.WriteString(fmt.Sprintf("Checked %d files (%d copies, %s) between %s and %s.\n",
+		report.Files, report.Copies, formatBytes(report.Bytes),
+		report.Started.Format("2006-01-02 15:04"), report.Finished.Format("2006-01-02 15:04")))
+	if report.Remaining > 0 {
+		buf.WriteString(fmt.Sprintf("%d files are left for the next run.\n", report.Remaining))
+	}
+
+	buf.WriteString("\nProblems:\n")
+	for _, f := range report.Findings {
+		state := "NOT RESTORED"
+		if f.Restored {
+			state = "restored from mirror"
+		}
+		buf.WriteString(fmt.Sprintf("  [%s] %s: %s (%s)\n    %s\n", f.Problem, f.Destination, f.Location, state, f.Detail))
+	}
+
+	buf.WriteString("\n")
+	buf.WriteString("This is an automated message from Media Ingest Server.\n")
+
+	subject := fmt.Sprintf("Media Ingest: scrub found %d problems", len(report.Findings))
+	return n.sendEmail(subject, buf.String(), "")
+}
+
+// buildLowSpaceBody creates the body of a low space notification
+func (n *Notifier) buildLowSpaceBody(deviceName, event string, shortfalls []transfer.SpaceShortfall) string {
+	var buf bytes.Buffer
+
+	buf.WriteString(fmt.Sprintf("Media Ingest Low Space - %s\n", deviceName))
+	buf.WriteString(strings.Repeat("=", 50) + "\n\n")
+
+	switch event {
+	case transfer.SpaceRefused:
+		buf.WriteString("The ingest was refused because it doesn't fit on the destinations below.\n")
+		buf.WriteString("Nothing was copied. Free up space and reconnect the device.\n\n")
+	case transfer.SpaceQueued:
+		buf.WriteString("The ingest is queued and will start once enough space has been freed.\n\n")
+	case transfer.SpacePaused:
+		buf.WriteString("The ingest is paused and will resume once enough space has been freed.\n\n")
+	}
+
+	for _, s := range shortfalls {
+		buf.WriteString(fmt.Sprintf("  %s: %s needed, %s available (reserve %s)\n",
+			strings.Join(s.Destinations, ", "), formatBytes(s.Required), formatBytes(s.Available),
+			formatBytes(n.config.Transfer.SpaceReserve)))
+	}
+
+	buf.WriteString("\n")
+	buf.WriteString(fmt.Sprintf("Time: %s\n", time.Now().Format("2006-01-02 15:04:05")))
+	buf.WriteString("This is an automated message from Media Ingest Server.\n")
+
+	return buf.String()
+}
+
 // buildEmailBody creates the email body content
 func (n *Notifier) buildEmailBody(deviceName string, stats transfer.TransferStats) string {
 	var buf bytes.Buffer
@@ -51,6 +146,50 @@ func (n *Notifier) buildEmailBody(deviceName string, stats transfer.TransferStat
 	buf.WriteString(fmt.Sprintf("  Total Size: %s\n", formatBytes(stats.TotalBytes)))
 	buf.WriteString(fmt.Sprintf("  Duration: %s\n", time.Since(stats.StartTime).Round(time.Second)))
 	buf.WriteString(fmt.Sprintf("  Average Speed: %s/s\n", formatBytes(int64(stats.TransferredBytes/int64(time.Since(stats.StartTime).Seconds())))))
+	if n.config.Transfer.VerifyChecksums {
+		buf.WriteString(fmt.Sprintf("  Hash Algorithms: %s\n", strings.Join(n.config.Transfer.HashAlgorithms, ", ")))
+	}
+	if stats.ManifestVerified > 0 || stats.SourceMismatches > 0 {
+		buf.WriteString(fmt.Sprintf("  Verified Against Source Manifests: %d\n", stats.ManifestVerified))
+		buf.WriteString(fmt.Sprintf("  Source Hash Mismatches: %d\n", stats.SourceMismatches))
+	}
+
+	if destinations := n.config.GetDestinations(); len(destinations) > 1 {
+		buf.WriteString("\nDestinations:\n")
+		for _, dest := range destinations {
+			ds := stats.Destinations[dest.Name]
+			location := dest.Path
+			switch dest.Type {
+			case config.DestinationS3:
+				location = "s3://" + dest.S3.Bucket
+			case config.DestinationSFTP:
+				location = dest.SFTP.Host + ":" + dest.Path
+			case config.DestinationWebDAV:
+				location = dest.WebDAV.URL
+			}
+			buf.WriteString(fmt.Sprintf("  %s (%s): %d files, %s, %d failed\n",
+				dest.Name, location, ds.Files, formatBytes(ds.Bytes), ds.FailedFiles))
+		}
+	}
+
+	if len(stats.Skipped) > 0 {
+		buf.WriteString("\nSkipped (already ingested):\n")
+		for _, skipped := range stats.Skipped {
+			buf.WriteString(fmt.Sprintf("  %s -> %s\n", filepath.Base(skipped.SourcePath), skipped.Existing))
+		}
+	}
+
+	if len(stats.Spans) > 0 {
+		buf.WriteString("\nSpanned clips:\n")
+		for _, span := range stats.Spans {
+			state := "complete"
+			if !span.Complete() {
+				state = fmt.Sprintf("INCOMPLETE, %d of %d stored", span.Copied, len(span.Segments))
+			}
+			buf.WriteString(fmt.Sprintf("  %s: %d segments, %s, %s\n",
+				span.Clip, len(span.Segments), formatBytes(span.Size), state))
+		}
+	}
 
 	buf.WriteString("\n")
 	buf.WriteString("This is an automated message from Media Ingest Server.\n")
@@ -0,0 +1,31 @@
+package jsonfile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSave_ReplacesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "counters.json")
+
+	for _, want := range []int{1, 2} {
+		if err := Save(path, map[string]int{"A": want}); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read saved file: %v", err)
+		}
+		var got map[string]int
+		if err := json.Unmarshal(data, &got); err != nil || got["A"] != want {
+			t.Errorf("Expected A=%d, got %s (%v)", want, data, err)
+		}
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary file left behind, got %v", err)
+	}
+}
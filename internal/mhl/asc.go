@@ -0,0 +1,273 @@
+package mhl
+
+import (
+	"crypto/sha512"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/autofileingest/internal/checksum"
+)
+
+const (
+	// ASCHistoryDir is the folder holding the ASC MHL history of a root
+	ASCHistoryDir = "ascmhl"
+	// ASCChainFile lists every generation with its C4 ID
+	ASCChainFile = "ascmhl_chain.xml"
+)
+
+// ascIgnorePatterns are the default ignore patterns from the ASC MHL spec
+var ascIgnorePatterns = []string{".DS_Store", "ascmhl", "ascmhl/"}
+
+// generationPattern matches generation file names such as 0001_A001_2024-...Z.mhl
+var generationPattern = regexp.MustCompile(`^(\d{4,})_.*\.mhl$`)
+
+type ascHashList struct {
+	XMLName     xml.Name       `xml:"urn:ASC:MHL:v2.0 hashlist"`
+	Version     string         `xml:"version,attr"`
+	CreatorInfo ascCreatorInfo `xml:"creatorinfo"`
+	ProcessInfo ascProcessInfo `xml:"processinfo"`
+	Hashes      []ascHash      `xml:"hashes>hash"`
+}
+
+type ascCreatorInfo struct {
+	CreationDate string      `xml:"creationdate"`
+	Hostname     string      `xml:"hostname"`
+	Tool         ascTool     `xml:"tool"`
+	Authors      []ascAuthor `xml:"author,omitempty"`
+	Location     string      `xml:"location,omitempty"`
+	Comment      string      `xml:"comment,omitempty"`
+}
+
+type ascTool struct {
+	Version string `xml:"version,attr"`
+	Name    string `xml:",chardata"`
+}
+
+type ascAuthor struct {
+	Email string `xml:"email,attr,omitempty"`
+	Role  string `xml:"role,attr,omitempty"`
+	Name  string `xml:",chardata"`
+}
+
+type ascProcessInfo struct {
+	Process  string   `xml:"process"`
+	Patterns []string `xml:"ignore>pattern"`
+}
+
+type ascHash struct {
+	Path  ascPath       `xml:"path"`
+	MD5   *ascHashValue `xml:"md5,omitempty"`
+	SHA1  *ascHashValue `xml:"sha1,omitempty"`
+	XXH64 *ascHashValue `xml:"xxh64,omitempty"`
+}
+
+type ascPath struct {
+	Size                 int64  `xml:"size,attr"`
+	LastModificationDate string `xml:"lastmodificationdate,attr,omitempty"`
+	Path                 string `xml:",chardata"`
+}
+
+type ascHashValue struct {
+	Action   string `xml:"action,attr,omitempty"`
+	HashDate string `xml:"hashdate,attr,omitempty"`
+	Value    string `xml:",chardata"`
+}
+
+type ascChain struct {
+	XMLName   xml.Name        `xml:"urn:ASC:MHL:DIRECTORY:v2.0 ascmhldirectory"`
+	Hashlists []ascChainEntry `xml:"hashlist"`
+}
+
+type ascChainEntry struct {
+	SequenceNr int    `xml:"sequencenr,attr"`
+	Path       string `xml:"path"`
+	C4         string `xml:"c4"`
+}
+
+// writeASC adds a new generation to the ascmhl history of root
+func writeASC(root string, m *Manifest) (string, error) {
+	historyDir := filepath.Join(root, ASCHistoryDir)
+	if err := os.MkdirAll(historyDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create ascmhl folder: %w", err)
+	}
+
+	chain, err := readChain(historyDir)
+	if err != nil {
+		return "", err
+	}
+
+	sequence, err := nextSequence(historyDir, chain)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := marshalXML(buildASCHashList(m))
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%04d_%s_%s.mhl", sequence, filepath.Base(root), m.Finished.UTC().Format("2006-01-02_150405Z"))
+	path := filepath.Join(historyDir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write MHL generation: %w", err)
+	}
+
+	chain.Hashlists = append(chain.Hashlists, ascChainEntry{
+		SequenceNr: sequence,
+		Path:       name,
+		C4:         C4ID(data),
+	})
+
+	chainData, err := marshalXML(chain)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(historyDir, ASCChainFile), chainData, 0644); err != nil {
+		return "", fmt.Errorf("failed to write MHL chain: %w", err)
+	}
+
+	return path, nil
+}
+
+// buildASCHashList converts a manifest to its ASC MHL v2 representation
+func buildASCHashList(m *Manifest) *ascHashList {
+	list := &ascHashList{
+		Version: "2.0",
+		CreatorInfo: ascCreatorInfo{
+			CreationDate: formatTime(m.Finished),
+			Hostname:     m.Creator.Hostname,
+			Tool:         ascTool{Version: ToolVersion, Name: ToolName},
+			Location:     m.Creator.Location,
+			Comment:      m.Creator.Comment,
+		},
+		ProcessInfo: ascProcessInfo{
+			Process:  m.Process,
+			Patterns: ascIgnorePatterns,
+		},
+	}
+
+	if list.ProcessInfo.Process == "" {
+		list.ProcessInfo.Process = ProcessTransfer
+	}
+
+	if m.Creator.Name != "" {
+		list.CreatorInfo.Authors = []ascAuthor{{
+			Email: m.Creator.Email,
+			Role:  m.Creator.Role,
+			Name:  m.Creator.Name,
+		}}
+	}
+
+	for _, entry := range m.Entries {
+		hash := ascHash{
+			Path: ascPath{
+				Size:                 entry.Size,
+				LastModificationDate: formatTime(entry.ModTime),
+				Path:                 toSlash(entry.Path),
+			},
+		}
+
+		action := entry.Action
+		if action == "" {
+			action = ActionOriginal
+		}
+
+		for _, name := range hashesFor(entry.Digests) {
+			value := &ascHashValue{
+				Action:   action,
+				HashDate: formatTime(entry.HashDate),
+				Value:    entry.Digests[name],
+			}
+			switch name {
+			case checksum.MD5:
+				hash.MD5 = value
+			case checksum.SHA1:
+				hash.SHA1 = value
+			case checksum.XXH64:
+				hash.XXH64 = value
+			}
+		}
+
+		list.Hashes = append(list.Hashes, hash)
+	}
+
+	return list
+}
+
+// readChain loads the chain file of a history folder, if there is one
+func readChain(historyDir string) (*ascChain, error) {
+	chain := &ascChain{}
+
+	data, err := os.ReadFile(filepath.Join(historyDir, ASCChainFile))
+	if os.IsNotExist(err) {
+		return chain, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MHL chain: %w", err)
+	}
+
+	if err := xml.Unmarshal(data, chain); err != nil {
+		return nil, fmt.Errorf("failed to parse MHL chain: %w", err)
+	}
+	return chain, nil
+}
+
+// nextSequence returns the next generation number, looking at both the chain
+// and the files on disk so a damaged chain never causes a number to be reused
+func nextSequence(historyDir string, chain *ascChain) (int, error) {
+	highest := 0
+	for _, entry := range chain.Hashlists {
+		if entry.SequenceNr > highest {
+			highest = entry.SequenceNr
+		}
+	}
+
+	entries, err := os.ReadDir(historyDir)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if match := generationPattern.FindStringSubmatch(entry.Name()); match != nil {
+			if n, err := strconv.Atoi(match[1]); err == nil && n > highest {
+				highest = n
+			}
+		}
+	}
+
+	return highest + 1, nil
+}
+
+// C4ID computes the C4 ID (SMPTE ST 2114) of data, as used in the chain file
+func C4ID(data []byte) string {
+	const charset = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+	sum := sha512.Sum512(data)
+	n := new(big.Int).SetBytes(sum[:])
+	base := big.NewInt(58)
+	mod := new(big.Int)
+
+	id := make([]byte, 88)
+	for i := range id {
+		id[i] = charset[0]
+	}
+	for i := len(id) - 1; n.Sign() > 0 && i >= 0; i-- {
+		n.DivMod(n, base, mod)
+		id[i] = charset[mod.Int64()]
+	}
+
+	return "c4" + string(id)
+}
+
+// marshalXML renders v as an indented XML document
+func marshalXML(v interface{}) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode MHL: %w", err)
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
@@ -0,0 +1,99 @@
+package mhl
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/autofileingest/internal/checksum"
+)
+
+type legacyHashList struct {
+	XMLName     xml.Name          `xml:"hashlist"`
+	Version     string            `xml:"version,attr"`
+	CreatorInfo legacyCreatorInfo `xml:"creatorinfo"`
+	Hashes      []legacyHash      `xml:"hash"`
+}
+
+type legacyCreatorInfo struct {
+	Name       string `xml:"name,omitempty"`
+	Username   string `xml:"username,omitempty"`
+	Hostname   string `xml:"hostname"`
+	Tool       string `xml:"tool"`
+	StartDate  string `xml:"startdate"`
+	FinishDate string `xml:"finishdate"`
+	Log        string `xml:"log,omitempty"`
+}
+
+type legacyHash struct {
+	File                 string `xml:"file"`
+	Size                 int64  `xml:"size"`
+	LastModificationDate string `xml:"lastmodificationdate,omitempty"`
+	MD5                  string `xml:"md5,omitempty"`
+	SHA1                 string `xml:"sha1,omitempty"`
+	XXHash64BE           string `xml:"xxhash64be,omitempty"`
+	HashDate             string `xml:"hashdate"`
+}
+
+// writeLegacy writes an MHL v1.1 file into root for this ingest
+func writeLegacy(root string, m *Manifest) (string, error) {
+	list := &legacyHashList{
+		Version: "1.1",
+		CreatorInfo: legacyCreatorInfo{
+			Name:       m.Creator.Name,
+			Hostname:   m.Creator.Hostname,
+			Tool:       ToolName + " " + ToolVersion,
+			StartDate:  formatTime(m.Started),
+			FinishDate: formatTime(m.Finished),
+			Log:        m.Creator.Comment,
+		},
+	}
+	if u, err := user.Current(); err == nil {
+		list.CreatorInfo.Username = u.Username
+	}
+
+	for _, entry := range m.Entries {
+		hash := legacyHash{
+			File:                 toSlash(entry.Path),
+			Size:                 entry.Size,
+			LastModificationDate: formatTime(entry.ModTime),
+			HashDate:             formatTime(entry.HashDate),
+		}
+		for _, name := range hashesFor(entry.Digests) {
+			switch name {
+			case checksum.MD5:
+				hash.MD5 = entry.Digests[name]
+			case checksum.SHA1:
+				hash.SHA1 = entry.Digests[name]
+			case checksum.XXH64:
+				hash.XXHash64BE = entry.Digests[name]
+			}
+		}
+		list.Hashes = append(list.Hashes, hash)
+	}
+
+	data, err := marshalXML(list)
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s_%s.mhl", filepath.Base(root), m.Finished.UTC().Format("2006-01-02_150405"))
+	path := filepath.Join(root, name)
+
+	// Two ingests finishing in the same second must not overwrite each other
+	for i := 2; fileExists(path); i++ {
+		path = filepath.Join(root, fmt.Sprintf("%s_%s_%d.mhl", filepath.Base(root), m.Finished.UTC().Format("2006-01-02_150405"), i))
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write MHL file: %w", err)
+	}
+	return path, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
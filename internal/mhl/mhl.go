@@ -0,0 +1,155 @@
+package mhl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/autofileingest/internal/checksum"
+)
+
+// Manifest formats
+const (
+	// FormatASC writes ASC MHL v2 generations into an ascmhl history folder
+	FormatASC = "ascmhl"
+	// FormatLegacy writes a single MHL v1.1 XML file per ingest
+	FormatLegacy = "legacy"
+)
+
+// Hash actions recorded per entry in ASC MHL generations
+const (
+	// ActionOriginal marks the first time a file was hashed in a history
+	ActionOriginal = "original"
+	// ActionVerified marks a file whose hash matched an earlier generation
+	ActionVerified = "verified"
+	// ActionFailed marks a file whose hash no longer matches
+	ActionFailed = "failed"
+)
+
+// Processes recorded in ASC MHL generations
+const (
+	// ProcessTransfer describes files copied into the root
+	ProcessTransfer = "transfer"
+	// ProcessInPlace describes files verified where they already are
+	ProcessInPlace = "in-place"
+)
+
+// Tool identifies this application in generated manifests
+const (
+	ToolName    = "media-ingest"
+	ToolVersion = "1.0"
+)
+
+// Creator describes who and what produced a manifest
+type Creator struct {
+	Name     string
+	Email    string
+	Role     string
+	Location string
+	Comment  string
+	Hostname string
+}
+
+// Entry is a single hashed file, with its path relative to the manifest root
+type Entry struct {
+	Path     string
+	Size     int64
+	ModTime  time.Time
+	HashDate time.Time
+	Digests  checksum.Digests
+	// Action defaults to ActionOriginal
+	Action string
+}
+
+// Manifest is the record of one ingest into a root folder
+type Manifest struct {
+	Creator Creator
+	// Process defaults to ProcessTransfer
+	Process  string
+	Started  time.Time
+	Finished time.Time
+	Entries  []Entry
+}
+
+// rootLocks serializes manifest writes per root so concurrent ingests into
+// the same destination don't claim the same generation number
+var (
+	rootLocksMu sync.Mutex
+	rootLocks   = map[string]*sync.Mutex{}
+)
+
+func lockRoot(root string) func() {
+	rootLocksMu.Lock()
+	l, ok := rootLocks[root]
+	if !ok {
+		l = &sync.Mutex{}
+		rootLocks[root] = l
+	}
+	rootLocksMu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// Write stores a manifest for root in the given format and returns the path
+// of the file written
+func Write(format, root string, m *Manifest) (string, error) {
+	if len(m.Entries) == 0 {
+		return "", fmt.Errorf("manifest has no entries")
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+
+	if m.Creator.Hostname == "" {
+		m.Creator.Hostname, _ = os.Hostname()
+	}
+
+	// Stable ordering makes manifests diffable between runs
+	entries := make([]Entry, len(m.Entries))
+	copy(entries, m.Entries)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	sorted := *m
+	sorted.Entries = entries
+
+	unlock := lockRoot(root)
+	defer unlock()
+
+	switch format {
+	case FormatASC, "":
+		return writeASC(root, &sorted)
+	case FormatLegacy:
+		return writeLegacy(root, &sorted)
+	default:
+		return "", fmt.Errorf("unknown MHL format: %s", format)
+	}
+}
+
+// SupportedAlgorithms lists the hash algorithms both MHL versions can record
+var SupportedAlgorithms = []string{checksum.XXH64, checksum.MD5, checksum.SHA1}
+
+// hashesFor returns the entry digests a manifest can hold, in a stable order
+func hashesFor(d checksum.Digests) []string {
+	var names []string
+	for _, name := range SupportedAlgorithms {
+		if _, ok := d[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// formatTime renders timestamps the way both MHL versions expect
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z07:00")
+}
+
+// toSlash converts a manifest-relative path to the forward-slash form MHL uses
+func toSlash(path string) string {
+	return filepath.ToSlash(path)
+}
@@ -0,0 +1,137 @@
+package mhl
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/autofileingest/internal/checksum"
+)
+
+func testManifest() *Manifest {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	return &Manifest{
+		Creator:  Creator{Name: "DIT", Email: "dit@example.com", Role: "DIT", Hostname: "ingest01"},
+		Started:  now.Add(-time.Minute),
+		Finished: now,
+		Entries: []Entry{
+			{
+				Path:     filepath.Join("Client", "Project", "ACam", "002.mov"),
+				Size:     3,
+				ModTime:  now.Add(-time.Hour),
+				HashDate: now,
+				Digests:  checksum.Digests{checksum.XXH64: "44bc2cf5ad770999", checksum.SHA256: "ignored"},
+			},
+			{
+				Path:     filepath.Join("Client", "Project", "ACam", "001.mov"),
+				Size:     3,
+				ModTime:  now.Add(-time.Hour),
+				HashDate: now,
+				Digests:  checksum.Digests{checksum.MD5: "900150983cd24fb0d6963f7d28e17f72"},
+			},
+		},
+	}
+}
+
+func TestWrite_ASC(t *testing.T) {
+	root := t.TempDir()
+
+	first, err := Write(FormatASC, root, testManifest())
+	if err != nil {
+		t.Fatalf("Failed to write first generation: %v", err)
+	}
+	second, err := Write(FormatASC, root, testManifest())
+	if err != nil {
+		t.Fatalf("Failed to write second generation: %v", err)
+	}
+
+	if !strings.HasPrefix(filepath.Base(first), "0001_") {
+		t.Errorf("Expected first generation to be 0001, got %s", filepath.Base(first))
+	}
+	if !strings.HasPrefix(filepath.Base(second), "0002_") {
+		t.Errorf("Expected second generation to be 0002, got %s", filepath.Base(second))
+	}
+
+	data, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatalf("Failed to read generation: %v", err)
+	}
+
+	var list ascHashList
+	if err := xml.Unmarshal(data, &list); err != nil {
+		t.Fatalf("Generation is not valid XML: %v", err)
+	}
+	if len(list.Hashes) != 2 {
+		t.Fatalf("Expected 2 hashes, got %d", len(list.Hashes))
+	}
+	if list.Hashes[0].Path.Path != "Client/Project/ACam/001.mov" {
+		t.Errorf("Expected sorted, slash-separated paths, got %s", list.Hashes[0].Path.Path)
+	}
+	if list.Hashes[1].XXH64 == nil || list.Hashes[1].XXH64.Value != "44bc2cf5ad770999" {
+		t.Errorf("Expected xxh64 digest to be recorded")
+	}
+	if list.Hashes[0].MD5 == nil || list.Hashes[0].MD5.Action != ActionOriginal {
+		t.Errorf("Expected md5 digest with action=original")
+	}
+	if strings.Contains(string(data), "sha256") {
+		t.Errorf("sha256 is not an ASC MHL hash format and must be omitted")
+	}
+
+	chain, err := readChain(filepath.Join(root, ASCHistoryDir))
+	if err != nil {
+		t.Fatalf("Failed to read chain: %v", err)
+	}
+	if len(chain.Hashlists) != 2 {
+		t.Fatalf("Expected 2 chain entries, got %d", len(chain.Hashlists))
+	}
+	if chain.Hashlists[0].C4 != C4ID(data) {
+		t.Errorf("Chain C4 does not match generation content")
+	}
+}
+
+func TestWrite_Legacy(t *testing.T) {
+	root := t.TempDir()
+
+	path, err := Write(FormatLegacy, root, testManifest())
+	if err != nil {
+		t.Fatalf("Failed to write legacy MHL: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read MHL: %v", err)
+	}
+
+	var list legacyHashList
+	if err := xml.Unmarshal(data, &list); err != nil {
+		t.Fatalf("MHL is not valid XML: %v", err)
+	}
+	if list.Version != "1.1" || len(list.Hashes) != 2 {
+		t.Fatalf("Unexpected legacy MHL content: %s", data)
+	}
+	if list.Hashes[1].XXHash64BE != "44bc2cf5ad770999" {
+		t.Errorf("Expected xxhash64be digest, got %q", list.Hashes[1].XXHash64BE)
+	}
+
+	// A second ingest in the same second gets its own file
+	again, err := Write(FormatLegacy, root, testManifest())
+	if err != nil {
+		t.Fatalf("Failed to write second legacy MHL: %v", err)
+	}
+	if again == path {
+		t.Errorf("Second legacy MHL overwrote the first")
+	}
+}
+
+func TestC4ID(t *testing.T) {
+	id := C4ID([]byte("foo"))
+	if len(id) != 90 || !strings.HasPrefix(id, "c4") {
+		t.Errorf("Expected 90 character c4 ID, got %q", id)
+	}
+	if C4ID([]byte("foo")) != id || C4ID([]byte("bar")) == id {
+		t.Errorf("C4 IDs must be deterministic and content dependent")
+	}
+}
@@ -0,0 +1,304 @@
+package mhl
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/autofileingest/internal/checksum"
+)
+
+// checksumFileAlgorithms maps checksum sidecar extensions to algorithms
+var checksumFileAlgorithms = map[string]string{
+	".md5":   checksum.MD5,
+	".sha1":  checksum.SHA1,
+	".xxh64": checksum.XXH64,
+}
+
+var (
+	// gnuChecksumLine matches md5sum/sha1sum/xxhsum output: "<hex>  <path>"
+	gnuChecksumLine = regexp.MustCompile(`^([0-9a-fA-F]+)\s+\*?(.+)$`)
+	// bsdChecksumLine matches BSD style output: "MD5 (<path>) = <hex>"
+	bsdChecksumLine = regexp.MustCompile(`^[A-Za-z0-9]+ \((.+)\) = ([0-9a-fA-F]+)$`)
+	// bareChecksumLine is a sidecar holding only the digest of its sibling
+	bareChecksumLine = regexp.MustCompile(`^([0-9a-fA-F]+)$`)
+)
+
+// Expected is a digest recorded for a file by the camera or offload tool
+type Expected struct {
+	Digests checksum.Digests
+	// Manifest is the file the digests were read from
+	Manifest string
+	// HistoryRoot is the folder owning the ascmhl history, if any
+	HistoryRoot string
+}
+
+// Reference holds every hash found in manifests on a piece of source media
+type Reference struct {
+	entries map[string]*Expected
+	// Histories lists folders with an ascmhl history
+	Histories []string
+	// Manifests lists every manifest file that was read
+	Manifests []string
+	// Skipped lists the manifests and folders that couldn't be read. The
+	// rest are still loaded.
+	Skipped []error
+}
+
+// LoadReference walks root for ASC MHL histories, MHL v1 files and checksum
+// sidecars (.md5, .sha1, .xxh64) and indexes their digests by absolute path.
+// Malformed manifests and unreadable folders are listed in Skipped.
+func LoadReference(root string) (*Reference, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := &Reference{entries: map[string]*Expected{}}
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			ref.Skipped = append(ref.Skipped, err)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			if info.Name() == ASCHistoryDir {
+				if err := ref.loadASCHistory(filepath.Dir(path)); err != nil {
+					ref.Skipped = append(ref.Skipped, err)
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		ext := strings.ToLower(filepath.Ext(path))
+		switch {
+		case ext == ".mhl":
+			err = ref.loadLegacy(path)
+		case checksumFileAlgorithms[ext] != "":
+			err = ref.loadChecksumFile(path, checksumFileAlgorithms[ext])
+		}
+		if err != nil {
+			ref.Skipped = append(ref.Skipped, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ref, nil
+}
+
+// Len returns the number of files with a recorded hash
+func (r *Reference) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.entries)
+}
+
+// Lookup returns the recorded digests for a source file
+func (r *Reference) Lookup(path string) (*Expected, bool) {
+	if r == nil {
+		return nil, false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, false
+	}
+	e, ok := r.entries[abs]
+	return e, ok
+}
+
+// Algorithms returns the algorithms used by any recorded digest
+func (r *Reference) Algorithms() []string {
+	if r == nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, e := range r.entries {
+		for name := range e.Digests {
+			seen[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// add records digests for a path relative to base. Later manifests add to or
+// replace digests from earlier ones, so the newest generation wins.
+func (r *Reference) add(base, relPath, manifest, historyRoot string, digests checksum.Digests) {
+	if len(digests) == 0 {
+		return
+	}
+
+	path := filepath.Clean(filepath.Join(base, filepath.FromSlash(relPath)))
+	e, ok := r.entries[path]
+	if !ok {
+		e = &Expected{Digests: checksum.Digests{}}
+		r.entries[path] = e
+	}
+	for name, value := range digests {
+		e.Digests[name] = strings.ToLower(value)
+	}
+	e.Manifest = manifest
+	if historyRoot != "" {
+		e.HistoryRoot = historyRoot
+	}
+}
+
+// loadASCHistory reads every generation of the ascmhl history in root
+func (r *Reference) loadASCHistory(root string) error {
+	generations, err := listGenerations(filepath.Join(root, ASCHistoryDir))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range generations {
+		list, err := readGeneration(path)
+		if err != nil {
+			return err
+		}
+
+		for _, hash := range list.Hashes {
+			digests := checksum.Digests{}
+			for name, value := range map[string]*ascHashValue{
+				checksum.MD5:   hash.MD5,
+				checksum.SHA1:  hash.SHA1,
+				checksum.XXH64: hash.XXH64,
+			} {
+				// Entries marked failed record a bad read, not a reference
+				if value != nil && value.Action != ActionFailed {
+					digests[name] = strings.TrimSpace(value.Value)
+				}
+			}
+			r.add(root, strings.TrimSpace(hash.Path.Path), path, root, digests)
+		}
+		r.Manifests = append(r.Manifests, path)
+	}
+
+	r.Histories = append(r.Histories, root)
+	return nil
+}
+
+// loadLegacy reads an MHL v1 file; paths are relative to its folder
+func (r *Reference) loadLegacy(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var list legacyHashList
+	if err := xml.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to parse MHL %s: %w", path, err)
+	}
+
+	for _, hash := range list.Hashes {
+		digests := checksum.Digests{}
+		if hash.MD5 != "" {
+			digests[checksum.MD5] = strings.TrimSpace(hash.MD5)
+		}
+		if hash.SHA1 != "" {
+			digests[checksum.SHA1] = strings.TrimSpace(hash.SHA1)
+		}
+		if hash.XXHash64BE != "" {
+			digests[checksum.XXH64] = strings.TrimSpace(hash.XXHash64BE)
+		}
+		r.add(filepath.Dir(path), strings.TrimSpace(hash.File), path, "", digests)
+	}
+
+	r.Manifests = append(r.Manifests, path)
+	return nil
+}
+
+// loadChecksumFile reads md5sum-style files, BSD-style files and bare
+// single-digest sidecars such as clip.mov.md5
+func (r *Reference) loadChecksumFile(path, algorithm string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(path)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if m := bsdChecksumLine.FindStringSubmatch(line); m != nil {
+			r.add(dir, m[1], path, "", checksum.Digests{algorithm: m[2]})
+		} else if m := bareChecksumLine.FindStringSubmatch(line); m != nil {
+			sibling := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			r.add(dir, sibling, path, "", checksum.Digests{algorithm: m[1]})
+		} else if m := gnuChecksumLine.FindStringSubmatch(line); m != nil {
+			r.add(dir, m[2], path, "", checksum.Digests{algorithm: m[1]})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	r.Manifests = append(r.Manifests, path)
+	return nil
+}
+
+// listGenerations returns the generation files of a history in sequence order
+func listGenerations(historyDir string) ([]string, error) {
+	entries, err := os.ReadDir(historyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	type generation struct {
+		seq  int
+		path string
+	}
+	var generations []generation
+	for _, entry := range entries {
+		if match := generationPattern.FindStringSubmatch(entry.Name()); match != nil {
+			seq, _ := strconv.Atoi(match[1])
+			generations = append(generations, generation{seq, filepath.Join(historyDir, entry.Name())})
+		}
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i].seq < generations[j].seq })
+
+	paths := make([]string, len(generations))
+	for i, g := range generations {
+		paths[i] = g.path
+	}
+	return paths, nil
+}
+
+// readGeneration parses one ASC MHL generation file
+func readGeneration(path string) (*ascHashList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var list ascHashList
+	if err := xml.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse MHL %s: %w", path, err)
+	}
+	return &list, nil
+}
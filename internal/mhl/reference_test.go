@@ -0,0 +1,109 @@
+package mhl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/autofileingest/internal/checksum"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestLoadReference(t *testing.T) {
+	card := t.TempDir()
+
+	writeFile(t, filepath.Join(card, "DCIM", "checksums.md5"),
+		"900150983cd24fb0d6963f7d28e17f72  clip1.mp4\n"+
+			"# comment\n"+
+			"d41d8cd98f00b204e9800998ecf8427e *sub/clip2.mp4\n")
+	writeFile(t, filepath.Join(card, "DCIM", "bsd.sha1"),
+		"SHA1 (clip1.mp4) = a9993e364706816aba3e25717850c26c9cd0d89d\n")
+	writeFile(t, filepath.Join(card, "AUDIO", "take1.wav.md5"), "0cc175b9c0f1b6a831c399e269772661\n")
+
+	// Camera-written ascmhl history with one generation
+	cardMHL := &Manifest{
+		Finished: time.Now(),
+		Entries: []Entry{{
+			Path:    "CLIPS/A001.mov",
+			Size:    3,
+			Digests: checksum.Digests{checksum.XXH64: "44bc2cf5ad770999"},
+		}},
+	}
+	if _, err := Write(FormatASC, card, cardMHL); err != nil {
+		t.Fatalf("Failed to write card history: %v", err)
+	}
+
+	ref, err := LoadReference(card)
+	if err != nil {
+		t.Fatalf("LoadReference failed: %v", err)
+	}
+
+	tests := []struct {
+		path      string
+		algorithm string
+		digest    string
+	}{
+		{"DCIM/clip1.mp4", checksum.MD5, "900150983cd24fb0d6963f7d28e17f72"},
+		{"DCIM/clip1.mp4", checksum.SHA1, "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{"DCIM/sub/clip2.mp4", checksum.MD5, "d41d8cd98f00b204e9800998ecf8427e"},
+		{"AUDIO/take1.wav", checksum.MD5, "0cc175b9c0f1b6a831c399e269772661"},
+		{"CLIPS/A001.mov", checksum.XXH64, "44bc2cf5ad770999"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+"_"+tt.algorithm, func(t *testing.T) {
+			expected, ok := ref.Lookup(filepath.Join(card, filepath.FromSlash(tt.path)))
+			if !ok {
+				t.Fatalf("No reference hash for %s", tt.path)
+			}
+			if expected.Digests[tt.algorithm] != tt.digest {
+				t.Errorf("Expected %s=%s, got %s", tt.algorithm, tt.digest, expected.Digests[tt.algorithm])
+			}
+		})
+	}
+
+	expected, _ := ref.Lookup(filepath.Join(card, "CLIPS", "A001.mov"))
+	if expected.HistoryRoot != card {
+		t.Errorf("Expected history root %s, got %s", card, expected.HistoryRoot)
+	}
+	if len(ref.Histories) != 1 {
+		t.Errorf("Expected one ascmhl history, got %d", len(ref.Histories))
+	}
+	if _, ok := ref.Lookup(filepath.Join(card, "missing.mov")); ok {
+		t.Errorf("Did not expect a hash for an unlisted file")
+	}
+}
+
+func TestReference_NilSafe(t *testing.T) {
+	var ref *Reference
+	if _, ok := ref.Lookup("/any"); ok || ref.Len() != 0 {
+		t.Errorf("Nil reference must behave as empty")
+	}
+}
+
+func TestLoadReference_SkipsBrokenManifests(t *testing.T) {
+	card := t.TempDir()
+	writeFile(t, filepath.Join(card, "A", "offload.mhl"), "<hashlist><hash><file>broken")
+	writeFile(t, filepath.Join(card, "B", "checksums.md5"), "900150983cd24fb0d6963f7d28e17f72  clip1.mp4\n")
+
+	ref, err := LoadReference(card)
+	if err != nil {
+		t.Fatalf("Expected a broken manifest not to stop loading, got %v", err)
+	}
+	if len(ref.Skipped) != 1 {
+		t.Errorf("Expected the broken manifest listed as skipped, got %v", ref.Skipped)
+	}
+	if _, ok := ref.Lookup(filepath.Join(card, "B", "clip1.mp4")); !ok {
+		t.Error("Expected the hashes of the other manifests to be loaded")
+	}
+}
@@ -44,6 +44,9 @@ func (m *Monitor) Start() error {
 	// Initial scan for already connected devices
 	go m.scanExistingDevices()
 
+	// Check stored files in the background when scrubbing is enabled
+	m.deviceMgr.StartScrubber()
+
 	return nil
 }
 
@@ -51,6 +54,9 @@ func (m *Monitor) Start() error {
 func (m *Monitor) Stop() {
 	close(m.stopChan)
 	m.deviceMgr.StopWatching()
+	if err := m.deviceMgr.Close(); err != nil {
+		m.logger.Error("Failed to close catalog: %v", err)
+	}
 	m.logger.Info("Device monitoring stopped")
 }
 
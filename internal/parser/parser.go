@@ -19,6 +19,10 @@ type FileInfo struct {
 	ClipNumber   string
 	Extension    string
 	Matched      bool
+	// Roll is the camera roll of the card, set by the ingest
+	Roll string
+	// Span is set for the segments of a spanned clip
+	Span *Span
 }
 
 // Parser handles filename parsing
@@ -68,29 +72,63 @@ func (p *Parser) Parse(filePath string) *FileInfo {
 	return info
 }
 
+// Layout describes how files are organized under a destination root
+type Layout struct {
+	Root            string
+	FolderStructure string
+	UnmatchedFolder string
+}
+
+// DefaultLayout returns the layout of destination_path and the parsing config
+func (p *Parser) DefaultLayout() Layout {
+	return Layout{
+		Root:            p.config.DestinationPath,
+		FolderStructure: p.config.Parsing.FolderStructure,
+		UnmatchedFolder: p.config.Parsing.UnmatchedFolder,
+	}
+}
+
 // GetDestinationPath returns the organized destination path for a file
 func (p *Parser) GetDestinationPath(info *FileInfo) string {
-	basePath := p.config.DestinationPath
+	return p.GetDestinationPathFor(info, p.DefaultLayout())
+}
+
+// GetDestinationPathFor returns the organized folder for a file in a layout
+func (p *Parser) GetDestinationPathFor(info *FileInfo, layout Layout) string {
+	basePath := layout.Root
 
 	if !info.Matched {
 		// Files that don't match go to unsorted folder
-		return filepath.Join(basePath, p.config.Parsing.UnmatchedFolder)
+		return filepath.Join(basePath, layout.UnmatchedFolder)
 	}
 
 	// Build path from folder structure template
-	structure := p.config.Parsing.FolderStructure
-	structure = strings.ReplaceAll(structure, "{client}", info.Client)
-	structure = strings.ReplaceAll(structure, "{project}", info.ProjectName)
-	structure = strings.ReplaceAll(structure, "{camera}", info.Camera)
+	structure := p.ExpandTemplate(layout.FolderStructure, info)
 
 	return filepath.Join(basePath, structure)
 }
 
+// ExpandTemplate replaces the {client}, {project}, {camera} and {roll}
+// tokens of a template with the values of a file
+func (p *Parser) ExpandTemplate(template string, info *FileInfo) string {
+	template = strings.ReplaceAll(template, "{client}", info.Client)
+	template = strings.ReplaceAll(template, "{project}", info.ProjectName)
+	template = strings.ReplaceAll(template, "{camera}", info.Camera)
+	template = strings.ReplaceAll(template, "{roll}", info.Roll)
+	return template
+}
+
 // GetFullDestinationPath returns the complete destination path including filename
 func (p *Parser) GetFullDestinationPath(info *FileInfo) string {
-	destDir := p.GetDestinationPath(info)
+	return p.GetFullDestinationPathFor(info, p.DefaultLayout())
+}
+
+// GetFullDestinationPathFor returns the complete path of a file in a layout
+func (p *Parser) GetFullDestinationPathFor(info *FileInfo, layout Layout) string {
+	destDir := p.GetDestinationPathFor(info, layout)
 	
-	if info.Matched {
+	// Segments keep their card names so players and editors can join them
+	if info.Matched && info.Span == nil {
 		fileName := fmt.Sprintf("%s%s", info.ClipNumber, info.Extension)
 		return filepath.Join(destDir, fileName)
 	}
@@ -100,7 +138,12 @@ func (p *Parser) GetFullDestinationPath(info *FileInfo) string {
 
 // GetUniqueDestinationPath ensures the destination path is unique by adding version numbers
 func (p *Parser) GetUniqueDestinationPath(info *FileInfo) (string, error) {
-	destPath := p.GetFullDestinationPath(info)
+	return p.GetUniqueDestinationPathFor(info, p.DefaultLayout())
+}
+
+// GetUniqueDestinationPathFor is GetUniqueDestinationPath for a given layout
+func (p *Parser) GetUniqueDestinationPathFor(info *FileInfo, layout Layout) (string, error) {
+	destPath := p.GetFullDestinationPathFor(info, layout)
 	
 	// Check if file exists
 	if _, err := filepath.Glob(destPath); err == nil {
@@ -136,3 +136,19 @@ func TestParser_GetDestinationPath(t *testing.T) {
 		})
 	}
 }
+
+func TestParser_ExpandTemplateRoll(t *testing.T) {
+	cfg := &config.Config{
+		Parsing: config.ParsingConfig{Pattern: "^([^_]+)_([^_]+)_(ACam|BCam|CCam)_(.+)$"},
+	}
+	parser, err := NewParser(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+
+	info := parser.Parse("BrandVideo_Nike_ACam_001.mp4")
+	info.Roll = "A003"
+	if got := parser.ExpandTemplate("{client}/{project}/{camera}/{roll}", info); got != "Nike/BrandVideo/ACam/A003" {
+		t.Errorf("Expected the roll expanded, got %s", got)
+	}
+}
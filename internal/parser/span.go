@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Span places a file within a clip the camera split over several files,
+// such as GoPro chapters or FAT32 4GB splits
+type Span struct {
+	// Clip is the file name of the first segment, naming the clip
+	Clip string
+	// Lead is the path of the first segment, identifying the clip
+	Lead string
+	// Part is the position of the segment, starting at 1
+	Part  int
+	Parts int
+}
+
+// segmentPattern recognizes the segments of one naming scheme. The key
+// groups are joined to identify the clip, part is the segment number.
+type segmentPattern struct {
+	re   *regexp.Regexp
+	key  []int
+	part int
+}
+
+var segmentPatterns = []segmentPattern{
+	// GoPro HERO6 and later: GX010001.MP4, GX020001.MP4 (also GH, GL proxies)
+	{regexp.MustCompile(`(?i)^(G[HXL])(\d{2})(\d{4})(\.(?:mp4|lrv|thm))$`), []int{1, 3, 4}, 2},
+	// Older GoPros: GOPR0001.MP4 followed by GP010001.MP4, GP020001.MP4
+	{regexp.MustCompile(`(?i)^(GOPR)()(\d{4})(\.(?:mp4|lrv|thm))$`), []int{3, 4}, 2},
+	{regexp.MustCompile(`(?i)^(GP)(\d{2})(\d{4})(\.(?:mp4|lrv|thm))$`), []int{3, 4}, 2},
+	// Canon XF: AA000101.MXF, AA000102.MXF
+	{regexp.MustCompile(`(?i)^([A-Z]{2}\d{4})(\d{2})(\.mxf)$`), []int{1, 3}, 2},
+	// Sony XDCAM EX and XAVC: 401_0012_01.MP4, 401_0012_02.MP4
+	{regexp.MustCompile(`(?i)^(\d{3}_\d{4})_(\d{2})(\.(?:mp4|mxf))$`), []int{1, 3}, 2},
+	// FAT32 splits: clip.mov.001, clip.mov.002
+	{regexp.MustCompile(`(?i)^(.+\.[a-z0-9]{2,4})\.(\d{3})$`), []int{1}, 2},
+}
+
+// segment returns the clip key and segment number of a file name that
+// looks like part of a spanned clip
+func segment(name string) (string, int, bool) {
+	for _, p := range segmentPatterns {
+		m := p.re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		var key []string
+		for _, i := range p.key {
+			key = append(key, strings.ToUpper(m[i]))
+		}
+		// GOPR0001 has no chapter number and counts as 0
+		part, _ := strconv.Atoi(m[p.part])
+		return strings.Join(key, "|"), part, true
+	}
+	return "", 0, false
+}
+
+// GroupSpans finds the segments of spanned clips among files. It returns
+// the span of each segment by path. Files that aren't one of at least two
+// segments in the same folder are left out.
+func GroupSpans(files []string) map[string]Span {
+	type part struct {
+		path   string
+		number int
+	}
+	groups := map[string][]part{}
+	for _, path := range files {
+		key, number, ok := segment(filepath.Base(path))
+		if !ok {
+			continue
+		}
+		key = filepath.Dir(path) + "|" + key
+		groups[key] = append(groups[key], part{path, number})
+	}
+
+	spans := map[string]Span{}
+	for _, parts := range groups {
+		if len(parts) < 2 {
+			continue
+		}
+		sort.Slice(parts, func(i, j int) bool { return parts[i].number < parts[j].number })
+		lead := parts[0].path
+		for i, p := range parts {
+			spans[p.path] = Span{Clip: filepath.Base(lead), Lead: lead, Part: i + 1, Parts: len(parts)}
+		}
+	}
+	return spans
+}
+
+// ParseSegment parses a segment of a spanned clip with the tokens of its
+// first segment, so all segments are routed to the same folder
+func (p *Parser) ParseSegment(filePath string, span Span) *FileInfo {
+	info := p.Parse(filePath)
+	lead := p.Parse(span.Lead)
+
+	info.ProjectName = lead.ProjectName
+	info.Client = lead.Client
+	info.Camera = lead.Camera
+	// Segments are named by file name; the clip number is for the catalog
+	info.ClipNumber = lead.ClipNumber
+	info.Matched = lead.Matched
+	info.Span = &span
+	return info
+}
@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/autofileingest/internal/config"
+)
+
+func TestGroupSpans(t *testing.T) {
+	card := filepath.Join("media", "card")
+	gopro := filepath.Join(card, "DCIM", "100GOPRO")
+	files := []string{
+		filepath.Join(gopro, "GX020042.MP4"),
+		filepath.Join(gopro, "GX010042.MP4"),
+		filepath.Join(gopro, "GX030042.MP4"),
+		filepath.Join(gopro, "GL010042.LRV"),
+		filepath.Join(gopro, "GX010043.MP4"),
+		filepath.Join(gopro, "GP010007.MP4"),
+		filepath.Join(gopro, "GOPR0007.MP4"),
+		filepath.Join(card, "CLIPS001", "AA0001", "AA000102.MXF"),
+		filepath.Join(card, "CLIPS001", "AA0001", "AA000101.MXF"),
+		filepath.Join(card, "BPAV", "401_0012_01.MP4"),
+		filepath.Join(card, "BPAV", "401_0012_02.MP4"),
+		filepath.Join(card, "Nike_Ad_ACam_001.mov.001"),
+		filepath.Join(card, "Nike_Ad_ACam_001.mov.002"),
+		filepath.Join(card, "Nike_Ad_ACam_002.mov"),
+	}
+
+	spans := GroupSpans(files)
+
+	tests := []struct {
+		file  string
+		lead  string
+		part  int
+		parts int
+	}{
+		{files[0], files[1], 2, 3},
+		{files[1], files[1], 1, 3},
+		{files[2], files[1], 3, 3},
+		{files[5], files[6], 2, 2},
+		{files[7], files[8], 2, 2},
+		{files[10], files[9], 2, 2},
+		{files[12], files[11], 2, 2},
+	}
+	for _, tt := range tests {
+		span, ok := spans[tt.file]
+		if !ok {
+			t.Errorf("Expected %s to be a segment", tt.file)
+			continue
+		}
+		if span.Lead != tt.lead || span.Part != tt.part || span.Parts != tt.parts {
+			t.Errorf("Expected %s to be part %d of %d led by %s, got %+v", tt.file, tt.part, tt.parts, tt.lead, span)
+		}
+	}
+
+	// Single chapters, lone proxies and ordinary clips aren't spanned
+	for _, file := range []string{files[3], files[4], files[13]} {
+		if span, ok := spans[file]; ok {
+			t.Errorf("Expected %s not to be a segment, got %+v", file, span)
+		}
+	}
+	if len(spans) != len(files)-3 {
+		t.Errorf("Expected %d segments, got %d", len(files)-3, len(spans))
+	}
+}
+
+func TestParser_ParseSegment(t *testing.T) {
+	cfg := &config.Config{
+		Parsing: config.ParsingConfig{
+			Pattern:         "^([^_]+)_([^_]+)_(ACam|BCam|CCam)_(.+)$",
+			FolderStructure: "{client}/{project}/{camera}",
+			UnmatchedFolder: "Unsorted",
+		},
+		DestinationPath: "/mnt/storage",
+	}
+	parser, err := NewParser(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+
+	spans := GroupSpans([]string{"/card/Nike_Ad_ACam_001.mov.001", "/card/Nike_Ad_ACam_001.mov.002"})
+	info := parser.ParseSegment("/card/Nike_Ad_ACam_001.mov.002", spans["/card/Nike_Ad_ACam_001.mov.002"])
+
+	if !info.Matched || info.Client != "Ad" || info.Span == nil || info.Span.Part != 2 {
+		t.Fatalf("Expected the segment parsed with the tokens of the clip, got %+v", info)
+	}
+	// Segments keep their card names next to each other
+	if got := filepath.ToSlash(parser.GetFullDestinationPath(info)); got != "/mnt/storage/Ad/Nike/ACam/Nike_Ad_ACam_001.mov.002" {
+		t.Errorf("Expected the segment under its card name, got %s", got)
+	}
+}
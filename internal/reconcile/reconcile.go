@@ -0,0 +1,255 @@
+// Package reconcile compares the catalog with the files actually stored on
+// local destinations, finding files nobody recorded, records whose file is
+// gone, and files that were moved or renamed by hand.
+package reconcile
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/autofileingest/internal/catalog"
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/logger"
+	"github.com/autofileingest/internal/mhl"
+	"github.com/autofileingest/internal/storage"
+)
+
+// Orphan is a stored file no catalog record refers to
+type Orphan struct {
+	Destination string
+	RelPath     string
+	Size        int64
+}
+
+// Missing is a recorded copy whose file isn't on the destination
+type Missing struct {
+	FileID      int64
+	Destination string
+	RelPath     string
+}
+
+// Moved is a recorded copy found at another path by its hash
+type Moved struct {
+	FileID      int64
+	Destination string
+	From        string
+	To          string
+	// Relinked is set when the record was updated to the new path
+	Relinked bool
+}
+
+// Report is the result of a reconciliation
+type Report struct {
+	Started  time.Time
+	Finished time.Time
+	// Scanned counts the files found on the destinations
+	Scanned int
+	Orphans []Orphan
+	Missing []Missing
+	Moved   []Moved
+}
+
+// Write prints the report for people
+func (r Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "Reconciliation %s: %d files scanned, %d orphans, %d missing, %d moved\n",
+		r.Started.Format("2006-01-02 15:04"), r.Scanned, len(r.Orphans), len(r.Missing), len(r.Moved))
+	for _, m := range r.Moved {
+		state := ""
+		if m.Relinked {
+			state = " (relinked)"
+		}
+		fmt.Fprintf(w, "  Moved on %s: %s -> %s%s\n", m.Destination, m.From, m.To, state)
+	}
+	for _, m := range r.Missing {
+		fmt.Fprintf(w, "  Missing on %s: %s (file %d)\n", m.Destination, m.RelPath, m.FileID)
+	}
+	for _, o := range r.Orphans {
+		fmt.Fprintf(w, "  Orphan on %s: %s\n", o.Destination, o.RelPath)
+	}
+}
+
+// recordedCopy is one copy of a catalog file on the destination being
+// reconciled
+type recordedCopy struct {
+	file  catalog.File
+	index int
+}
+
+// Run reconciles every local destination with the catalog. With relink,
+// records of moved files are updated to their new paths.
+func Run(cfg *config.Config, cat *catalog.Catalog, log *logger.Logger, relink bool) (Report, error) {
+	report := Report{Started: time.Now()}
+
+	for _, destCfg := range cfg.GetDestinations() {
+		store, err := storage.New(destCfg)
+		if err != nil {
+			return report, fmt.Errorf("destination %s: %w", destCfg.Name, err)
+		}
+		local, ok := store.(*storage.Local)
+		if !ok {
+			continue
+		}
+		// An unmounted volume would make every file look missing
+		if err := local.Check(); err != nil {
+			log.Error("Reconcile: skipping destination %s: %v", destCfg.Name, err)
+			continue
+		}
+
+		if err := reconcileDestination(cfg, cat, local, relink, &report); err != nil {
+			return report, fmt.Errorf("destination %s: %w", destCfg.Name, err)
+		}
+	}
+
+	report.Finished = time.Now()
+	log.Info("Reconciliation finished: %d files scanned, %d orphans, %d missing, %d moved",
+		report.Scanned, len(report.Orphans), len(report.Missing), len(report.Moved))
+	return report, nil
+}
+
+// reconcileDestination compares one destination with its records
+func reconcileDestination(cfg *config.Config, cat *catalog.Catalog, local *storage.Local, relink bool, report *Report) error {
+	name := local.Name()
+
+	onDisk, err := scan(local.Root())
+	if err != nil {
+		return err
+	}
+	report.Scanned += len(onDisk)
+
+	// Hard-linked duplicates are recorded as skipped at their linked paths
+	recorded := map[string][]recordedCopy{}
+	for _, status := range []string{catalog.StatusCopied, catalog.StatusSkipped} {
+		for _, file := range cat.Files(catalog.FileQuery{Destination: name, Status: status}) {
+			for i, c := range file.Copies {
+				if c.Destination == name {
+					recorded[c.RelPath] = append(recorded[c.RelPath], recordedCopy{file: file, index: i})
+				}
+			}
+		}
+	}
+
+	// Files on disk that no record points at
+	orphans := map[string]int64{}
+	for rel, size := range onDisk {
+		if _, ok := recorded[rel]; !ok {
+			orphans[rel] = size
+		}
+	}
+
+	// Records whose file is gone, matched to orphans by size then hash
+	var missingPaths []string
+	for rel := range recorded {
+		if _, ok := onDisk[rel]; !ok {
+			missingPaths = append(missingPaths, rel)
+		}
+	}
+	sort.Strings(missingPaths)
+
+	orphanHashes := map[string]checksum.Digests{}
+	for _, rel := range missingPaths {
+		for _, rc := range recorded[rel] {
+			to := findMoved(local, rc.file, orphans, orphanHashes, cfg.Transfer.HashAlgorithms)
+			if to == "" {
+				report.Missing = append(report.Missing, Missing{FileID: rc.file.ID, Destination: name, RelPath: rel})
+				continue
+			}
+			delete(orphans, to)
+
+			moved := Moved{FileID: rc.file.ID, Destination: name, From: rel, To: to}
+			if relink {
+				file, _ := cat.File(rc.file.ID)
+				file.Copies[rc.index].RelPath = to
+				file.Copies[rc.index].Location = local.Location(to)
+				if _, err := cat.PutFile(file); err != nil {
+					return err
+				}
+				moved.Relinked = true
+			}
+			report.Moved = append(report.Moved, moved)
+		}
+	}
+
+	for rel, size := range orphans {
+		report.Orphans = append(report.Orphans, Orphan{Destination: name, RelPath: rel, Size: size})
+	}
+	sort.Slice(report.Orphans, func(i, j int) bool { return report.Orphans[i].RelPath < report.Orphans[j].RelPath })
+	return nil
+}
+
+// findMoved returns the orphan with the size and recorded hash of a file,
+// hashing each orphan of a matching size at most once
+func findMoved(local *storage.Local, file catalog.File, orphans map[string]int64, hashes map[string]checksum.Digests, preferred []string) string {
+	algorithm := file.Algorithm(preferred)
+	if algorithm == "" {
+		return ""
+	}
+
+	var candidates []string
+	for rel, size := range orphans {
+		if size == file.Size {
+			candidates = append(candidates, rel)
+		}
+	}
+	sort.Strings(candidates)
+
+	for _, rel := range candidates {
+		digests := hashes[rel]
+		if _, ok := digests[algorithm]; !ok {
+			sum, err := local.Hash(rel, []string{algorithm})
+			if err != nil {
+				continue
+			}
+			if digests == nil {
+				digests = checksum.Digests{}
+				hashes[rel] = digests
+			}
+			digests[algorithm] = sum[algorithm]
+		}
+		if strings.EqualFold(digests[algorithm], file.Checksums[algorithm]) {
+			return rel
+		}
+	}
+	return ""
+}
+
+// scan lists the files under root by slash-separated relative path.
+// Hidden files, partial uploads and MHL manifests aren't media and are
+// left out.
+func scan(root string) (map[string]int64, error) {
+	files := map[string]int64{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if path != root && strings.HasPrefix(name, ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if name == mhl.ASCHistoryDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.EqualFold(filepath.Ext(name), ".mhl") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = info.Size()
+		return nil
+	})
+	return files, err
+}
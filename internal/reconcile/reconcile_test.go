@@ -0,0 +1,124 @@
+package reconcile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/autofileingest/internal/catalog"
+	"github.com/autofileingest/internal/catalog/catalogtest"
+)
+
+// newReconcileFixture stores clips on a single destination, nas
+func newReconcileFixture(t *testing.T) *catalogtest.Fixture {
+	t.Helper()
+	return catalogtest.New(t, "nas")
+}
+
+// move renames a file on the destination by hand
+func move(t *testing.T, f *catalogtest.Fixture, from, to string) {
+	t.Helper()
+	dest := f.Path("nas", to)
+	os.MkdirAll(filepath.Dir(dest), 0755)
+	if err := os.Rename(f.Path("nas", from), dest); err != nil {
+		t.Fatalf("Failed to move %s: %v", from, err)
+	}
+}
+
+func TestRun_FindsDrift(t *testing.T) {
+	f := newReconcileFixture(t)
+	f.Add(t, "Ad/Nike/ACam/001.mov", "in place")
+	gone := f.Add(t, "Ad/Nike/ACam/002.mov", "deleted by hand")
+	moved := f.Add(t, "Ad/Nike/ACam/003.mov", "moved by hand")
+	move(t, f, "Ad/Nike/ACam/003.mov", "Archive/Nike/003.mov")
+	os.Remove(filepath.Join(f.Roots["nas"], "Ad/Nike/ACam/002.mov"))
+	// Same size as the deleted clip but different content
+	f.Write(t, "nas", "Ad/Nike/ACam/stray.mov", "deleted by HAND")
+	f.Write(t, "nas", "Ad/Nike/ascmhl/0001_Nike.mhl", "<hashlist/>")
+	f.Write(t, "nas", "Ad/.DS_Store", "finder")
+
+	report, err := Run(f.Config, f.Catalog, f.Log, false)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	if report.Scanned != 3 {
+		t.Errorf("Expected 3 media files scanned, got %d", report.Scanned)
+	}
+	if len(report.Moved) != 1 || report.Moved[0].FileID != moved.ID ||
+		report.Moved[0].To != "Archive/Nike/003.mov" || report.Moved[0].Relinked {
+		t.Errorf("Expected 003.mov found in the archive without relinking, got %+v", report.Moved)
+	}
+	if len(report.Missing) != 1 || report.Missing[0].FileID != gone.ID {
+		t.Errorf("Expected 002.mov reported missing, got %+v", report.Missing)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0].RelPath != "Ad/Nike/ACam/stray.mov" {
+		t.Errorf("Expected only the stray clip reported as an orphan, got %+v", report.Orphans)
+	}
+
+	if file, _ := f.Catalog.File(moved.ID); file.Copies[0].RelPath != "Ad/Nike/ACam/003.mov" {
+		t.Errorf("Expected the record left alone without relink, got %s", file.Copies[0].RelPath)
+	}
+
+	var out bytes.Buffer
+	report.Write(&out)
+	if !strings.Contains(out.String(), "Archive/Nike/003.mov") {
+		t.Errorf("Expected the move in the printed report, got %q", out.String())
+	}
+}
+
+func TestRun_Relink(t *testing.T) {
+	f := newReconcileFixture(t)
+	moved := f.Add(t, "Ad/Nike/ACam/001.mov", "moved by hand")
+	move(t, f, "Ad/Nike/ACam/001.mov", "Ad/Nike Spring/ACam/001.mov")
+
+	report, err := Run(f.Config, f.Catalog, f.Log, true)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(report.Moved) != 1 || !report.Moved[0].Relinked {
+		t.Fatalf("Expected the moved clip relinked, got %+v", report.Moved)
+	}
+
+	file, _ := f.Catalog.File(moved.ID)
+	want := "Ad/Nike Spring/ACam/001.mov"
+	if file.Copies[0].RelPath != want || file.Copies[0].Location != filepath.Join(f.Roots["nas"], want) {
+		t.Errorf("Expected the record relinked to %s, got %+v", want, file.Copies[0])
+	}
+
+	// Once relinked there's nothing left to report
+	report, err = Run(f.Config, f.Catalog, f.Log, true)
+	if err != nil || len(report.Moved)+len(report.Missing)+len(report.Orphans) != 0 {
+		t.Errorf("Expected no drift after relinking, got %+v (%v)", report, err)
+	}
+}
+
+func TestRun_LinkedDuplicateIsNotAnOrphan(t *testing.T) {
+	f := newReconcileFixture(t)
+	original := f.Add(t, "Ad/Nike/ACam/001.mov", "shot twice")
+	if err := os.Link(f.Path("nas", "Ad/Nike/ACam/001.mov"), f.Path("nas", "Ad/Nike/ACam/001_v2.mov")); err != nil {
+		t.Fatalf("Failed to link duplicate: %v", err)
+	}
+	f.Catalog.PutFile(catalog.File{
+		IngestID:   2,
+		SourcePath: "/media/card2/001.mov",
+		Size:       original.Size,
+		Status:     catalog.StatusSkipped,
+		Checksums:  original.Checksums,
+		Copies: []catalog.Copy{{
+			Destination: "nas",
+			RelPath:     "Ad/Nike/ACam/001_v2.mov",
+			Location:    f.Path("nas", "Ad/Nike/ACam/001_v2.mov"),
+		}},
+	})
+
+	report, err := Run(f.Config, f.Catalog, f.Log, false)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(report.Orphans)+len(report.Missing) != 0 {
+		t.Errorf("Expected the linked duplicate to be recorded, got %+v", report)
+	}
+}
@@ -0,0 +1,213 @@
+// Package roll numbers the cards of each camera as rolls, A001, A002,
+// B001... Counters are kept in a small JSON file so numbering carries on
+// across restarts.
+package roll
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/autofileingest/internal/jsonfile"
+)
+
+// MarkerName is the file recording the roll of a card on the card itself
+const MarkerName = ".media-ingest-roll"
+
+// dayFormat is the shoot day of per-day numbering
+const dayFormat = "2006-01-02"
+
+// Roll is a roll assigned to a card
+type Roll struct {
+	ID     string `json:"id"`
+	Camera string `json:"camera"`
+	Number int    `json:"number"`
+	// Day is the shoot day when numbering restarts daily
+	Day      string    `json:"day,omitempty"`
+	Assigned time.Time `json:"assigned"`
+}
+
+// rollPattern matches roll IDs such as A001, A_012 or CAM-3
+var rollPattern = regexp.MustCompile(`^([A-Za-z]{1,4})[_-]?(\d{1,4})$`)
+
+// Parse reads a roll ID such as a card label. The ID keeps the digits as
+// typed until the roll is claimed.
+func Parse(id string) (Roll, bool) {
+	m := rollPattern.FindStringSubmatch(strings.TrimSpace(id))
+	if m == nil {
+		return Roll{}, false
+	}
+	n, _ := strconv.Atoi(m[2])
+	if n == 0 {
+		return Roll{}, false
+	}
+	return Roll{ID: strings.ToUpper(m[1]) + m[2], Camera: strings.ToUpper(m[1]), Number: n}, true
+}
+
+// CameraLetter returns the roll letter of a parsed camera token: its
+// mapping in cameras, else its first letter
+func CameraLetter(token string, cameras map[string]string) string {
+	for name, letter := range cameras {
+		if strings.EqualFold(name, token) {
+			return strings.ToUpper(letter)
+		}
+	}
+	for _, r := range token {
+		if unicode.IsLetter(r) {
+			return string(unicode.ToUpper(r))
+		}
+	}
+	return ""
+}
+
+// state is the content of the counter file
+type state struct {
+	// Counters holds the last roll number per camera, or per camera and
+	// day when numbering restarts daily
+	Counters map[string]int `json:"counters"`
+	// Cards holds the roll of each card by identity, so a card inserted
+	// again keeps its roll
+	Cards map[string]Roll `json:"cards"`
+}
+
+// Allocator hands out roll numbers. It's safe for concurrent use.
+type Allocator struct {
+	mu     sync.Mutex
+	path   string
+	digits int
+	perDay bool
+	state  state
+}
+
+// Open loads the counters at path, starting empty when there are none
+func Open(path string, digits int, perDay bool) (*Allocator, error) {
+	a := &Allocator{
+		path:   path,
+		digits: digits,
+		perDay: perDay,
+		state:  state{Counters: map[string]int{}, Cards: map[string]Roll{}},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &a.state); err != nil {
+		return nil, fmt.Errorf("invalid roll counters %s: %w", path, err)
+	}
+	if a.state.Counters == nil {
+		a.state.Counters = map[string]int{}
+	}
+	if a.state.Cards == nil {
+		a.state.Cards = map[string]Roll{}
+	}
+	return a, nil
+}
+
+// Card returns the roll recorded for a card identity
+func (a *Allocator) Card(identity string) (Roll, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r, ok := a.state.Cards[identity]
+	return r, ok && identity != ""
+}
+
+// Next assigns the next roll of a camera to a card. A card already
+// recorded under identity keeps its roll.
+func (a *Allocator) Next(camera, identity string, now time.Time) (Roll, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if r, ok := a.state.Cards[identity]; ok && identity != "" {
+		return r, nil
+	}
+
+	r := Roll{Camera: strings.ToUpper(camera), Assigned: now}
+	if a.perDay {
+		r.Day = now.Format(dayFormat)
+	}
+	key := a.key(r)
+	r.Number = a.state.Counters[key] + 1
+	r.ID = a.format(r)
+
+	a.state.Counters[key] = r.Number
+	if identity != "" {
+		a.state.Cards[identity] = r
+	}
+	return r, a.save()
+}
+
+// Claim records a roll named elsewhere, such as by the card label or a
+// marker, so its number isn't handed out again. Its ID is written with the
+// configured digits, so A_12 becomes A012 like an allocated roll.
+func (a *Allocator) Claim(r Roll, identity string, now time.Time) (Roll, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r.Camera = strings.ToUpper(r.Camera)
+	r.ID = a.format(r)
+	if r.Assigned.IsZero() {
+		r.Assigned = now
+	}
+	if a.perDay && r.Day == "" {
+		r.Day = now.Format(dayFormat)
+	}
+	if key := a.key(r); a.state.Counters[key] < r.Number {
+		a.state.Counters[key] = r.Number
+	}
+	if identity != "" {
+		a.state.Cards[identity] = r
+	}
+	return r, a.save()
+}
+
+// key is the counter a roll counts against
+func (a *Allocator) key(r Roll) string {
+	if a.perDay {
+		return r.Camera + "@" + r.Day
+	}
+	return r.Camera
+}
+
+func (a *Allocator) format(r Roll) string {
+	return fmt.Sprintf("%s%0*d", r.Camera, a.digits, r.Number)
+}
+
+// save writes the counters to the counter file
+func (a *Allocator) save() error {
+	return jsonfile.Save(a.path, a.state)
+}
+
+// ReadMarker returns the roll recorded on a card mounted at root
+func ReadMarker(root string) (Roll, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(root, MarkerName))
+	if err != nil {
+		return Roll{}, false
+	}
+	var r Roll
+	if err := json.Unmarshal(data, &r); err != nil || r.ID == "" {
+		return Roll{}, false
+	}
+	return r, true
+}
+
+// WriteMarker records the roll on a card mounted at root
+func WriteMarker(root string, r Roll) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(root, MarkerName), data, 0644)
+}
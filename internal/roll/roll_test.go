@@ -0,0 +1,113 @@
+package roll
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAllocator_NumbersPerCamera(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rolls.json")
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	a, err := Open(path, 3, false)
+	if err != nil {
+		t.Fatalf("Failed to open counters: %v", err)
+	}
+	for _, want := range []string{"A001", "A002"} {
+		if r, err := a.Next("A", "", now); err != nil || r.ID != want {
+			t.Errorf("Expected %s, got %+v (%v)", want, r, err)
+		}
+	}
+	if r, _ := a.Next("b", "card-1", now); r.ID != "B001" {
+		t.Errorf("Expected B001 for the first B card, got %s", r.ID)
+	}
+	// The same card inserted again keeps its roll
+	if r, _ := a.Next("B", "card-1", now); r.ID != "B001" {
+		t.Errorf("Expected the B card to keep B001, got %s", r.ID)
+	}
+
+	// Counters survive a restart
+	a, err = Open(path, 3, false)
+	if err != nil {
+		t.Fatalf("Failed to reopen counters: %v", err)
+	}
+	if r, _ := a.Next("A", "", now); r.ID != "A003" {
+		t.Errorf("Expected A003 after a restart, got %s", r.ID)
+	}
+	if r, ok := a.Card("card-1"); !ok || r.ID != "B001" {
+		t.Errorf("Expected card-1 recorded as B001, got %+v", r)
+	}
+}
+
+func TestAllocator_PerDayAndClaim(t *testing.T) {
+	a, err := Open(filepath.Join(t.TempDir(), "rolls.json"), 3, true)
+	if err != nil {
+		t.Fatalf("Failed to open counters: %v", err)
+	}
+	day1 := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+
+	// A card labelled A004 on set moves the counter past it
+	labelled, _ := Parse("A_4")
+	claimed, err := a.Claim(labelled, "", day1)
+	if err != nil {
+		t.Fatalf("Failed to claim roll: %v", err)
+	}
+	if claimed.ID != "A004" {
+		t.Errorf("Expected the claimed roll written as A004, got %s", claimed.ID)
+	}
+	if r, _ := a.Next("A", "", day1); r.ID != "A005" {
+		t.Errorf("Expected A005 after the claimed A004, got %s", r.ID)
+	}
+	if r, _ := a.Next("A", "", day2); r.ID != "A001" || r.Day != "2026-03-03" {
+		t.Errorf("Expected numbering to restart on the next day, got %+v", r)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		label string
+		want  string
+		ok    bool
+	}{
+		{"A001", "A001", true},
+		{"b_012", "B012", true},
+		{"CAM-3", "CAM3", true},
+		{"A000", "", false},
+		{"NO NAME", "", false},
+		{"EOS_DIGITAL", "", false},
+	}
+	for _, tt := range tests {
+		r, ok := Parse(tt.label)
+		if ok != tt.ok || r.ID != tt.want {
+			t.Errorf("Parse(%q) = %q, %v; expected %q, %v", tt.label, r.ID, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCameraLetter(t *testing.T) {
+	cameras := map[string]string{"Drone": "d"}
+	if got := CameraLetter("ACam", cameras); got != "A" {
+		t.Errorf("Expected A for ACam, got %q", got)
+	}
+	if got := CameraLetter("drone", cameras); got != "D" {
+		t.Errorf("Expected the mapped letter D for drone, got %q", got)
+	}
+	if got := CameraLetter("", cameras); got != "" {
+		t.Errorf("Expected no letter without a camera, got %q", got)
+	}
+}
+
+func TestMarker(t *testing.T) {
+	card := t.TempDir()
+	if _, ok := ReadMarker(card); ok {
+		t.Fatal("Expected no marker on a fresh card")
+	}
+	if err := WriteMarker(card, Roll{ID: "C007", Camera: "C", Number: 7}); err != nil {
+		t.Fatalf("Failed to write marker: %v", err)
+	}
+	if r, ok := ReadMarker(card); !ok || r.ID != "C007" || r.Number != 7 {
+		t.Errorf("Expected C007 read back, got %+v", r)
+	}
+}
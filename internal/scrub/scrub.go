@@ -0,0 +1,494 @@
+// Package scrub periodically re-hashes the files stored on destinations
+// against the hashes recorded in the catalog, to catch bit rot, missing
+// files and edits, and restores damaged copies from an intact mirror.
+package scrub
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autofileingest/internal/catalog"
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/logger"
+	"github.com/autofileingest/internal/storage"
+	"github.com/autofileingest/internal/transfer"
+)
+
+// Problems found on a copy
+const (
+	// Missing copies are no longer on the destination
+	Missing = "missing"
+	// Corrupted copies have the recorded size but not the recorded hash
+	Corrupted = "corrupted"
+	// Modified copies were changed after the ingest, judging by their size
+	// or modification time, so the difference is probably intentional
+	Modified = "modified"
+	// Unreadable copies couldn't be checked
+	Unreadable = "unreadable"
+)
+
+// modifiedSlack allows for clocks and timestamp resolution when deciding
+// whether a copy was changed after it was written
+const modifiedSlack = 2 * time.Second
+
+// Finding is a problem with one copy of a file
+type Finding struct {
+	FileID      int64
+	Destination string
+	Location    string
+	Problem     string
+	Detail      string
+	// Restored is set when the copy was replaced from a mirror
+	Restored bool
+}
+
+// Report summarises a scrub run
+type Report struct {
+	Started  time.Time
+	Finished time.Time
+	// Files and Copies count what was checked, Bytes what was read
+	Files    int
+	Copies   int
+	Bytes    int64
+	Findings []Finding
+	// Remaining counts due files left for the next run by the byte cap or
+	// the end of the time window
+	Remaining int
+}
+
+// Scrubber checks stored files. Run does one pass; Start repeats it.
+type Scrubber struct {
+	config  *config.Config
+	catalog *catalog.Catalog
+	logger  *logger.Logger
+	limiter *transfer.RateLimiter
+	now     func() time.Time
+
+	// destinations is opened for each run
+	destinations map[string]storage.Destination
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// New creates a scrubber for the files in cat
+func New(cfg *config.Config, cat *catalog.Catalog, log *logger.Logger) *Scrubber {
+	return &Scrubber{
+		config:  cfg,
+		catalog: cat,
+		logger:  log,
+		limiter: transfer.NewRateLimiter(cfg.Scrub.Rate),
+		now:     time.Now,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start runs the scrubber every checkEvery while inside the time window
+// and files are due, passing each report with findings to onReport
+func (s *Scrubber) Start(checkEvery time.Duration, onReport func(Report)) {
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(checkEvery)
+		defer ticker.Stop()
+
+		for {
+			if s.inWindow(s.now()) && len(s.due()) > 0 {
+				report, err := s.Run()
+				if err != nil {
+					s.logger.Error("Scrub failed: %v", err)
+				} else if len(report.Findings) > 0 && onReport != nil {
+					onReport(report)
+				}
+			}
+
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends a started scrubber after the file being checked
+func (s *Scrubber) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+// stopped reports whether Stop was called
+func (s *Scrubber) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run checks the files that are due, oldest check first, until the byte
+// cap or the end of the time window
+func (s *Scrubber) Run() (Report, error) {
+	report := Report{Started: s.now()}
+	if err := s.openDestinations(); err != nil {
+		return report, err
+	}
+	defer s.closeDestinations()
+
+	cutoff := s.cutoff()
+	due := s.due()
+	s.logger.Info("Scrub started: %d files due", len(due))
+
+	for i, file := range due {
+		capped := s.config.Scrub.MaxBytes > 0 && report.Bytes >= s.config.Scrub.MaxBytes
+		if capped || s.stopped() || !s.inWindow(s.now()) {
+			report.Remaining = len(due) - i
+			break
+		}
+
+		findings, checked, bytes := s.checkFile(&file, cutoff)
+		report.Bytes += bytes
+		report.Findings = append(report.Findings, findings...)
+		if checked == 0 {
+			continue
+		}
+		report.Files++
+		report.Copies += checked
+
+		// The file counts as verified when its least recently checked copy was
+		var oldest time.Time
+		for j, c := range file.Copies {
+			if verified := lastVerified(file, c); j == 0 || verified.Before(oldest) {
+				oldest = verified
+			}
+		}
+		file.Verified = oldest
+		if _, err := s.catalog.PutFile(file); err != nil {
+			s.logger.Error("Failed to record scrub of %s: %v", file.SourcePath, err)
+		}
+	}
+
+	// Every file checked was recorded again
+	if report.Files > 0 {
+		if _, err := s.catalog.CompactIfStale(); err != nil {
+			s.logger.Error("Failed to compact catalog: %v", err)
+		}
+	}
+
+	report.Finished = s.now()
+	for _, f := range report.Findings {
+		if f.Restored {
+			s.logger.Warning("Scrub: %s copy %s was %s and has been restored from a mirror", f.Destination, f.Location, f.Problem)
+		} else {
+			s.logger.Error("Scrub: %s copy %s is %s: %s", f.Destination, f.Location, f.Problem, f.Detail)
+		}
+	}
+	s.logger.Info("Scrub finished: %d files, %d copies, %d problems, %d files left for the next run",
+		report.Files, report.Copies, len(report.Findings), report.Remaining)
+	return report, nil
+}
+
+// cutoff returns the time before which a copy is due again
+func (s *Scrubber) cutoff() time.Time {
+	interval := time.Duration(s.config.Scrub.Interval) * time.Hour
+	if interval <= 0 {
+		interval = config.DefaultScrubInterval * time.Hour
+	}
+	return s.now().Add(-interval)
+}
+
+// lastVerified returns when a copy was last hashed. Copies recorded before
+// they were tracked one by one fall back to the file.
+func lastVerified(file catalog.File, c catalog.Copy) time.Time {
+	if c.Verified.IsZero() {
+		return file.Verified
+	}
+	return c.Verified
+}
+
+// due returns the copied files with hashes that have a copy not checked
+// within the interval, least recently checked first
+func (s *Scrubber) due() []catalog.File {
+	cutoff := s.cutoff()
+
+	var due []catalog.File
+	for _, file := range s.catalog.Files(catalog.FileQuery{Status: catalog.StatusCopied}) {
+		if len(file.Checksums) == 0 || file.Algorithm(s.config.Transfer.HashAlgorithms) == "" {
+			continue
+		}
+		for _, c := range file.Copies {
+			if lastVerified(file, c).Before(cutoff) {
+				due = append(due, file)
+				break
+			}
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].Verified.Before(due[j].Verified) })
+	return due
+}
+
+// inWindow reports whether t is within the configured time of day
+func (s *Scrubber) inWindow(t time.Time) bool {
+	if s.config.Scrub.Start == "" {
+		return true
+	}
+	start, err1 := config.ParseClock(s.config.Scrub.Start)
+	end, err2 := config.ParseClock(s.config.Scrub.End)
+	if err1 != nil || err2 != nil {
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// copyResult is the outcome of checking one copy
+type copyResult struct {
+	copy    catalog.Copy
+	store   storage.Destination
+	problem string
+	detail  string
+}
+
+// checkFile checks the due copies of a file on the available destinations,
+// then restores the damaged ones from an intact copy when enabled. Copies
+// that could be checked get their Verified time. It returns the findings,
+// the number of copies checked and the bytes read.
+func (s *Scrubber) checkFile(file *catalog.File, cutoff time.Time) ([]Finding, int, int64) {
+	algorithm := file.Algorithm(s.config.Transfer.HashAlgorithms)
+	expected := file.Checksums[algorithm]
+
+	var results []copyResult
+	var bytes int64
+	checked := 0
+	for i, c := range file.Copies {
+		// An unmounted destination is left for a later run
+		store, ok := s.destinations[c.Destination]
+		if !ok {
+			continue
+		}
+		// Copies that aren't due can still be restored from, the mirror's
+		// hash is checked while restoring
+		result := copyResult{copy: c, store: store}
+		if !lastVerified(*file, c).Before(cutoff) {
+			results = append(results, result)
+			continue
+		}
+
+		var read int64
+		result.problem, result.detail, read = s.checkCopy(store, c, *file, algorithm, expected)
+		bytes += read
+		results = append(results, result)
+		if result.problem != Unreadable {
+			file.Copies[i].Verified = s.now()
+			checked++
+		}
+	}
+
+	var findings []Finding
+	for _, r := range results {
+		if r.problem == "" {
+			continue
+		}
+		finding := Finding{
+			FileID:      file.ID,
+			Destination: r.copy.Destination,
+			Location:    r.copy.Location,
+			Problem:     r.problem,
+			Detail:      r.detail,
+		}
+		if s.config.Scrub.Restore && (r.problem == Missing || r.problem == Corrupted) {
+			if err := s.restore(r, results, file.Size, algorithm, expected); err != nil {
+				finding.Detail += "; not restored: " + err.Error()
+			} else {
+				finding.Restored = true
+			}
+		}
+		findings = append(findings, finding)
+	}
+	return findings, checked, bytes
+}
+
+// checkCopy classifies one copy. It returns the problem, if any, and the
+// bytes read.
+func (s *Scrubber) checkCopy(store storage.Destination, c catalog.Copy, file catalog.File, algorithm, expected string) (string, string, int64) {
+	info, err := store.Stat(c.RelPath)
+	if errors.Is(err, storage.ErrNotExist) {
+		return Missing, "no longer on the destination", 0
+	}
+	if err != nil {
+		return Unreadable, err.Error(), 0
+	}
+	if info.Size != file.Size {
+		return Modified, fmt.Sprintf("size changed from %d to %d bytes", file.Size, info.Size), 0
+	}
+
+	digest, err := s.hash(store, c.RelPath, algorithm, info.Size)
+	read := info.Size
+	if err != nil {
+		return Unreadable, err.Error(), read
+	}
+	if strings.EqualFold(digest, expected) {
+		return "", "", read
+	}
+
+	if !file.Completed.IsZero() && info.ModTime.After(file.Completed.Add(modifiedSlack)) {
+		return Modified, fmt.Sprintf("changed on %s", info.ModTime.Format("2006-01-02 15:04")), read
+	}
+	return Corrupted, fmt.Sprintf("%s is %s, recorded %s", algorithm, digest, expected), read
+}
+
+// hash reads a copy within the I/O budget
+func (s *Scrubber) hash(store storage.Destination, relPath, algorithm string, size int64) (string, error) {
+	if opener, ok := store.(storage.Opener); ok {
+		r, err := opener.Open(relPath)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		digests, err := checksum.Sum(&budgetReader{r: r, limiter: s.limiter}, []string{algorithm})
+		if err != nil {
+			return "", err
+		}
+		return digests[algorithm], nil
+	}
+
+	// The destination reads the file itself, so pay for it afterwards
+	digests, err := store.Hash(relPath, []string{algorithm})
+	for remaining := size; remaining > 0; remaining -= budgetChunk {
+		n := remaining
+		if n > budgetChunk {
+			n = budgetChunk
+		}
+		s.limiter.WaitN(int(n))
+	}
+	if err != nil {
+		return "", err
+	}
+	return digests[algorithm], nil
+}
+
+// restore replaces a damaged copy with an intact one, checking the hash of
+// the data written and of the restored copy. Sources that weren't checked
+// in this run may be damaged too; the next one is tried then.
+func (s *Scrubber) restore(damaged copyResult, results []copyResult, size int64, algorithm, expected string) error {
+	var errs []error
+	for _, source := range results {
+		if source.problem != "" {
+			continue
+		}
+		opener, ok := source.store.(storage.Opener)
+		if !ok {
+			continue
+		}
+
+		r, err := opener.Open(source.copy.RelPath)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source.copy.Destination, err))
+			continue
+		}
+		err = s.restoreFrom(r, damaged, size, algorithm, expected)
+		r.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source.copy.Destination, err))
+			continue
+		}
+
+		digests, err := damaged.store.Hash(damaged.copy.RelPath, []string{algorithm})
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(digests[algorithm], expected) {
+			return fmt.Errorf("restored copy doesn't match the recorded hash")
+		}
+		return nil
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return fmt.Errorf("no intact readable mirror")
+}
+
+// restoreFrom writes r over the damaged copy if it has the expected hash.
+// Writers only replace the file on commit, so the damaged copy is left as
+// it was when the source turns out bad.
+func (s *Scrubber) restoreFrom(r io.Reader, damaged copyResult, size int64, algorithm, expected string) error {
+	w, err := storage.CreateSized(damaged.store, damaged.copy.RelPath, size)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	set, err := checksum.NewSet([]string{algorithm})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.MultiWriter(append(set.Writers(), w)...), &budgetReader{r: r, limiter: s.limiter}); err != nil {
+		return err
+	}
+	if !strings.EqualFold(set.Sums()[algorithm], expected) {
+		return fmt.Errorf("mirror copy doesn't match the recorded hash")
+	}
+	return w.Commit()
+}
+
+// openDestinations opens the configured destinations by name. Ones that
+// aren't mounted are left out, so their files aren't reported missing.
+func (s *Scrubber) openDestinations() error {
+	s.destinations = map[string]storage.Destination{}
+	for _, cfg := range s.config.GetDestinations() {
+		store, err := storage.New(cfg)
+		if err != nil {
+			s.closeDestinations()
+			return fmt.Errorf("destination %s: %w", cfg.Name, err)
+		}
+		if checker, ok := store.(storage.Checker); ok {
+			if err := checker.Check(); err != nil {
+				s.logger.Error("Scrub: skipping destination %s: %v", cfg.Name, err)
+				if closer, ok := store.(io.Closer); ok {
+					closer.Close()
+				}
+				continue
+			}
+		}
+		s.destinations[cfg.Name] = store
+	}
+	return nil
+}
+
+func (s *Scrubber) closeDestinations() {
+	for _, store := range s.destinations {
+		if closer, ok := store.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	s.destinations = nil
+}
+
+// budgetChunk is the largest amount charged to the limiter at once
+const budgetChunk = 16 << 20
+
+// budgetReader waits for the limiter before returning data
+type budgetReader struct {
+	r       io.Reader
+	limiter *transfer.RateLimiter
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if n > 0 {
+		b.limiter.WaitN(n)
+	}
+	return n, err
+}
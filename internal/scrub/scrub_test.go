@@ -0,0 +1,167 @@
+package scrub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/autofileingest/internal/catalog/catalogtest"
+	"github.com/autofileingest/internal/config"
+)
+
+// newScrubFixture stores clips on a primary and a mirror destination
+func newScrubFixture(t *testing.T) *catalogtest.Fixture {
+	t.Helper()
+	f := catalogtest.New(t, "primary", "mirror")
+	f.Config.Scrub = config.ScrubConfig{Enabled: true, Interval: 24, Restore: true}
+	return f
+}
+
+func TestScrubber_FindsAndRestoresDamage(t *testing.T) {
+	f := newScrubFixture(t)
+	intact := f.Add(t, "Ad/Nike/ACam/001.mov", "intact clip")
+	rotten := f.Add(t, "Ad/Nike/ACam/002.mov", "rotten clip")
+	missing := f.Add(t, "Ad/Nike/ACam/003.mov", "missing clip")
+	edited := f.Add(t, "Ad/Nike/ACam/004.mov", "edited clip")
+
+	// Bit rot keeps the size and timestamp
+	rottenPath := filepath.Join(f.Roots["primary"], "Ad/Nike/ACam/002.mov")
+	ioutil.WriteFile(rottenPath, []byte("rotten clap"), 0644)
+	os.Chtimes(rottenPath, f.Stored, f.Stored)
+	os.Remove(filepath.Join(f.Roots["mirror"], "Ad/Nike/ACam/003.mov"))
+	ioutil.WriteFile(filepath.Join(f.Roots["primary"], "Ad/Nike/ACam/004.mov"), []byte("edited clip, graded"), 0644)
+
+	report, err := New(f.Config, f.Catalog, f.Log).Run()
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+
+	if report.Files != 4 || report.Copies != 8 {
+		t.Errorf("Expected 4 files and 8 copies checked, got %d and %d", report.Files, report.Copies)
+	}
+	found := map[int64]Finding{}
+	for _, finding := range report.Findings {
+		found[finding.FileID] = finding
+	}
+	if len(report.Findings) != 3 {
+		t.Fatalf("Expected 3 findings, got %+v", report.Findings)
+	}
+	if _, ok := found[intact.ID]; ok {
+		t.Error("Expected no finding for the intact clip")
+	}
+	if got := found[rotten.ID]; got.Problem != Corrupted || got.Destination != "primary" || !got.Restored {
+		t.Errorf("Expected the rotten primary copy to be restored, got %+v", got)
+	}
+	if got := found[missing.ID]; got.Problem != Missing || got.Destination != "mirror" || !got.Restored {
+		t.Errorf("Expected the missing mirror copy to be restored, got %+v", got)
+	}
+	if got := found[edited.ID]; got.Problem != Modified || got.Restored {
+		t.Errorf("Expected the edited copy to be reported but left alone, got %+v", got)
+	}
+
+	if data, _ := ioutil.ReadFile(rottenPath); string(data) != "rotten clip" {
+		t.Errorf("Expected the rotten copy restored from the mirror, got %q", data)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(f.Roots["mirror"], "Ad/Nike/ACam/003.mov")); string(data) != "missing clip" {
+		t.Errorf("Expected the missing copy restored, got %q", data)
+	}
+
+	// Everything was checked, so nothing is due until the interval passes
+	report, err = New(f.Config, f.Catalog, f.Log).Run()
+	if err != nil || report.Files != 0 {
+		t.Errorf("Expected no files due on the second run, got %d (%v)", report.Files, err)
+	}
+}
+
+func TestScrubber_ByteCapAndWindow(t *testing.T) {
+	f := newScrubFixture(t)
+	f.Config.Scrub.Restore = false
+	f.Config.Scrub.MaxBytes = 10
+	for _, name := range []string{"001", "002", "003"} {
+		f.Add(t, "Ad/Nike/ACam/"+name+".mov", "0123456789")
+	}
+
+	s := New(f.Config, f.Catalog, f.Log)
+	report, err := s.Run()
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	// The cap is checked between files, so the first file's two copies fit
+	if report.Files != 1 || report.Remaining != 2 {
+		t.Errorf("Expected 1 file checked and 2 left, got %d and %d", report.Files, report.Remaining)
+	}
+
+	s.config.Scrub.Start, s.config.Scrub.End = "22:00", "06:00"
+	s.now = func() time.Time { return time.Date(2026, 10, 18, 23, 30, 0, 0, time.Local) }
+	if !s.inWindow(s.now()) {
+		t.Error("Expected 23:30 to be inside a window wrapping midnight")
+	}
+	s.now = func() time.Time { return time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local) }
+	report, _ = s.Run()
+	if report.Files != 0 || report.Remaining != 2 {
+		t.Errorf("Expected nothing checked outside the window, got %d files and %d left", report.Files, report.Remaining)
+	}
+}
+
+func TestScrubber_UncheckedCopiesStayDue(t *testing.T) {
+	f := newScrubFixture(t)
+	clip := f.Add(t, "Ad/Nike/ACam/001.mov", "clip")
+
+	// The mirror didn't mount, so its copy can't be checked
+	f.Config.Destinations[1].Guard = config.MountGuardConfig{Sentinel: ".mirror"}
+	s := New(f.Config, f.Catalog, f.Log)
+	report, err := s.Run()
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if report.Files != 1 || report.Copies != 1 {
+		t.Errorf("Expected only the primary copy checked, got %d files and %d copies", report.Files, report.Copies)
+	}
+
+	file, _ := f.Catalog.File(clip.ID)
+	if file.Copies[0].Verified.IsZero() || !file.Copies[1].Verified.IsZero() {
+		t.Errorf("Expected only the primary copy marked verified, got %+v", file.Copies)
+	}
+	if !file.Verified.Equal(clip.Verified) {
+		t.Errorf("Expected the file not marked verified while a copy is unchecked, got %v", file.Verified)
+	}
+
+	// The primary isn't read again while waiting for the mirror
+	report, _ = s.Run()
+	if report.Bytes != 0 || report.Copies != 0 {
+		t.Errorf("Expected nothing read with the mirror still away, got %d copies and %d bytes", report.Copies, report.Bytes)
+	}
+
+	ioutil.WriteFile(filepath.Join(f.Roots["mirror"], ".mirror"), nil, 0644)
+	report, _ = s.Run()
+	file, _ = f.Catalog.File(clip.ID)
+	if report.Copies != 1 || file.Verified.IsZero() {
+		t.Errorf("Expected the mirror checked once mounted and the file verified, got %d copies, verified %v", report.Copies, file.Verified)
+	}
+}
+
+func TestScrubber_BadMirrorLeavesDamagedCopy(t *testing.T) {
+	f := newScrubFixture(t)
+	clip := f.Add(t, "Ad/Nike/ACam/001.mov", "clip data")
+
+	// The mirror was verified recently, so it's used as a source unchecked
+	clip.Copies[1].Verified = time.Now()
+	if _, err := f.Catalog.PutFile(clip); err != nil {
+		t.Fatalf("Failed to update catalog: %v", err)
+	}
+	f.Write(t, "primary", "Ad/Nike/ACam/001.mov", "clip dbta")
+	f.Write(t, "mirror", "Ad/Nike/ACam/001.mov", "clip dat4")
+
+	report, err := New(f.Config, f.Catalog, f.Log).Run()
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if len(report.Findings) != 1 || report.Findings[0].Restored {
+		t.Fatalf("Expected one finding left unrestored, got %+v", report.Findings)
+	}
+	if data, _ := ioutil.ReadFile(f.Path("primary", "Ad/Nike/ACam/001.mov")); string(data) != "clip dbta" {
+		t.Errorf("Expected the damaged copy left in place, got %q", data)
+	}
+}
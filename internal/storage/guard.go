@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrNotMounted is returned when a local destination isn't the mounted
+// storage it's configured to be
+var ErrNotMounted = errors.New("destination is not mounted")
+
+// Mount table and UUID links, variables for testing
+var (
+	mountInfoPath = "/proc/self/mountinfo"
+	diskByUUID    = "/dev/disk/by-uuid"
+)
+
+// Checker is implemented by destinations that can tell whether they're
+// ready to receive files
+type Checker interface {
+	Check() error
+}
+
+// Check runs the configured mount guard, so a failed mount doesn't fill
+// the folder it should have been mounted on
+func (l *Local) Check() error {
+	g := l.guard
+	if !g.Enabled() {
+		return nil
+	}
+
+	root, err := filepath.Abs(l.root)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotMounted, err)
+	}
+
+	if g.RequireMount || g.FilesystemUUID != "" {
+		mount, err := mountFor(root)
+		if err != nil {
+			return err
+		}
+		if g.RequireMount && mount.point != root {
+			return fmt.Errorf("%w: %s is not a mount point (it's on %s)", ErrNotMounted, root, mount.point)
+		}
+		if g.FilesystemUUID != "" {
+			device, err := deviceForUUID(g.FilesystemUUID)
+			if err != nil {
+				return fmt.Errorf("%w: filesystem %s not found: %v", ErrNotMounted, g.FilesystemUUID, err)
+			}
+			// LVM and dm-crypt volumes are mounted from /dev/mapper links
+			// to the dm-N node the UUID points at
+			if device != resolveDevice(mount.source) {
+				return fmt.Errorf("%w: %s is on %s, not filesystem %s (%s)",
+					ErrNotMounted, root, mount.source, g.FilesystemUUID, device)
+			}
+		}
+	}
+
+	if g.Sentinel != "" {
+		sentinel := filepath.Join(root, filepath.FromSlash(g.Sentinel))
+		if _, err := os.Stat(sentinel); err != nil {
+			return fmt.Errorf("%w: sentinel file %s is missing", ErrNotMounted, sentinel)
+		}
+	}
+	return nil
+}
+
+// mountEntry is a mounted filesystem
+type mountEntry struct {
+	point  string
+	source string
+}
+
+// mountFor returns the mount holding path, which must be absolute and
+// free of symlinks
+func mountFor(path string) (mountEntry, error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return mountEntry{}, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	defer f.Close()
+
+	var best mountEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry, ok := parseMountInfo(scanner.Text())
+		if !ok || !pathWithin(path, entry.point) {
+			continue
+		}
+		// Later entries are mounted over earlier ones
+		if len(entry.point) >= len(best.point) {
+			best = entry
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return mountEntry{}, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	if best.point == "" {
+		return mountEntry{}, fmt.Errorf("no mount found for %s", path)
+	}
+	return best, nil
+}
+
+// parseMountInfo parses a mountinfo line: the mount point is the fifth
+// field and the source follows the fstype after the " - " separator
+func parseMountInfo(line string) (mountEntry, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 5 {
+		return mountEntry{}, false
+	}
+
+	entry := mountEntry{point: unescapeMount(fields[4])}
+	for i := 5; i < len(fields); i++ {
+		if fields[i] == "-" && i+2 < len(fields) {
+			entry.source = unescapeMount(fields[i+2])
+			break
+		}
+	}
+	return entry, true
+}
+
+// unescapeMount decodes the octal escapes used for spaces and other
+// special characters in the mount table
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// pathWithin reports whether path is dir or inside it
+func pathWithin(path, dir string) bool {
+	if dir == "/" || path == dir {
+		return true
+	}
+	return strings.HasPrefix(path, dir+"/")
+}
+
+// deviceForUUID resolves the device node of a filesystem UUID
+func deviceForUUID(uuid string) (string, error) {
+	link := filepath.Join(diskByUUID, uuid)
+	target, err := os.Readlink(link)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(diskByUUID, target)
+	}
+	return resolveDevice(target), nil
+}
+
+// resolveDevice follows the symlinks of a device path, keeping it as is
+// when it can't be resolved, such as for sources that aren't devices
+func resolveDevice(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
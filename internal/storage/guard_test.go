@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/autofileingest/internal/config"
+)
+
+// fakeMountTable points the mount guard at a mount table listing mounts
+// as mount point and source pairs
+func fakeMountTable(t *testing.T, mounts ...string) {
+	t.Helper()
+
+	var lines []string
+	for i := 0; i+1 < len(mounts); i += 2 {
+		point := strings.ReplaceAll(mounts[i], " ", `\040`)
+		lines = append(lines, fmt.Sprintf("%d 1 8:%d / %s rw,relatime shared:1 - ext4 %s rw", 20+i, i, point, mounts[i+1]))
+	}
+
+	path := filepath.Join(t.TempDir(), "mountinfo")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write mount table: %v", err)
+	}
+
+	old := mountInfoPath
+	mountInfoPath = path
+	t.Cleanup(func() { mountInfoPath = old })
+}
+
+// guardedRoot returns a destination folder with a space in its name,
+// resolved the way the guard sees it
+func guardedRoot(t *testing.T) string {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "Media RAID")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatalf("Failed to create root: %v", err)
+	}
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatalf("Failed to resolve root: %v", err)
+	}
+	return resolved
+}
+
+func TestParseMountInfo(t *testing.T) {
+	entry, ok := parseMountInfo(`36 35 98:0 / /mnt/Media\040RAID rw,noatime master:1 - ext4 /dev/sdb1 rw,errors=continue`)
+	if !ok {
+		t.Fatal("Expected the line to parse")
+	}
+	if entry.point != "/mnt/Media RAID" || entry.source != "/dev/sdb1" {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+}
+
+func TestLocal_CheckRequireMount(t *testing.T) {
+	root := guardedRoot(t)
+	l := NewLocal("raid", root)
+	l.guard = config.MountGuardConfig{RequireMount: true}
+
+	// The RAID didn't mount, the folder is on the root filesystem
+	fakeMountTable(t, "/", "/dev/sda1")
+	if err := l.Check(); !errors.Is(err, ErrNotMounted) {
+		t.Errorf("Expected ErrNotMounted, got %v", err)
+	}
+
+	fakeMountTable(t, "/", "/dev/sda1", root, "/dev/sdb1")
+	if err := l.Check(); err != nil {
+		t.Errorf("Expected the mounted destination to pass, got %v", err)
+	}
+
+	// Folders below the mount point aren't mount points themselves
+	sub := NewLocal("raid", filepath.Join(root, "sub"))
+	os.Mkdir(sub.root, 0755)
+	sub.guard = l.guard
+	if err := sub.Check(); !errors.Is(err, ErrNotMounted) {
+		t.Errorf("Expected ErrNotMounted below the mount point, got %v", err)
+	}
+}
+
+func TestLocal_CheckFilesystemUUID(t *testing.T) {
+	root := guardedRoot(t)
+	dev := t.TempDir()
+	byUUID := filepath.Join(dev, "disk", "by-uuid")
+	os.MkdirAll(byUUID, 0755)
+	if err := os.Symlink("../../sdb1", filepath.Join(byUUID, "3f1c-raid")); err != nil {
+		t.Skipf("symlinks not available: %v", err)
+	}
+	old := diskByUUID
+	diskByUUID = byUUID
+	t.Cleanup(func() { diskByUUID = old })
+
+	l := NewLocal("raid", root)
+	l.guard = config.MountGuardConfig{FilesystemUUID: "3f1c-raid"}
+
+	fakeMountTable(t, "/", "/dev/sda1", root, filepath.Join(dev, "sdb1"))
+	if err := l.Check(); err != nil {
+		t.Errorf("Expected the matching filesystem to pass, got %v", err)
+	}
+
+	// A different disk mounted in the same place
+	fakeMountTable(t, "/", "/dev/sda1", root, filepath.Join(dev, "sdc1"))
+	if err := l.Check(); !errors.Is(err, ErrNotMounted) {
+		t.Errorf("Expected ErrNotMounted for another filesystem, got %v", err)
+	}
+
+	l.guard.FilesystemUUID = "missing"
+	if err := l.Check(); !errors.Is(err, ErrNotMounted) {
+		t.Errorf("Expected ErrNotMounted for an unknown UUID, got %v", err)
+	}
+}
+
+func TestLocal_CheckFilesystemUUIDOnMapper(t *testing.T) {
+	root := guardedRoot(t)
+	dev, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve dev dir: %v", err)
+	}
+	byUUID := filepath.Join(dev, "disk", "by-uuid")
+	os.MkdirAll(byUUID, 0755)
+	os.MkdirAll(filepath.Join(dev, "mapper"), 0755)
+	ioutil.WriteFile(filepath.Join(dev, "dm-0"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dev, "dm-1"), nil, 0644)
+	if err := os.Symlink("../../dm-0", filepath.Join(byUUID, "3f1c-lvm")); err != nil {
+		t.Skipf("symlinks not available: %v", err)
+	}
+	os.Symlink("../dm-0", filepath.Join(dev, "mapper", "vg-media"))
+	os.Symlink("../dm-1", filepath.Join(dev, "mapper", "vg-scratch"))
+	old := diskByUUID
+	diskByUUID = byUUID
+	t.Cleanup(func() { diskByUUID = old })
+
+	l := NewLocal("raid", root)
+	l.guard = config.MountGuardConfig{FilesystemUUID: "3f1c-lvm"}
+
+	// mountinfo lists the mapper name, the UUID links to the dm node
+	fakeMountTable(t, "/", "/dev/sda1", root, filepath.Join(dev, "mapper", "vg-media"))
+	if err := l.Check(); err != nil {
+		t.Errorf("Expected the LVM volume to pass, got %v", err)
+	}
+
+	fakeMountTable(t, "/", "/dev/sda1", root, filepath.Join(dev, "mapper", "vg-scratch"))
+	if err := l.Check(); !errors.Is(err, ErrNotMounted) {
+		t.Errorf("Expected ErrNotMounted for another logical volume, got %v", err)
+	}
+}
+
+func TestLocal_CheckSentinel(t *testing.T) {
+	root := guardedRoot(t)
+	l := NewLocal("raid", root)
+	l.guard = config.MountGuardConfig{Sentinel: ".media-raid"}
+
+	if err := l.Check(); !errors.Is(err, ErrNotMounted) {
+		t.Errorf("Expected ErrNotMounted without the sentinel, got %v", err)
+	}
+
+	ioutil.WriteFile(filepath.Join(root, ".media-raid"), nil, 0644)
+	if err := l.Check(); err != nil {
+		t.Errorf("Expected the sentinel to be found, got %v", err)
+	}
+
+	missing := NewLocal("raid", filepath.Join(root, "missing"))
+	missing.guard = l.guard
+	if err := missing.Check(); !errors.Is(err, ErrNotMounted) {
+		t.Errorf("Expected ErrNotMounted for a missing root, got %v", err)
+	}
+}
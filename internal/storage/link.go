@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Linker is implemented by destinations that can give an existing file a
+// second path without copying it
+type Linker interface {
+	// Link makes path refer to the same data as existing
+	Link(existing, path string) error
+}
+
+// Link hard links path to existing, creating missing parent folders.
+// Both must be on the same filesystem.
+func (l *Local) Link(existing, path string) error {
+	fullPath := l.Location(path)
+
+	destDir := filepath.Dir(fullPath)
+	if err := l.mkdirAll(destDir); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", destDir, err)
+	}
+	return os.Link(l.Location(existing), fullPath)
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+)
+
+// Local stores files in a folder on a local or mounted filesystem
+type Local struct {
+	name  string
+	root  string
+	guard config.MountGuardConfig
+	perms permissions
+}
+
+// NewLocal creates a local destination rooted at root
+func NewLocal(name, root string) *Local {
+	return &Local{name: name, root: root, perms: permissions{uid: -1, gid: -1}}
+}
+
+func newLocalFromConfig(cfg config.DestinationConfig) (Destination, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("destination %s: path is required", cfg.Name)
+	}
+	perms, err := resolvePermissions(cfg.Permissions)
+	if err != nil {
+		return nil, fmt.Errorf("destination %s: %w", cfg.Name, err)
+	}
+
+	l := NewLocal(cfg.Name, cfg.Path)
+	l.guard = cfg.Guard
+	l.perms = perms
+	return l, nil
+}
+
+// Name returns the destination name
+func (l *Local) Name() string {
+	return l.name
+}
+
+// Root returns the folder files are stored in
+func (l *Local) Root() string {
+	return l.root
+}
+
+// Location returns the local path of a file
+func (l *Local) Location(path string) string {
+	return filepath.Join(l.root, filepath.FromSlash(path))
+}
+
+// Create creates any missing parent folders with the configured
+// permissions and writes a hidden temporary file next to the final path,
+// renamed over it on commit
+func (l *Local) Create(path string) (Writer, error) {
+	fullPath := l.Location(path)
+
+	destDir := filepath.Dir(fullPath)
+	if err := l.mkdirAll(destDir); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", destDir, err)
+	}
+
+	temp := filepath.Join(destDir, "."+filepath.Base(fullPath)+".part")
+	f, err := os.Create(temp)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.perms.apply(temp, 0666); err != nil {
+		f.Close()
+		os.Remove(temp)
+		return nil, fmt.Errorf("failed to set permissions on %s: %w", fullPath, err)
+	}
+	return &localWriter{file: f, final: fullPath}, nil
+}
+
+// Stat returns the size and modification time of a file
+func (l *Local) Stat(path string) (FileInfo, error) {
+	info, err := os.Stat(l.Location(path))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Exists reports whether a file is stored at path
+func (l *Local) Exists(path string) (bool, error) {
+	return existsFromStat(l, path)
+}
+
+// Hash reads a file back and hashes it
+func (l *Local) Hash(path string, algorithms []string) (checksum.Digests, error) {
+	f, err := os.Open(l.Location(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return checksum.Sum(f, algorithms)
+}
+
+// Remove deletes a file
+func (l *Local) Remove(path string) error {
+	return os.Remove(l.Location(path))
+}
+
+// localWriter writes a temporary file and renames it on commit. The file
+// is removed if it's closed before being committed.
+type localWriter struct {
+	file      *os.File
+	final     string
+	committed bool
+	closed    bool
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+func (w *localWriter) Commit() error {
+	if err := os.Rename(w.file.Name(), w.final); err != nil {
+		return err
+	}
+	w.committed = true
+	return nil
+}
+
+func (w *localWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.file.Close()
+	if !w.committed {
+		os.Remove(w.file.Name())
+	}
+	return err
+}
+
+// File returns the underlying file
+func (w *localWriter) File() *os.File {
+	return w.file
+}
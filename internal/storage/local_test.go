@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+)
+
+func TestLocal_CreateCommit(t *testing.T) {
+	root := t.TempDir()
+	dest, err := New(config.DestinationConfig{Name: "raid", Path: root})
+	if err != nil {
+		t.Fatalf("Failed to create destination: %v", err)
+	}
+
+	w, err := dest.Create("Client/Project/001.mp4")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, ok := w.(FileWriter); !ok {
+		t.Errorf("Expected local writer to expose its file")
+	}
+	if _, err := w.Write([]byte("abc")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(root, "Client", "Project", "001.mp4"))
+	if err != nil || string(content) != "abc" {
+		t.Fatalf("Expected committed file with content abc, got %q (%v)", content, err)
+	}
+
+	info, err := dest.Stat("Client/Project/001.mp4")
+	if err != nil || info.Size != 3 {
+		t.Errorf("Unexpected stat result: %+v (%v)", info, err)
+	}
+
+	digests, err := dest.Hash("Client/Project/001.mp4", []string{checksum.MD5})
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	if digests[checksum.MD5] != "900150983cd24fb0d6963f7d28e17f72" {
+		t.Errorf("Unexpected md5: %s", digests[checksum.MD5])
+	}
+
+	if err := dest.Remove("Client/Project/001.mp4"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if exists, err := dest.Exists("Client/Project/001.mp4"); err != nil || exists {
+		t.Errorf("Expected file to be removed, exists=%v err=%v", exists, err)
+	}
+}
+
+func TestLocal_CloseWithoutCommit(t *testing.T) {
+	root := t.TempDir()
+	dest := NewLocal("raid", root)
+
+	w, err := dest.Create("partial.mov")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	w.Write([]byte("partial"))
+	w.Close()
+
+	if _, err := os.Stat(filepath.Join(root, "partial.mov")); !os.IsNotExist(err) {
+		t.Errorf("Expected uncommitted file to be discarded, got %v", err)
+	}
+	if _, err := dest.Stat("partial.mov"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestLocal_ReplaceOnlyOnCommit(t *testing.T) {
+	root := t.TempDir()
+	dest := NewLocal("raid", root)
+	path := filepath.Join(root, "clip.mov")
+	ioutil.WriteFile(path, []byte("original"), 0644)
+
+	w, err := dest.Create("clip.mov")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	w.Write([]byte("abandoned"))
+	w.Close()
+	if content, _ := ioutil.ReadFile(path); string(content) != "original" {
+		t.Fatalf("Expected an uncommitted write to leave the file alone, got %q", content)
+	}
+
+	w, err = dest.Create("clip.mov")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	w.Write([]byte("replaced"))
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	w.Close()
+	if content, _ := ioutil.ReadFile(path); string(content) != "replaced" {
+		t.Errorf("Expected the commit to replace the file, got %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".clip.mov.part")); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary file left, got %v", err)
+	}
+}
+
+func TestNew_UnknownType(t *testing.T) {
+	if _, err := New(config.DestinationConfig{Name: "x", Type: "tape", Path: "/tmp"}); err == nil {
+		t.Error("Expected error for unknown destination type")
+	}
+}
+
+func TestLocal_SpaceOfMissingRoot(t *testing.T) {
+	l := NewLocal("raid", filepath.Join(t.TempDir(), "not", "created"))
+
+	space, err := l.Space()
+	if errors.Is(err, ErrSpaceUnknown) {
+		t.Skip("free space not available on this platform")
+	}
+	if err != nil {
+		t.Fatalf("Space failed: %v", err)
+	}
+	if space.Free <= 0 || space.Volume == "" {
+		t.Errorf("Unexpected space: %+v", space)
+	}
+}
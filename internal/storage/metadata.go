@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/autofileingest/internal/config"
+)
+
+// Metadata is carried over from a source file to its copies
+type Metadata struct {
+	ModTime    time.Time
+	AccessTime time.Time
+	// Mode holds permission bits to apply, or zero to keep the copy's own
+	Mode os.FileMode
+	// Xattrs holds user extended attributes by name
+	Xattrs map[string][]byte
+}
+
+// MetadataSetter is implemented by destinations that can preserve metadata
+type MetadataSetter interface {
+	SetMetadata(path string, meta Metadata) error
+}
+
+// ReadMetadata reads the metadata of a local file. Extended attributes are
+// only read when xattrs is set.
+func ReadMetadata(path string, xattrs bool) (Metadata, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	meta := Metadata{
+		ModTime:    info.ModTime(),
+		AccessTime: accessTime(info),
+		Mode:       info.Mode().Perm(),
+	}
+	if xattrs {
+		if meta.Xattrs, err = readXattrs(path); err != nil {
+			return Metadata{}, fmt.Errorf("failed to read extended attributes: %w", err)
+		}
+	}
+	return meta, nil
+}
+
+// SetMetadata applies the mode, extended attributes and times of the
+// source to a copy. Times are set last so nothing else touches them, and
+// are set even when the mode or attributes fail.
+func (l *Local) SetMetadata(path string, meta Metadata) error {
+	fullPath := l.Location(path)
+	var errs []error
+
+	if meta.Mode != 0 {
+		if err := os.Chmod(fullPath, meta.Mode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	names := make([]string, 0, len(meta.Xattrs))
+	for name := range meta.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := setXattr(fullPath, name, meta.Xattrs[name]); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set extended attribute %s: %w", name, err))
+		}
+	}
+
+	if err := os.Chtimes(fullPath, meta.AccessTime, meta.ModTime); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// permissions are the resolved permissions settings of a local destination
+type permissions struct {
+	umask    os.FileMode
+	hasUmask bool
+	// uid and gid are -1 to keep the defaults
+	uid, gid int
+}
+
+// resolvePermissions looks up the configured owner and group
+func resolvePermissions(cfg config.PermissionsConfig) (permissions, error) {
+	p := permissions{uid: -1, gid: -1}
+	p.umask, p.hasUmask = cfg.UmaskBits()
+
+	if cfg.Owner != "" {
+		id, err := lookupID(cfg.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return p, fmt.Errorf("unknown owner %q: %w", cfg.Owner, err)
+		}
+		p.uid = id
+	}
+
+	if cfg.Group != "" {
+		id, err := lookupID(cfg.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return p, fmt.Errorf("unknown group %q: %w", cfg.Group, err)
+		}
+		p.gid = id
+	}
+	return p, nil
+}
+
+// lookupID accepts a numeric id or resolves a name
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// apply sets the configured mode and ownership on a new file or folder
+// created with the default mode base
+func (p permissions) apply(path string, base os.FileMode) error {
+	if p.hasUmask {
+		if err := os.Chmod(path, base&^p.umask); err != nil {
+			return err
+		}
+	}
+	if p.uid >= 0 || p.gid >= 0 {
+		if err := os.Chown(path, p.uid, p.gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isDefault reports whether nothing is configured
+func (p permissions) isDefault() bool {
+	return !p.hasUmask && p.uid < 0 && p.gid < 0
+}
+
+// mkdirAll creates dir and its missing parents, applying the configured
+// permissions to every folder it creates
+func (l *Local) mkdirAll(dir string) error {
+	if l.perms.isDefault() {
+		return os.MkdirAll(dir, 0755)
+	}
+
+	var missing []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0755); err != nil {
+			// Another worker may have created it in the meantime
+			if os.IsExist(err) {
+				continue
+			}
+			return err
+		}
+		if err := l.perms.apply(missing[i], 0777); err != nil {
+			return fmt.Errorf("failed to set permissions on %s: %w", missing[i], err)
+		}
+	}
+	return nil
+}
+
+// userXattr reports whether an extended attribute is in the user namespace,
+// the only one that can be copied without privileges
+func userXattr(name string) bool {
+	return strings.HasPrefix(name, "user.")
+}
@@ -0,0 +1,73 @@
+// +build linux
+
+package storage
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// accessTime returns the last access time of a file
+func accessTime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Atim.Sec, st.Atim.Nsec)
+	}
+	return info.ModTime()
+}
+
+// readXattrs returns the user extended attributes of a file. Filesystems
+// without extended attributes, like exFAT cards, have none.
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := unix.Listxattr(path, nil)
+	if err == unix.ENOTSUP || size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := map[string][]byte{}
+	start := 0
+	for i := 0; i < size; i++ {
+		if buf[i] != 0 {
+			continue
+		}
+		name := string(buf[start:i])
+		start = i + 1
+		if !userXattr(name) {
+			continue
+		}
+
+		n, err := unix.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, n)
+		if n > 0 {
+			if n, err = unix.Getxattr(path, name, value); err != nil {
+				return nil, err
+			}
+		}
+		xattrs[name] = value[:n]
+	}
+	return xattrs, nil
+}
+
+// setXattr sets an extended attribute on a file. Destinations without
+// user attributes, like SMB and NFS shares or exFAT, just don't get them.
+func setXattr(path, name string, value []byte) error {
+	err := unix.Setxattr(path, name, value, 0)
+	if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+		return nil
+	}
+	return err
+}
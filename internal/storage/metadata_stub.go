@@ -0,0 +1,24 @@
+// +build !linux
+
+package storage
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// accessTime stub for non-Linux platforms
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
+
+// readXattrs stub for non-Linux platforms
+func readXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// setXattr stub for non-Linux platforms
+func setXattr(path, name string, value []byte) error {
+	return errors.New("extended attributes not available on this platform")
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/autofileingest/internal/config"
+)
+
+func TestLocal_SetMetadata(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "A001.mov")
+	ioutil.WriteFile(src, []byte("clip"), 0600)
+	recorded := time.Date(2021, 6, 1, 14, 30, 0, 0, time.UTC)
+	os.Chtimes(src, recorded.Add(time.Hour), recorded)
+
+	// Filesystems without user attributes silently don't store it
+	setXattr(src, "user.camera", []byte("ACam"))
+	stored, _ := readXattrs(src)
+	xattrs := stored["user.camera"] != nil
+
+	meta, err := ReadMetadata(src, true)
+	if err != nil {
+		t.Fatalf("ReadMetadata failed: %v", err)
+	}
+	if xattrs && string(meta.Xattrs["user.camera"]) != "ACam" {
+		t.Errorf("Expected user.camera to be read, got %v", meta.Xattrs)
+	}
+
+	l := NewLocal("raid", t.TempDir())
+	if err := writeObject(t, l, "Client/A001.mov", []byte("clip")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := l.SetMetadata("Client/A001.mov", meta); err != nil {
+		t.Fatalf("SetMetadata failed: %v", err)
+	}
+
+	info, err := os.Stat(l.Location("Client/A001.mov"))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if !info.ModTime().Equal(recorded) {
+		t.Errorf("Expected mtime %v, got %v", recorded, info.ModTime())
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+	if xattrs {
+		copied, err := readXattrs(l.Location("Client/A001.mov"))
+		if err != nil || string(copied["user.camera"]) != "ACam" {
+			t.Errorf("Expected user.camera on the copy, got %v (%v)", copied, err)
+		}
+	}
+}
+
+func TestLocal_SetMetadataKeepsTimesOnXattrFailure(t *testing.T) {
+	l := NewLocal("raid", t.TempDir())
+	if err := writeObject(t, l, "A001.mov", []byte("clip")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Larger than any filesystem accepts for one attribute
+	recorded := time.Date(2021, 6, 1, 14, 30, 0, 0, time.UTC)
+	meta := Metadata{
+		ModTime:    recorded,
+		AccessTime: recorded,
+		Xattrs:     map[string][]byte{"user.huge": make([]byte, 1<<20)},
+	}
+	l.SetMetadata("A001.mov", meta)
+
+	info, err := os.Stat(l.Location("A001.mov"))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if !info.ModTime().Equal(recorded) {
+		t.Errorf("Expected mtime %v despite the attribute failing, got %v", recorded, info.ModTime())
+	}
+}
+
+func TestLocal_Permissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix permissions only")
+	}
+
+	root := t.TempDir()
+	dest, err := New(config.DestinationConfig{
+		Name: "shared",
+		Type: config.DestinationLocal,
+		Path: root,
+		Permissions: config.PermissionsConfig{
+			Owner: strconv.Itoa(os.Getuid()),
+			Group: strconv.Itoa(os.Getgid()),
+			Umask: "0027",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create destination: %v", err)
+	}
+
+	if err := writeObject(t, dest, "Client/Project/001.mp4", []byte("x")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	for path, want := range map[string]os.FileMode{
+		"Client":                 0750,
+		"Client/Project":         0750,
+		"Client/Project/001.mp4": 0640,
+	} {
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(path)))
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("Expected %s to have mode %v, got %v", path, want, info.Mode().Perm())
+		}
+	}
+}
+
+func TestLocal_UnknownOwner(t *testing.T) {
+	_, err := New(config.DestinationConfig{
+		Name:        "shared",
+		Type:        config.DestinationLocal,
+		Path:        t.TempDir(),
+		Permissions: config.PermissionsConfig{Owner: "no-such-user-for-ingest"},
+	})
+	if err == nil {
+		t.Error("Expected an error for an unknown owner")
+	}
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"io"
+	"net/http"
+	"os"
+)
+
+// Opener is implemented by destinations that can read a stored file back,
+// such as to restore a copy from a mirror
+type Opener interface {
+	Open(path string) (io.ReadCloser, error)
+}
+
+// Open opens a stored file for reading
+func (l *Local) Open(path string) (io.ReadCloser, error) {
+	return os.Open(l.Location(path))
+}
+
+// Open downloads an object
+func (s *S3) Open(path string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, s.key(path), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Open downloads a file
+func (d *WebDAV) Open(file string) (io.ReadCloser, error) {
+	resp, err := d.do(http.MethodGet, file, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
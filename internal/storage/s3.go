@@ -0,0 +1,548 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+)
+
+// httpMaxAttempts is how often an HTTP request is tried before giving up
+const httpMaxAttempts = 3
+
+// S3 stores files in a bucket on any S3-compatible object storage. Files
+// larger than one part are sent as multipart uploads whose parts outlive a
+// failed ingest, so the next attempt only sends the parts still missing.
+type S3 struct {
+	name     string
+	config   config.S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3 creates an S3 destination. Credentials fall back to the standard
+// AWS environment variables.
+func NewS3(name string, cfg config.S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("destination %s: s3.bucket is required", name)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = config.S3DefaultPartSize
+	}
+	if cfg.AccessKey == "" {
+		cfg.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("destination %s: invalid s3.endpoint %q", name, cfg.Endpoint)
+	}
+
+	return &S3{
+		name:     name,
+		config:   cfg,
+		endpoint: endpoint,
+		client:   &http.Client{},
+		now:      time.Now,
+	}, nil
+}
+
+func newS3FromConfig(cfg config.DestinationConfig) (Destination, error) {
+	return NewS3(cfg.Name, cfg.S3)
+}
+
+// Name returns the destination name
+func (s *S3) Name() string {
+	return s.name
+}
+
+// Location returns the s3:// URL of a file
+func (s *S3) Location(path string) string {
+	return "s3://" + s.config.Bucket + "/" + s.key(path)
+}
+
+// s3MaxParts is the most parts a multipart upload can have
+const s3MaxParts = 10000
+
+// Create starts an upload. Nothing is visible in the bucket until Commit.
+func (s *S3) Create(path string) (Writer, error) {
+	return s.CreateSized(path, 0)
+}
+
+// CreateSized starts an upload of a file of a known size, with parts large
+// enough to stay within the part limit. Files smaller than a part only get
+// a buffer of their own size.
+func (s *S3) CreateSized(path string, size int64) (Writer, error) {
+	partSize := s.partSize(size)
+	bufSize := partSize
+	if size > 0 && size < partSize {
+		bufSize = size
+	}
+	return &s3Writer{
+		s3:       s,
+		key:      s.key(path),
+		partSize: partSize,
+		buf:      make([]byte, 0, bufSize),
+	}, nil
+}
+
+// partSize returns the configured part size, grown in whole MiB for files
+// that would need more than s3MaxParts. It only depends on the size, so a
+// retried upload splits the file the same way and can resume.
+func (s *S3) partSize(size int64) int64 {
+	partSize := s.config.PartSize
+	if size > partSize*s3MaxParts {
+		const mib = 1 << 20
+		partSize = (size + s3MaxParts - 1) / s3MaxParts
+		partSize = (partSize + mib - 1) / mib * mib
+	}
+	return partSize
+}
+
+// Stat returns the size and modification time of an object
+func (s *S3) Stat(path string) (FileInfo, error) {
+	resp, err := s.do(http.MethodHead, s.key(path), nil, nil, nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return FileInfo{Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// Exists reports whether an object is stored at path
+func (s *S3) Exists(path string) (bool, error) {
+	return existsFromStat(s, path)
+}
+
+// Hash downloads an object and hashes it
+func (s *S3) Hash(path string, algorithms []string) (checksum.Digests, error) {
+	resp, err := s.do(http.MethodGet, s.key(path), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return checksum.Sum(resp.Body, algorithms)
+}
+
+// Remove deletes an object
+func (s *S3) Remove(path string) error {
+	resp, err := s.do(http.MethodDelete, s.key(path), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// key converts a destination path to an object key
+func (s *S3) key(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
+
+// objectURL returns the URL of an object, addressing the bucket by path or
+// by virtual host
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+
+	if s.config.PathStyle {
+		u.Path = base + "/" + s.config.Bucket + "/" + key
+		u.RawPath = base + "/" + uriEncode(s.config.Bucket, true) + "/" + uriEncode(key, false)
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = base + "/" + key
+		u.RawPath = base + "/" + uriEncode(key, false)
+	}
+
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// s3Error is an error response from the object storage
+type s3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("s3: %s: %s (HTTP %d)", e.Code, e.Message, e.Status)
+}
+
+// Is makes missing objects match ErrNotExist
+func (e *s3Error) Is(target error) bool {
+	return target == ErrNotExist && (e.Status == http.StatusNotFound || e.Code == "NoSuchKey")
+}
+
+// do sends a signed request, retrying network errors and server errors.
+// Responses other than 2xx are returned as errors.
+func (s *S3) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = hexSHA256(body)
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= httpMaxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * 200 * time.Millisecond)
+		}
+
+		req, err := http.NewRequest(method, s.objectURL(key, query).String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = int64(len(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		s.sign(req, payloadHash)
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		lastErr = readS3Error(resp)
+		if resp.StatusCode < 500 {
+			return nil, lastErr
+		}
+	}
+	return nil, lastErr
+}
+
+// readS3Error turns an error response into an s3Error and closes it
+func readS3Error(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	e := &s3Error{Status: resp.StatusCode}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	xml.Unmarshal(data, e)
+	return e
+}
+
+// decodeXML reads an XML response body. Some operations report errors in a
+// 200 response, so an Error document is returned as an error.
+func decodeXML(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Contains(data, []byte("<Error>")) {
+		e := &s3Error{Status: resp.StatusCode}
+		xml.Unmarshal(data, e)
+		return e
+	}
+	return xml.Unmarshal(data, v)
+}
+
+// contentMD5 returns the hex and base64 MD5 of a body
+func contentMD5(body []byte) (string, string) {
+	sum := md5.Sum(body)
+	return hex.EncodeToString(sum[:]), base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// s3Part is one uploaded part of a multipart upload
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	Size       int64  `xml:"Size,omitempty"`
+}
+
+type s3InitiateResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3ListUploadsResult struct {
+	Uploads []struct {
+		Key       string    `xml:"Key"`
+		UploadID  string    `xml:"UploadId"`
+		Initiated time.Time `xml:"Initiated"`
+	} `xml:"Upload"`
+}
+
+type s3ListPartsResult struct {
+	Parts                []s3Part `xml:"Part"`
+	IsTruncated          bool     `xml:"IsTruncated"`
+	NextPartNumberMarker int      `xml:"NextPartNumberMarker"`
+}
+
+type s3CompleteUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []s3Part `xml:"Part"`
+}
+
+// putObject uploads a whole object in a single request
+func (s *S3) putObject(key string, body []byte) error {
+	_, md5Base64 := contentMD5(body)
+	header := http.Header{"Content-Md5": {md5Base64}}
+
+	resp, err := s.do(http.MethodPut, key, nil, header, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// resumableUpload returns the newest unfinished upload of a key
+func (s *S3) resumableUpload(key string) (string, error) {
+	resp, err := s.do(http.MethodGet, "", url.Values{"uploads": {""}, "prefix": {key}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var result s3ListUploadsResult
+	if err := decodeXML(resp, &result); err != nil {
+		return "", err
+	}
+
+	var uploadID string
+	var newest time.Time
+	for _, upload := range result.Uploads {
+		if upload.Key == key && (uploadID == "" || upload.Initiated.After(newest)) {
+			uploadID = upload.UploadID
+			newest = upload.Initiated
+		}
+	}
+	return uploadID, nil
+}
+
+// listParts returns the parts already stored for an upload
+func (s *S3) listParts(key, uploadID string) (map[int]s3Part, error) {
+	parts := map[int]s3Part{}
+	marker := 0
+
+	for {
+		query := url.Values{"uploadId": {uploadID}}
+		if marker > 0 {
+			query.Set("part-number-marker", strconv.Itoa(marker))
+		}
+
+		resp, err := s.do(http.MethodGet, key, query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListPartsResult
+		if err := decodeXML(resp, &result); err != nil {
+			return nil, err
+		}
+		for _, part := range result.Parts {
+			parts[part.PartNumber] = part
+		}
+
+		if !result.IsTruncated || result.NextPartNumberMarker <= marker {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+// createUpload starts a new multipart upload
+func (s *S3) createUpload(key string) (string, error) {
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var result s3InitiateResult
+	if err := decodeXML(resp, &result); err != nil {
+		return "", err
+	}
+	if result.UploadID == "" {
+		return "", fmt.Errorf("s3: no upload ID returned for %s", key)
+	}
+	return result.UploadID, nil
+}
+
+// uploadPart sends one part and returns its ETag
+func (s *S3) uploadPart(key, uploadID string, number int, body []byte) (string, error) {
+	md5Hex, md5Base64 := contentMD5(body)
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	header := http.Header{"Content-Md5": {md5Base64}}
+
+	resp, err := s.do(http.MethodPut, key, query, header, body)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		etag = `"` + md5Hex + `"`
+	}
+	return etag, nil
+}
+
+// completeUpload assembles the uploaded parts into the object
+func (s *S3) completeUpload(key, uploadID string, parts []s3Part) error {
+	complete := s3CompleteUpload{}
+	for _, part := range parts {
+		complete.Parts = append(complete.Parts, s3Part{PartNumber: part.PartNumber, ETag: part.ETag})
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+
+	var result struct{}
+	return decodeXML(resp, &result)
+}
+
+// s3Writer buffers one part at a time. Small files are sent with a single
+// PUT on commit; larger ones resume an earlier unfinished upload of the same
+// key when there is one, skipping parts whose size and MD5 already match.
+type s3Writer struct {
+	s3        *S3
+	key       string
+	partSize  int64
+	buf       []byte
+	uploadID  string
+	existing  map[int]s3Part
+	parts     []s3Part
+	committed bool
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := int(w.partSize) - len(w.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if int64(len(w.buf)) == w.partSize {
+			if err := w.flushPart(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flushPart uploads the buffered part unless an earlier attempt already did
+func (w *s3Writer) flushPart() error {
+	if w.uploadID == "" {
+		if err := w.startUpload(); err != nil {
+			return err
+		}
+	}
+
+	number := len(w.parts) + 1
+	if number > s3MaxParts {
+		return fmt.Errorf("%s needs more than %d parts of %d bytes; raise s3.part_size", w.key, s3MaxParts, w.partSize)
+	}
+	md5Hex, _ := contentMD5(w.buf)
+
+	if part, ok := w.existing[number]; ok && part.Size == int64(len(w.buf)) && strings.Trim(part.ETag, `"`) == md5Hex {
+		w.parts = append(w.parts, part)
+	} else {
+		etag, err := w.s3.uploadPart(w.key, w.uploadID, number, w.buf)
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d of %s: %w", number, w.key, err)
+		}
+		w.parts = append(w.parts, s3Part{PartNumber: number, ETag: etag, Size: int64(len(w.buf))})
+	}
+
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// startUpload resumes the newest unfinished upload of the key or starts one
+func (w *s3Writer) startUpload() error {
+	uploadID, err := w.s3.resumableUpload(w.key)
+	if err != nil {
+		return fmt.Errorf("failed to list uploads for %s: %w", w.key, err)
+	}
+
+	if uploadID != "" {
+		existing, err := w.s3.listParts(w.key, uploadID)
+		if err != nil {
+			return fmt.Errorf("failed to list parts of %s: %w", w.key, err)
+		}
+		w.uploadID = uploadID
+		w.existing = existing
+		return nil
+	}
+
+	if w.uploadID, err = w.s3.createUpload(w.key); err != nil {
+		return fmt.Errorf("failed to start upload of %s: %w", w.key, err)
+	}
+	return nil
+}
+
+func (w *s3Writer) Commit() error {
+	if w.uploadID == "" {
+		if err := w.s3.putObject(w.key, w.buf); err != nil {
+			return err
+		}
+		w.committed = true
+		return nil
+	}
+
+	if len(w.buf) > 0 {
+		if err := w.flushPart(); err != nil {
+			return err
+		}
+	}
+
+	if err := w.s3.completeUpload(w.key, w.uploadID, w.parts); err != nil {
+		return fmt.Errorf("failed to complete upload of %s: %w", w.key, err)
+	}
+	w.committed = true
+	return nil
+}
+
+// Close drops the buffer. An unfinished multipart upload is left in the
+// bucket so a retried ingest can resume it; a lifecycle rule on the bucket
+// should clean up uploads that are never retried.
+func (w *s3Writer) Close() error {
+	w.buf = nil
+	return nil
+}
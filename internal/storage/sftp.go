@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+)
+
+// SFTP stores files in a folder on a host reachable over SSH. Files are
+// written under a temporary name and renamed into place on commit, so
+// editors never see a partial clip.
+type SFTP struct {
+	name   string
+	root   string
+	config config.SFTPConfig
+	dial   func() (io.ReadWriteCloser, error)
+
+	mu     sync.Mutex
+	client *sftpClient
+	dirs   map[string]bool
+}
+
+// NewSFTP creates an SFTP destination storing files under root. The
+// connection is opened on first use.
+func NewSFTP(name, root string, cfg config.SFTPConfig) (*SFTP, error) {
+	if cfg.Host == "" || cfg.User == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("destination %s: sftp.host, sftp.user and sftp.key_file are required", name)
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 22
+	}
+
+	// Relative roots are relative to the login folder
+	s := &SFTP{
+		name:   name,
+		root:   path.Clean(root),
+		config: cfg,
+		dirs:   map[string]bool{},
+	}
+	s.dial = s.dialSSH
+	return s, nil
+}
+
+func newSFTPFromConfig(cfg config.DestinationConfig) (Destination, error) {
+	return NewSFTP(cfg.Name, cfg.Path, cfg.SFTP)
+}
+
+// Name returns the destination name
+func (s *SFTP) Name() string {
+	return s.name
+}
+
+// Location returns the sftp:// URL of a file
+func (s *SFTP) Location(file string) string {
+	remote := s.remotePath(file)
+	if !strings.HasPrefix(remote, "/") {
+		remote = "/~/" + remote
+	}
+	return fmt.Sprintf("sftp://%s@%s%s", s.config.User, s.config.Host, remote)
+}
+
+// Create opens a temporary file next to the final path
+func (s *SFTP) Create(file string) (Writer, error) {
+	client, err := s.session()
+	if err != nil {
+		return nil, err
+	}
+
+	final := s.remotePath(file)
+	dir := path.Dir(final)
+	if err := s.mkdirAll(client, dir); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	temp := path.Join(dir, "."+path.Base(final)+".part")
+	handle, err := client.open(temp, sshFxfWrite|sshFxfCreat|sshFxfTrunc, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sftpWriter{client: client, handle: handle, temp: temp, final: final}, nil
+}
+
+// Stat returns the size and modification time of a remote file
+func (s *SFTP) Stat(file string) (FileInfo, error) {
+	client, err := s.session()
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	attrs, err := client.stat(s.remotePath(file))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Size: int64(attrs.Size), ModTime: attrs.ModTime}, nil
+}
+
+// Exists reports whether a file is stored at path
+func (s *SFTP) Exists(file string) (bool, error) {
+	return existsFromStat(s, file)
+}
+
+// Hash reads a remote file back over the connection and hashes it
+func (s *SFTP) Hash(file string, algorithms []string) (checksum.Digests, error) {
+	client, err := s.session()
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := checksum.NewSet(algorithms)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.readFile(s.remotePath(file), set); err != nil {
+		return nil, err
+	}
+	return set.Sums(), nil
+}
+
+// Remove deletes a remote file
+func (s *SFTP) Remove(file string) error {
+	client, err := s.session()
+	if err != nil {
+		return err
+	}
+	return client.remove(s.remotePath(file))
+}
+
+// Close ends the SFTP session
+func (s *SFTP) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	s.client = nil
+	return err
+}
+
+// remotePath converts a destination path to a remote path
+func (s *SFTP) remotePath(file string) string {
+	return path.Join(s.root, file)
+}
+
+// session returns the open session, reconnecting if the last one broke
+func (s *SFTP) session() (*sftpClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil && s.client.broken() == nil {
+		return s.client, nil
+	}
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+		s.dirs = map[string]bool{}
+	}
+
+	conn, err := s.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", s.config.Host, err)
+	}
+
+	client, err := newSFTPClient(conn)
+	if err != nil {
+		conn.Close()
+		if ssh, ok := conn.(*sshConn); ok && ssh.stderr.String() != "" {
+			return nil, fmt.Errorf("%w: %s", err, ssh.stderr.String())
+		}
+		return nil, err
+	}
+
+	s.client = client
+	return client, nil
+}
+
+// mkdirAll creates dir and its missing parents
+func (s *SFTP) mkdirAll(client *sftpClient, dir string) error {
+	s.mu.Lock()
+	known := s.dirs[dir]
+	s.mu.Unlock()
+	if known || dir == "/" || dir == "." {
+		return nil
+	}
+
+	if _, err := client.stat(dir); err != nil {
+		if !errors.Is(err, ErrNotExist) {
+			return err
+		}
+		if err := s.mkdirAll(client, path.Dir(dir)); err != nil {
+			return err
+		}
+		// Another worker may have created it in the meantime
+		if err := client.mkdir(dir, 0755); err != nil {
+			if _, statErr := client.stat(dir); statErr != nil {
+				return err
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.dirs[dir] = true
+	s.mu.Unlock()
+	return nil
+}
+
+// sshArgs returns the ssh command line. Password prompts are disabled and
+// hosts missing from known_hosts are refused rather than trusted.
+func (s *SFTP) sshArgs() []string {
+	args := []string{
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "IdentitiesOnly=yes",
+		"-o", "PasswordAuthentication=no",
+		"-o", "ServerAliveInterval=30",
+		"-i", s.config.KeyFile,
+		"-p", strconv.Itoa(s.config.Port),
+		"-l", s.config.User,
+	}
+	if s.config.KnownHostsFile != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+s.config.KnownHostsFile)
+	}
+	return append(args, s.config.Host, "-s", "sftp")
+}
+
+// dialSSH starts ssh with the sftp subsystem
+func (s *SFTP) dialSSH() (io.ReadWriteCloser, error) {
+	cmd := exec.Command("ssh", s.sshArgs()...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &sshConn{Reader: stdout, WriteCloser: stdin, cmd: cmd}
+	cmd.Stderr = &conn.stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sshConn is the stdin and stdout of an ssh process
+type sshConn struct {
+	io.Reader
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr stderrBuffer
+}
+
+// Close ends the session and the ssh process
+func (c *sshConn) Close() error {
+	c.WriteCloser.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	return nil
+}
+
+// stderrBuffer keeps the start of ssh's error output for error messages
+type stderrBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *stderrBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := 4096 - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *stderrBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.TrimSpace(b.buf.String())
+}
+
+// sftpWriter writes a temporary remote file and renames it on commit
+type sftpWriter struct {
+	client    *sftpClient
+	handle    string
+	temp      string
+	final     string
+	offset    uint64
+	committed bool
+	closed    bool
+}
+
+func (w *sftpWriter) Write(p []byte) (int, error) {
+	if err := w.client.writeAt(w.handle, w.offset, p); err != nil {
+		return 0, err
+	}
+	w.offset += uint64(len(p))
+	return len(p), nil
+}
+
+func (w *sftpWriter) Commit() error {
+	w.closed = true
+	if err := w.client.closeHandle(w.handle); err != nil {
+		w.client.remove(w.temp)
+		return err
+	}
+
+	if err := w.client.rename(w.temp, w.final); err != nil {
+		w.client.remove(w.temp)
+		return fmt.Errorf("failed to rename %s: %w", w.temp, err)
+	}
+	w.committed = true
+	return nil
+}
+
+func (w *sftpWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.client.closeHandle(w.handle)
+	w.client.remove(w.temp)
+	return err
+}
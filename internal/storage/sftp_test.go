@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+)
+
+// testSFTPServer serves SFTP version 3 from a local folder, enough of the
+// protocol for the SFTP destination
+type testSFTPServer struct {
+	root string
+	// posixRename advertises the OpenSSH posix-rename extension
+	posixRename bool
+	// maxRead caps read responses to exercise short reads
+	maxRead int
+
+	mu      sync.Mutex
+	handles map[string]*os.File
+	next    int
+}
+
+func newTestSFTP(t *testing.T, server *testSFTPServer) *SFTP {
+	t.Helper()
+	if server.root == "" {
+		server.root = t.TempDir()
+	}
+	server.handles = map[string]*os.File{}
+
+	s, err := NewSFTP("edit-bay", "/media", config.SFTPConfig{
+		Host:    "edit-bay.local",
+		User:    "ingest",
+		KeyFile: "/etc/media-ingest/id_ed25519",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SFTP destination: %v", err)
+	}
+	s.dial = func() (io.ReadWriteCloser, error) {
+		client, conn := net.Pipe()
+		go server.serve(conn)
+		return client, nil
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func (s *testSFTPServer) serve(conn io.ReadWriteCloser) {
+	defer conn.Close()
+
+	if typ, _, err := readSFTPPacket(conn); err != nil || typ != sshFxpInit {
+		return
+	}
+	version := new(sftpBuffer).u32(3)
+	if s.posixRename {
+		version.str(posixRenameExtension).str("1")
+	}
+	if err := writeSFTPPacket(conn, sshFxpVersion, version.b); err != nil {
+		return
+	}
+
+	for {
+		typ, data, err := readSFTPPacket(conn)
+		if err != nil {
+			return
+		}
+		r := &sftpReader{b: data}
+		id := r.u32()
+		respType, resp := s.handle(typ, r)
+		if err := writeSFTPPacket(conn, respType, new(sftpBuffer).u32(id).raw(resp).b); err != nil {
+			return
+		}
+	}
+}
+
+func (s *testSFTPServer) local(remote string) string {
+	return filepath.Join(s.root, filepath.FromSlash(remote))
+}
+
+func (s *testSFTPServer) handle(typ byte, r *sftpReader) (byte, []byte) {
+	switch typ {
+	case sshFxpOpen:
+		name, flags := r.str(), r.u32()
+		mode := os.O_RDONLY
+		if flags&sshFxfWrite != 0 {
+			mode = os.O_WRONLY
+		}
+		if flags&sshFxfCreat != 0 {
+			mode |= os.O_CREATE
+		}
+		if flags&sshFxfTrunc != 0 {
+			mode |= os.O_TRUNC
+		}
+		f, err := os.OpenFile(s.local(name), mode, 0644)
+		if err != nil {
+			return status(err)
+		}
+		s.mu.Lock()
+		s.next++
+		handle := fmt.Sprintf("h%d", s.next)
+		s.handles[handle] = f
+		s.mu.Unlock()
+		return sshFxpHandle, new(sftpBuffer).str(handle).b
+
+	case sshFxpClose:
+		f := s.file(r.str())
+		if f == nil {
+			return status(os.ErrInvalid)
+		}
+		return status(f.Close())
+
+	case sshFxpRead:
+		f, offset, length := s.file(r.str()), r.u64(), int(r.u32())
+		if f == nil {
+			return status(os.ErrInvalid)
+		}
+		if s.maxRead > 0 && length > s.maxRead {
+			length = s.maxRead
+		}
+		buf := make([]byte, length)
+		n, err := f.ReadAt(buf, int64(offset))
+		if n == 0 {
+			return status(err)
+		}
+		return sshFxpData, new(sftpBuffer).bytes(buf[:n]).b
+
+	case sshFxpWrite:
+		f, offset, data := s.file(r.str()), r.u64(), r.bytes()
+		if f == nil {
+			return status(os.ErrInvalid)
+		}
+		_, err := f.WriteAt(data, int64(offset))
+		return status(err)
+
+	case sshFxpStat:
+		info, err := os.Stat(s.local(r.str()))
+		if err != nil {
+			return status(err)
+		}
+		attrs := new(sftpBuffer).u32(sshFileXferAttrSize | sshFileXferAttrPermissions | sshFileXferAttrACModTime).
+			u64(uint64(info.Size())).u32(uint32(info.Mode().Perm())).
+			u32(uint32(info.ModTime().Unix())).u32(uint32(info.ModTime().Unix()))
+		return sshFxpAttrs, attrs.b
+
+	case sshFxpMkdir:
+		return status(os.Mkdir(s.local(r.str()), 0755))
+
+	case sshFxpRemove:
+		return status(os.Remove(s.local(r.str())))
+
+	case sshFxpRename:
+		oldPath, newPath := s.local(r.str()), s.local(r.str())
+		// Like OpenSSH, plain rename never replaces an existing file
+		if _, err := os.Stat(newPath); err == nil {
+			return status(os.ErrExist)
+		}
+		return status(os.Rename(oldPath, newPath))
+
+	case sshFxpExtended:
+		if r.str() == posixRenameExtension && s.posixRename {
+			return status(os.Rename(s.local(r.str()), s.local(r.str())))
+		}
+	}
+	return status(errors.New("unsupported"))
+}
+
+func (s *testSFTPServer) file(handle string) *os.File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.handles[handle]
+}
+
+func status(err error) (byte, []byte) {
+	code := uint32(sshFxOK)
+	message := ""
+	switch {
+	case err == nil:
+	case err == io.EOF:
+		code = sshFxEOF
+	case os.IsNotExist(err):
+		code, message = sshFxNoSuchFile, err.Error()
+	default:
+		code, message = sshFxFailure, err.Error()
+	}
+	return sshFxpStatus, new(sftpBuffer).u32(code).str(message).str("").b
+}
+
+func TestSFTP_CreateCommit(t *testing.T) {
+	for _, posixRename := range []bool{true, false} {
+		t.Run(fmt.Sprintf("posix-rename=%v", posixRename), func(t *testing.T) {
+			server := &testSFTPServer{posixRename: posixRename}
+			s := newTestSFTP(t, server)
+
+			// Replacing an existing file exercises both rename paths
+			for _, content := range []string{"first", "second"} {
+				if err := writeObject(t, s, "Client/Project/001.mp4", []byte(content)); err != nil {
+					t.Fatalf("Upload failed: %v", err)
+				}
+			}
+
+			dir := filepath.Join(server.root, "media", "Client", "Project")
+			data, err := ioutil.ReadFile(filepath.Join(dir, "001.mp4"))
+			if err != nil || string(data) != "second" {
+				t.Fatalf("Expected committed file with content second, got %q (%v)", data, err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, ".001.mp4.part")); !os.IsNotExist(err) {
+				t.Errorf("Expected temporary file to be renamed away, got %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, ".001.mp4.old")); !os.IsNotExist(err) {
+				t.Errorf("Expected the replaced file to be removed, got %v", err)
+			}
+
+			info, err := s.Stat("Client/Project/001.mp4")
+			if err != nil || info.Size != 6 {
+				t.Errorf("Unexpected stat result: %+v (%v)", info, err)
+			}
+		})
+	}
+}
+
+func TestSFTP_CloseWithoutCommit(t *testing.T) {
+	server := &testSFTPServer{posixRename: true}
+	s := newTestSFTP(t, server)
+
+	w, err := s.Create("clip.mov")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	w.Write([]byte("partial"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	entries, _ := ioutil.ReadDir(filepath.Join(server.root, "media"))
+	if len(entries) != 0 {
+		t.Errorf("Expected no files after an uncommitted write, got %d", len(entries))
+	}
+	if _, err := s.Stat("clip.mov"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Expected ErrNotExist, got %v", err)
+	}
+	if exists, err := s.Exists("clip.mov"); err != nil || exists {
+		t.Errorf("Expected clip.mov to be missing, exists=%v err=%v", exists, err)
+	}
+}
+
+func TestSFTP_HashRereadsRemoteFile(t *testing.T) {
+	for _, maxRead := range []int{0, 10000} {
+		t.Run(fmt.Sprintf("max-read=%d", maxRead), func(t *testing.T) {
+			server := &testSFTPServer{posixRename: true, maxRead: maxRead}
+			s := newTestSFTP(t, server)
+
+			data := bytes.Repeat([]byte("0123456789abcdef"), 40000)
+			if err := writeObject(t, s, "large.mov", data); err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+
+			sum := md5.Sum(data)
+			digests, err := s.Hash("large.mov", []string{checksum.MD5})
+			if err != nil {
+				t.Fatalf("Hash failed: %v", err)
+			}
+			if digests[checksum.MD5] != hex.EncodeToString(sum[:]) {
+				t.Errorf("Remote hash %s doesn't match %x", digests[checksum.MD5], sum)
+			}
+
+			// Change the file behind the destination's back
+			ioutil.WriteFile(filepath.Join(server.root, "media", "large.mov"), []byte("tampered"), 0644)
+			digests, err = s.Hash("large.mov", []string{checksum.MD5})
+			if err != nil {
+				t.Fatalf("Hash failed: %v", err)
+			}
+			if digests[checksum.MD5] == hex.EncodeToString(sum[:]) {
+				t.Error("Expected the re-read to notice the changed remote file")
+			}
+		})
+	}
+}
+
+func TestSFTP_ConcurrentWriters(t *testing.T) {
+	server := &testSFTPServer{posixRename: true}
+	s := newTestSFTP(t, server)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w, err := s.Create(fmt.Sprintf("Client/Cam/%03d.mp4", i))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer w.Close()
+			if _, err := w.Write(bytes.Repeat([]byte{byte(i)}, 100000)); err != nil {
+				errs <- err
+				return
+			}
+			errs <- w.Commit()
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Concurrent upload failed: %v", err)
+		}
+	}
+	entries, _ := ioutil.ReadDir(filepath.Join(server.root, "media", "Client", "Cam"))
+	if len(entries) != 8 {
+		t.Errorf("Expected 8 files, got %d", len(entries))
+	}
+}
+
+func TestSFTP_SSHArgs(t *testing.T) {
+	s, err := NewSFTP("edit-bay", "/media", config.SFTPConfig{
+		Host:           "edit-bay.local",
+		Port:           2222,
+		User:           "ingest",
+		KeyFile:        "/etc/media-ingest/id_ed25519",
+		KnownHostsFile: "/etc/media-ingest/known_hosts",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SFTP destination: %v", err)
+	}
+
+	args := strings.Join(s.sshArgs(), " ")
+	for _, want := range []string{
+		"-o BatchMode=yes",
+		"-o StrictHostKeyChecking=yes",
+		"-o UserKnownHostsFile=/etc/media-ingest/known_hosts",
+		"-i /etc/media-ingest/id_ed25519",
+		"-p 2222",
+		"-l ingest",
+		"edit-bay.local -s sftp",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("Expected %q in ssh arguments: %s", want, args)
+		}
+	}
+
+	if loc := s.Location("Client/001.mp4"); loc != "sftp://ingest@edit-bay.local/media/Client/001.mp4" {
+		t.Errorf("Unexpected location: %s", loc)
+	}
+}
+
+func TestNewSFTP_RequiresKey(t *testing.T) {
+	if _, err := NewSFTP("x", "/media", config.SFTPConfig{Host: "h", User: "u"}); err == nil {
+		t.Error("Expected an error without a key file")
+	}
+}
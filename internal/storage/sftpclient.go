@@ -0,0 +1,583 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// SFTP version 3 packet types (draft-ietf-secsh-filexfer-02)
+const (
+	sshFxpInit     = 1
+	sshFxpVersion  = 2
+	sshFxpOpen     = 3
+	sshFxpClose    = 4
+	sshFxpRead     = 5
+	sshFxpWrite    = 6
+	sshFxpRemove   = 13
+	sshFxpMkdir    = 14
+	sshFxpStat     = 17
+	sshFxpRename   = 18
+	sshFxpStatus   = 101
+	sshFxpHandle   = 102
+	sshFxpData     = 103
+	sshFxpAttrs    = 105
+	sshFxpExtended = 200
+)
+
+// SFTP open flags
+const (
+	sshFxfRead  = 0x01
+	sshFxfWrite = 0x02
+	sshFxfCreat = 0x08
+	sshFxfTrunc = 0x10
+)
+
+// SFTP status codes
+const (
+	sshFxOK         = 0
+	sshFxEOF        = 1
+	sshFxNoSuchFile = 2
+	sshFxFailure    = 4
+)
+
+// SFTP attribute flags
+const (
+	sshFileXferAttrSize        = 0x01
+	sshFileXferAttrUIDGID      = 0x02
+	sshFileXferAttrPermissions = 0x04
+	sshFileXferAttrACModTime   = 0x08
+	sshFileXferAttrExtended    = 0x80000000
+)
+
+const (
+	// sftpChunkSize is the read and write size every server must accept
+	sftpChunkSize = 32768
+	// sftpMaxPacket bounds incoming packets to catch protocol corruption
+	sftpMaxPacket = 1 << 20
+	// sftpReadWindow is how many reads are in flight while hashing a file
+	sftpReadWindow = 16
+	// posixRenameExtension replaces the target atomically, unlike RENAME
+	posixRenameExtension = "posix-rename@openssh.com"
+)
+
+// sftpStatusError is an SSH_FXP_STATUS response other than OK
+type sftpStatusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *sftpStatusError) Error() string {
+	return fmt.Sprintf("sftp: %s (status %d)", e.Message, e.Code)
+}
+
+// Is makes missing files match ErrNotExist
+func (e *sftpStatusError) Is(target error) bool {
+	return target == ErrNotExist && e.Code == sshFxNoSuchFile
+}
+
+// sftpAttrs holds the file attributes this package uses
+type sftpAttrs struct {
+	Size    uint64
+	Mode    uint32
+	ModTime time.Time
+}
+
+// sftpPacket is a response from the server
+type sftpPacket struct {
+	typ  byte
+	data []byte
+}
+
+// sftpClient speaks SFTP version 3 over a stream. Requests from several
+// goroutines share the connection and are matched to responses by ID.
+type sftpClient struct {
+	conn       io.ReadWriteCloser
+	extensions map[string]string
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan sftpPacket
+	err     error
+}
+
+// newSFTPClient performs the version handshake on conn
+func newSFTPClient(conn io.ReadWriteCloser) (*sftpClient, error) {
+	if err := writeSFTPPacket(conn, sshFxpInit, new(sftpBuffer).u32(3).b); err != nil {
+		return nil, fmt.Errorf("sftp: failed to send init: %w", err)
+	}
+
+	typ, data, err := readSFTPPacket(conn)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: failed to read version: %w", err)
+	}
+	if typ != sshFxpVersion {
+		return nil, fmt.Errorf("sftp: unexpected packet type %d during handshake", typ)
+	}
+
+	r := &sftpReader{b: data}
+	if version := r.u32(); version != 3 {
+		return nil, fmt.Errorf("sftp: unsupported protocol version %d", version)
+	}
+
+	c := &sftpClient{
+		conn:       conn,
+		extensions: map[string]string{},
+		pending:    map[uint32]chan sftpPacket{},
+	}
+	for len(r.b) > 0 && r.err == nil {
+		name := r.str()
+		c.extensions[name] = r.str()
+	}
+
+	go c.readLoop()
+	return c, nil
+}
+
+// readLoop dispatches responses until the connection fails
+func (c *sftpClient) readLoop() {
+	for {
+		typ, data, err := readSFTPPacket(c.conn)
+		if err == nil && len(data) < 4 {
+			err = fmt.Errorf("sftp: short packet")
+		}
+		if err != nil {
+			c.mu.Lock()
+			c.err = fmt.Errorf("sftp: connection lost: %w", err)
+			for id, ch := range c.pending {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+			return
+		}
+
+		id := binary.BigEndian.Uint32(data)
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+
+		if ok {
+			ch <- sftpPacket{typ: typ, data: data[4:]}
+		}
+	}
+}
+
+// broken returns the error that ended the connection, if any
+func (c *sftpClient) broken() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// send writes a request and returns the channel its response arrives on
+func (c *sftpClient) send(typ byte, payload []byte) (chan sftpPacket, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan sftpPacket, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	packet := new(sftpBuffer).u32(id).raw(payload).b
+
+	c.writeMu.Lock()
+	err := writeSFTPPacket(c.conn, typ, packet)
+	c.writeMu.Unlock()
+
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, err
+	}
+	return ch, nil
+}
+
+// wait returns the response to a request
+func (c *sftpClient) wait(ch chan sftpPacket) (sftpPacket, error) {
+	p, ok := <-ch
+	if !ok {
+		return p, c.broken()
+	}
+	return p, nil
+}
+
+// call sends a request and waits for its response
+func (c *sftpClient) call(typ byte, payload []byte) (sftpPacket, error) {
+	ch, err := c.send(typ, payload)
+	if err != nil {
+		return sftpPacket{}, err
+	}
+	return c.wait(ch)
+}
+
+// callStatus sends a request answered with a status
+func (c *sftpClient) callStatus(typ byte, payload []byte) error {
+	p, err := c.call(typ, payload)
+	if err != nil {
+		return err
+	}
+	return expectStatus(p)
+}
+
+// expectStatus turns a status response into an error
+func expectStatus(p sftpPacket) error {
+	if p.typ != sshFxpStatus {
+		return fmt.Errorf("sftp: unexpected packet type %d", p.typ)
+	}
+
+	r := &sftpReader{b: p.data}
+	code := r.u32()
+	message := r.str()
+	switch code {
+	case sshFxOK:
+		return nil
+	case sshFxEOF:
+		return io.EOF
+	default:
+		if message == "" {
+			message = "request failed"
+		}
+		return &sftpStatusError{Code: code, Message: message}
+	}
+}
+
+// open opens a remote file and returns its handle
+func (c *sftpClient) open(path string, flags uint32, perm os.FileMode) (string, error) {
+	payload := new(sftpBuffer).str(path).u32(flags)
+	if flags&sshFxfCreat != 0 {
+		payload.u32(sshFileXferAttrPermissions).u32(uint32(perm.Perm()))
+	} else {
+		payload.u32(0)
+	}
+
+	p, err := c.call(sshFxpOpen, payload.b)
+	if err != nil {
+		return "", err
+	}
+	if p.typ != sshFxpHandle {
+		return "", expectStatus(p)
+	}
+	return (&sftpReader{b: p.data}).str(), nil
+}
+
+// closeHandle releases a file handle
+func (c *sftpClient) closeHandle(handle string) error {
+	return c.callStatus(sshFxpClose, new(sftpBuffer).str(handle).b)
+}
+
+// writeAt writes data at offset, pipelining one request per chunk
+func (c *sftpClient) writeAt(handle string, offset uint64, data []byte) error {
+	var waiting []chan sftpPacket
+	var firstErr error
+
+	for len(data) > 0 {
+		n := len(data)
+		if n > sftpChunkSize {
+			n = sftpChunkSize
+		}
+
+		payload := new(sftpBuffer).str(handle).u64(offset).bytes(data[:n]).b
+		ch, err := c.send(sshFxpWrite, payload)
+		if err != nil {
+			firstErr = err
+			break
+		}
+		waiting = append(waiting, ch)
+
+		data = data[n:]
+		offset += uint64(n)
+	}
+
+	for _, ch := range waiting {
+		p, err := c.wait(ch)
+		if err == nil {
+			err = expectStatus(p)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// readFile streams a remote file to w, keeping several reads in flight
+func (c *sftpClient) readFile(path string, w io.Writer) error {
+	handle, err := c.open(path, sshFxfRead, 0)
+	if err != nil {
+		return err
+	}
+	defer c.closeHandle(handle)
+
+	var window []sftpPendingRead
+	var next uint64
+	eof := false
+
+	for {
+		for !eof && len(window) < sftpReadWindow {
+			ch, err := c.send(sshFxpRead, new(sftpBuffer).str(handle).u64(next).u32(sftpChunkSize).b)
+			if err != nil {
+				return err
+			}
+			window = append(window, sftpPendingRead{offset: next, ch: ch})
+			next += sftpChunkSize
+		}
+		if len(window) == 0 {
+			return nil
+		}
+
+		req := window[0]
+		window = window[1:]
+		p, err := c.wait(req.ch)
+		if err != nil {
+			return err
+		}
+
+		if p.typ != sshFxpData {
+			err := expectStatus(p)
+			if err == nil {
+				err = fmt.Errorf("sftp: unexpected status reading %s", path)
+			}
+			if err != io.EOF {
+				return err
+			}
+			// Every read after the end of the file fails the same way
+			eof = true
+			if err := c.drain(window); err != nil {
+				return err
+			}
+			window = nil
+			continue
+		}
+
+		data := (&sftpReader{b: p.data}).bytes()
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+
+		// Reads in flight assume full chunks, so a short one restarts the
+		// window right after the data received
+		if len(data) < sftpChunkSize {
+			if err := c.drain(window); err != nil {
+				return err
+			}
+			window = nil
+			next = req.offset + uint64(len(data))
+			eof = len(data) == 0
+		}
+	}
+}
+
+// sftpPendingRead is a read request in flight
+type sftpPendingRead struct {
+	offset uint64
+	ch     chan sftpPacket
+}
+
+// drain waits for reads whose responses are no longer needed
+func (c *sftpClient) drain(window []sftpPendingRead) error {
+	for _, req := range window {
+		if _, err := c.wait(req.ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stat returns the attributes of a remote path
+func (c *sftpClient) stat(path string) (sftpAttrs, error) {
+	p, err := c.call(sshFxpStat, new(sftpBuffer).str(path).b)
+	if err != nil {
+		return sftpAttrs{}, err
+	}
+	if p.typ != sshFxpAttrs {
+		return sftpAttrs{}, expectStatus(p)
+	}
+	return parseSFTPAttrs(&sftpReader{b: p.data}), nil
+}
+
+// mkdir creates a remote directory
+func (c *sftpClient) mkdir(path string, perm os.FileMode) error {
+	payload := new(sftpBuffer).str(path).u32(sshFileXferAttrPermissions).u32(uint32(perm.Perm()))
+	return c.callStatus(sshFxpMkdir, payload.b)
+}
+
+// remove deletes a remote file
+func (c *sftpClient) remove(path string) error {
+	return c.callStatus(sshFxpRemove, new(sftpBuffer).str(path).b)
+}
+
+// rename moves oldPath to newPath, replacing newPath. The OpenSSH
+// posix-rename extension does this atomically; plain SFTP v3 refuses to
+// overwrite, so there the target is moved aside and only removed once the
+// new file is in place.
+func (c *sftpClient) rename(oldPath, newPath string) error {
+	if _, ok := c.extensions[posixRenameExtension]; ok {
+		payload := new(sftpBuffer).str(posixRenameExtension).str(oldPath).str(newPath)
+		return c.callStatus(sshFxpExtended, payload.b)
+	}
+
+	// A file left aside by an interrupted rename was being replaced anyway
+	aside := path.Join(path.Dir(newPath), "."+path.Base(newPath)+".old")
+	if err := c.remove(aside); err != nil && !errors.Is(err, ErrNotExist) {
+		return err
+	}
+	err := c.callStatus(sshFxpRename, new(sftpBuffer).str(newPath).str(aside).b)
+	if err != nil && !errors.Is(err, ErrNotExist) {
+		return err
+	}
+	replaced := err == nil
+
+	if err := c.callStatus(sshFxpRename, new(sftpBuffer).str(oldPath).str(newPath).b); err != nil {
+		if replaced {
+			c.callStatus(sshFxpRename, new(sftpBuffer).str(aside).str(newPath).b)
+		}
+		return err
+	}
+	if replaced {
+		// The new file is in place; a copy left aside is removed next time
+		c.remove(aside)
+	}
+	return nil
+}
+
+// Close ends the session
+func (c *sftpClient) Close() error {
+	return c.conn.Close()
+}
+
+// parseSFTPAttrs decodes an ATTRS structure
+func parseSFTPAttrs(r *sftpReader) sftpAttrs {
+	var attrs sftpAttrs
+	flags := r.u32()
+	if flags&sshFileXferAttrSize != 0 {
+		attrs.Size = r.u64()
+	}
+	if flags&sshFileXferAttrUIDGID != 0 {
+		r.u32()
+		r.u32()
+	}
+	if flags&sshFileXferAttrPermissions != 0 {
+		attrs.Mode = r.u32()
+	}
+	if flags&sshFileXferAttrACModTime != 0 {
+		r.u32()
+		attrs.ModTime = time.Unix(int64(r.u32()), 0)
+	}
+	if flags&sshFileXferAttrExtended != 0 {
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			r.str()
+			r.str()
+		}
+	}
+	return attrs
+}
+
+// writeSFTPPacket frames and sends one packet
+func writeSFTPPacket(w io.Writer, typ byte, payload []byte) error {
+	packet := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(packet, uint32(1+len(payload)))
+	packet[4] = typ
+	copy(packet[5:], payload)
+	_, err := w.Write(packet)
+	return err
+}
+
+// readSFTPPacket reads one framed packet
+func readSFTPPacket(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 1 || length > sftpMaxPacket {
+		return 0, nil, fmt.Errorf("sftp: invalid packet length %d", length)
+	}
+
+	data := make([]byte, length-1)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return header[4], data, nil
+}
+
+// sftpBuffer builds packet payloads
+type sftpBuffer struct {
+	b []byte
+}
+
+func (b *sftpBuffer) u32(v uint32) *sftpBuffer {
+	b.b = append(b.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	return b
+}
+
+func (b *sftpBuffer) u64(v uint64) *sftpBuffer {
+	return b.u32(uint32(v >> 32)).u32(uint32(v))
+}
+
+func (b *sftpBuffer) str(s string) *sftpBuffer {
+	b.u32(uint32(len(s)))
+	b.b = append(b.b, s...)
+	return b
+}
+
+func (b *sftpBuffer) bytes(p []byte) *sftpBuffer {
+	b.u32(uint32(len(p)))
+	b.b = append(b.b, p...)
+	return b
+}
+
+func (b *sftpBuffer) raw(p []byte) *sftpBuffer {
+	b.b = append(b.b, p...)
+	return b
+}
+
+// sftpReader decodes packet payloads; reading past the end sets err
+type sftpReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sftpReader) u32() uint32 {
+	if len(r.b) < 4 {
+		r.err = io.ErrUnexpectedEOF
+		r.b = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *sftpReader) u64() uint64 {
+	return uint64(r.u32())<<32 | uint64(r.u32())
+}
+
+func (r *sftpReader) bytes() []byte {
+	n := r.u32()
+	if uint32(len(r.b)) < n {
+		r.err = io.ErrUnexpectedEOF
+		r.b = nil
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *sftpReader) str() string {
+	return string(r.bytes())
+}
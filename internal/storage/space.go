@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrSpaceUnknown is returned where free space can't be determined
+var ErrSpaceUnknown = errors.New("free space unknown")
+
+// Space describes the filesystem a destination is stored on
+type Space struct {
+	// Free is the number of bytes available for new files
+	Free int64
+	// Volume identifies the filesystem, so destinations sharing one can be
+	// counted together
+	Volume string
+}
+
+// SpaceReporter is implemented by destinations that can report free space
+type SpaceReporter interface {
+	Space() (Space, error)
+}
+
+// Space returns the free space of the filesystem holding the root folder
+func (l *Local) Space() (Space, error) {
+	return diskSpace(existingParent(l.root))
+}
+
+// existingParent returns path or its closest existing parent, since the
+// root folder is only created with the first file
+func existingParent(path string) string {
+	path = filepath.Clean(path)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
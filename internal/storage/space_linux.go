@@ -0,0 +1,28 @@
+// +build linux
+
+package storage
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// diskSpace uses statfs for the space available to unprivileged users and
+// the device number of path to identify the filesystem
+func diskSpace(path string) (Space, error) {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err != nil {
+		return Space{}, fmt.Errorf("statfs %s: %w", path, err)
+	}
+
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return Space{}, fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	return Space{
+		Free:   int64(fs.Bavail) * int64(fs.Bsize),
+		Volume: fmt.Sprintf("dev:%d", st.Dev),
+	}, nil
+}
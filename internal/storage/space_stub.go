@@ -0,0 +1,8 @@
+// +build !linux,!windows
+
+package storage
+
+// diskSpace stub for platforms other than Linux and Windows
+func diskSpace(path string) (Space, error) {
+	return Space{}, ErrSpaceUnknown
+}
@@ -0,0 +1,34 @@
+// +build windows
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// diskSpace uses GetDiskFreeSpaceEx for the space available to the user
+// and the volume mount point of path to identify the filesystem
+func diskSpace(path string) (Space, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return Space{}, err
+	}
+
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, &total, &totalFree); err != nil {
+		return Space{}, fmt.Errorf("GetDiskFreeSpaceEx %s: %w", path, err)
+	}
+
+	volume := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumePathName(p, &volume[0], uint32(len(volume))); err != nil {
+		return Space{}, fmt.Errorf("GetVolumePathName %s: %w", path, err)
+	}
+
+	return Space{
+		Free:   int64(free),
+		Volume: "volume:" + strings.ToUpper(windows.UTF16ToString(volume)),
+	}, nil
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+)
+
+// ErrNotExist is returned by Stat for files missing from a destination
+var ErrNotExist = os.ErrNotExist
+
+// FileInfo describes a file stored on a destination
+type FileInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Writer receives the content of one file
+type Writer interface {
+	io.Writer
+	// Commit publishes the file at its final path once fully written
+	Commit() error
+	// Close releases the writer, discarding the file if it wasn't committed
+	Close() error
+}
+
+// FileWriter is a Writer backed by a local file, which lets the transfer
+// manager use kernel copies, cache hints and uncached readback
+type FileWriter interface {
+	Writer
+	File() *os.File
+}
+
+// SizedCreator is implemented by destinations that plan an upload from the
+// size of the file, such as S3 choosing a part size
+type SizedCreator interface {
+	CreateSized(path string, size int64) (Writer, error)
+}
+
+// CreateSized starts writing a file of a known size, telling destinations
+// that want it
+func CreateSized(d Destination, path string, size int64) (Writer, error) {
+	if sized, ok := d.(SizedCreator); ok {
+		return sized.CreateSized(path, size)
+	}
+	return d.Create(path)
+}
+
+// Destination stores ingested files. Paths are slash-separated and relative
+// to the root of the destination.
+type Destination interface {
+	// Name identifies the destination in logs and statistics
+	Name() string
+	// Location returns a human-readable location of a path
+	Location(path string) string
+	// Create starts writing a file, replacing any existing one on commit
+	Create(path string) (Writer, error)
+	// Stat returns information on a stored file, or ErrNotExist
+	Stat(path string) (FileInfo, error)
+	// Exists reports whether a file is stored at path. Colliding files are
+	// versioned as _v2, _v3... based on it.
+	Exists(path string) (bool, error)
+	// Hash reads a stored file back and hashes it with the given algorithms
+	Hash(path string, algorithms []string) (checksum.Digests, error)
+	// Remove deletes a stored file
+	Remove(path string) error
+}
+
+// Factory creates a destination from its configuration
+type Factory func(cfg config.DestinationConfig) (Destination, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{
+		config.DestinationLocal:  newLocalFromConfig,
+		config.DestinationS3:     newS3FromConfig,
+		config.DestinationSFTP:   newSFTPFromConfig,
+		config.DestinationWebDAV: newWebDAVFromConfig,
+	}
+)
+
+// Register adds or replaces a destination type
+func Register(kind string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[strings.ToLower(kind)] = factory
+}
+
+// New creates the destination described by cfg
+func New(cfg config.DestinationConfig) (Destination, error) {
+	kind := strings.ToLower(cfg.Type)
+	if kind == "" {
+		kind = config.DestinationLocal
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[kind]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported destination type: %s", cfg.Type)
+	}
+	return factory(cfg)
+}
+
+// Types returns the registered destination types in sorted order
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	kinds := make([]string, 0, len(registry))
+	for kind := range registry {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// existsFromStat implements Exists on top of Stat
+func existsFromStat(d Destination, path string) (bool, error) {
+	_, err := d.Stat(path)
+	if errors.Is(err, ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
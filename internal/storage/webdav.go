@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+)
+
+// errUploadAborted ends an upload that is closed without being committed
+var errUploadAborted = errors.New("upload aborted")
+
+// propfindBody asks for the properties Stat needs
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+// WebDAV stores files in a collection on a WebDAV server. Uploads stream to
+// a temporary name with a chunked PUT and are moved into place on commit.
+type WebDAV struct {
+	name   string
+	base   *url.URL
+	config config.WebDAVConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	collections map[string]bool
+}
+
+// NewWebDAV creates a WebDAV destination for the collection at cfg.URL
+func NewWebDAV(name string, cfg config.WebDAVConfig) (*WebDAV, error) {
+	base, err := url.Parse(cfg.URL)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("destination %s: invalid webdav.url %q", name, cfg.URL)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	base.RawPath = ""
+	base.User = nil
+
+	return &WebDAV{
+		name:        name,
+		base:        base,
+		config:      cfg,
+		client:      &http.Client{},
+		collections: map[string]bool{},
+	}, nil
+}
+
+func newWebDAVFromConfig(cfg config.DestinationConfig) (Destination, error) {
+	return NewWebDAV(cfg.Name, cfg.WebDAV)
+}
+
+// Name returns the destination name
+func (d *WebDAV) Name() string {
+	return d.name
+}
+
+// Location returns the URL of a file
+func (d *WebDAV) Location(file string) string {
+	return d.url(file)
+}
+
+// Create makes the missing collections above file and starts streaming the
+// upload to a temporary resource next to it
+func (d *WebDAV) Create(file string) (Writer, error) {
+	file = path.Clean("/" + file)
+	dir := path.Dir(file)
+	if err := d.mkcolAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to create collection %s: %w", dir, err)
+	}
+
+	pr, pw := io.Pipe()
+	w := &webdavWriter{
+		dav:   d,
+		temp:  path.Join(dir, "."+path.Base(file)+".part"),
+		final: file,
+		pipe:  pw,
+		done:  make(chan error, 1),
+	}
+
+	go func() {
+		err := d.put(w.temp, pr)
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return w, nil
+}
+
+// Stat returns the size and modification time of a file
+func (d *WebDAV) Stat(file string) (FileInfo, error) {
+	props, err := d.propfind(file)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	modTime, _ := http.ParseTime(props.LastModified)
+	return FileInfo{Size: props.ContentLength, ModTime: modTime}, nil
+}
+
+// Exists reports whether a file is stored at path
+func (d *WebDAV) Exists(file string) (bool, error) {
+	return existsFromStat(d, file)
+}
+
+// Hash downloads a file and hashes it
+func (d *WebDAV) Hash(file string, algorithms []string) (checksum.Digests, error) {
+	resp, err := d.do(http.MethodGet, file, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return checksum.Sum(resp.Body, algorithms)
+}
+
+// Remove deletes a file
+func (d *WebDAV) Remove(file string) error {
+	resp, err := d.do(http.MethodDelete, file, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// url returns the absolute URL of a path within the collection
+func (d *WebDAV) url(file string) string {
+	u := *d.base
+	u.Path = d.base.Path + path.Clean("/"+file)
+	return u.String()
+}
+
+// webdavError is an unexpected response from the server
+type webdavError struct {
+	Method string
+	Status int
+}
+
+func (e *webdavError) Error() string {
+	return fmt.Sprintf("webdav: %s failed: HTTP %d %s", e.Method, e.Status, http.StatusText(e.Status))
+}
+
+// Is makes missing resources match ErrNotExist
+func (e *webdavError) Is(target error) bool {
+	return target == ErrNotExist && e.Status == http.StatusNotFound
+}
+
+// newRequest builds an authenticated request for a path
+func (d *WebDAV) newRequest(method, file string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, d.url(file), body)
+	if err != nil {
+		return nil, err
+	}
+	if d.config.Username != "" {
+		req.SetBasicAuth(d.config.Username, d.config.Password)
+	}
+	return req, nil
+}
+
+// do sends a request, retrying network errors and server errors. Responses
+// other than 2xx are returned as errors.
+func (d *WebDAV) do(method, file string, header http.Header, body []byte) (*http.Response, error) {
+	var lastErr error
+	for attempt := 1; attempt <= httpMaxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * 200 * time.Millisecond)
+		}
+
+		req, err := d.newRequest(method, file, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = int64(len(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		lastErr = &webdavError{Method: method, Status: resp.StatusCode}
+		if resp.StatusCode < 500 {
+			return nil, lastErr
+		}
+	}
+	return nil, lastErr
+}
+
+// webdavProps holds the properties of one resource
+type webdavProps struct {
+	ContentLength int64  `xml:"getcontentlength"`
+	LastModified  string `xml:"getlastmodified"`
+	ResourceType  struct {
+		Collection *struct{} `xml:"collection"`
+	} `xml:"resourcetype"`
+}
+
+type webdavMultistatus struct {
+	Responses []struct {
+		Propstats []struct {
+			Prop   webdavProps `xml:"prop"`
+			Status string      `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// propfind returns the properties of a resource, or ErrNotExist
+func (d *WebDAV) propfind(file string) (*webdavProps, error) {
+	header := http.Header{
+		"Depth":        {"0"},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	}
+	resp, err := d.do("PROPFIND", file, header, []byte(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ms webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("webdav: invalid PROPFIND response: %w", err)
+	}
+
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if statusCode(ps.Status) == http.StatusOK {
+				return &ps.Prop, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("webdav: PROPFIND returned no properties for %s", file)
+}
+
+// mkcolAll creates dir and its missing parent collections. PROPFIND finds
+// the existing ones so MKCOL is only sent where needed.
+func (d *WebDAV) mkcolAll(dir string) error {
+	if dir == "/" || dir == "." {
+		return nil
+	}
+
+	d.mu.Lock()
+	known := d.collections[dir]
+	d.mu.Unlock()
+	if known {
+		return nil
+	}
+
+	props, err := d.propfind(dir)
+	switch {
+	case err == nil && props.ResourceType.Collection == nil:
+		return fmt.Errorf("%s exists and is not a collection", dir)
+	case errors.Is(err, ErrNotExist):
+		if err := d.mkcolAll(path.Dir(dir)); err != nil {
+			return err
+		}
+		resp, err := d.do("MKCOL", dir, nil, nil)
+		// 405 means another worker created it in the meantime
+		var davErr *webdavError
+		if errors.As(err, &davErr) && davErr.Status == http.StatusMethodNotAllowed {
+			err = nil
+		} else if err == nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	}
+
+	d.mu.Lock()
+	d.collections[dir] = true
+	d.mu.Unlock()
+	return nil
+}
+
+// put streams body to file using chunked transfer encoding
+func (d *WebDAV) put(file string, body io.Reader) error {
+	req, err := d.newRequest(http.MethodPut, file, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = -1
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return &webdavError{Method: http.MethodPut, Status: resp.StatusCode}
+	}
+	return nil
+}
+
+// move renames a resource, replacing the destination
+func (d *WebDAV) move(from, to string) error {
+	header := http.Header{
+		"Destination": {d.url(to)},
+		"Overwrite":   {"T"},
+	}
+	resp, err := d.do("MOVE", from, header, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// webdavWriter streams a temporary resource and moves it into place
+type webdavWriter struct {
+	dav       *WebDAV
+	temp      string
+	final     string
+	pipe      *io.PipeWriter
+	done      chan error
+	finished  bool
+	committed bool
+	closed    bool
+}
+
+func (w *webdavWriter) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+// finish ends the request body and waits for the server's answer
+func (w *webdavWriter) finish(err error) error {
+	if w.finished {
+		return nil
+	}
+	w.finished = true
+	w.pipe.CloseWithError(err)
+	return <-w.done
+}
+
+func (w *webdavWriter) Commit() error {
+	if err := w.finish(nil); err != nil {
+		return err
+	}
+
+	if err := w.dav.move(w.temp, w.final); err != nil {
+		w.dav.Remove(w.temp)
+		return fmt.Errorf("failed to move %s into place: %w", w.temp, err)
+	}
+	w.committed = true
+	return nil
+}
+
+func (w *webdavWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if !w.committed {
+		w.finish(errUploadAborted)
+		w.dav.Remove(w.temp)
+	}
+	return nil
+}
+
+// statusCode extracts the code from a status line such as "HTTP/1.1 200 OK"
+func statusCode(line string) int {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return 0
+	}
+	code, _ := strconv.Atoi(fields[1])
+	return code
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/storage/webdavtest"
+)
+
+func newTestWebDAV(t *testing.T, serverURL string) *WebDAV {
+	t.Helper()
+	d, err := NewWebDAV("delivery", config.WebDAVConfig{
+		URL:      serverURL + webdavtest.Prefix + "/",
+		Username: webdavtest.Username,
+		Password: webdavtest.Password,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create WebDAV destination: %v", err)
+	}
+	return d
+}
+
+func TestWebDAV_CreateCommit(t *testing.T) {
+	fake, server := webdavtest.New(t)
+	d := newTestWebDAV(t, server.URL)
+
+	data := bytes.Repeat([]byte("frame"), 100000)
+	if err := writeObject(t, d, "Client/Project A/ACam/001.mp4", data); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	if !bytes.Equal(fake.Files["/Client/Project A/ACam/001.mp4"], data) {
+		t.Fatalf("Expected the file to be moved into place, files: %d", len(fake.Files))
+	}
+	if _, ok := fake.Files["/Client/Project A/ACam/.001.mp4.part"]; ok {
+		t.Error("Expected the temporary upload to be moved away")
+	}
+	for _, dir := range []string{"/Client", "/Client/Project A", "/Client/Project A/ACam"} {
+		if !fake.Collections[dir] {
+			t.Errorf("Expected collection %s to be created", dir)
+		}
+	}
+	if fake.ChunkedPuts != 1 {
+		t.Errorf("Expected a chunked PUT, got %d", fake.ChunkedPuts)
+	}
+
+	// Known collections aren't checked again
+	mkcols := fake.Methods["MKCOL"]
+	if err := writeObject(t, d, "Client/Project A/ACam/002.mp4", []byte("x")); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if fake.Methods["MKCOL"] != mkcols {
+		t.Errorf("Expected no further MKCOL requests, got %d", fake.Methods["MKCOL"]-mkcols)
+	}
+
+	if loc := d.Location("Client/Project A/ACam/001.mp4"); loc != server.URL+"/dav/Client/Project%20A/ACam/001.mp4" {
+		t.Errorf("Unexpected location: %s", loc)
+	}
+}
+
+func TestWebDAV_StatHashRemove(t *testing.T) {
+	_, server := webdavtest.New(t)
+	d := newTestWebDAV(t, server.URL)
+
+	if err := writeObject(t, d, "clip.mov", []byte("abc")); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	info, err := d.Stat("clip.mov")
+	if err != nil || info.Size != 3 || info.ModTime.IsZero() {
+		t.Errorf("Unexpected stat result: %+v (%v)", info, err)
+	}
+
+	digests, err := d.Hash("clip.mov", []string{checksum.MD5})
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	if digests[checksum.MD5] != "900150983cd24fb0d6963f7d28e17f72" {
+		t.Errorf("Unexpected md5: %s", digests[checksum.MD5])
+	}
+
+	if err := d.Remove("clip.mov"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := d.Stat("clip.mov"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Expected ErrNotExist, got %v", err)
+	}
+	if exists, err := d.Exists("clip.mov"); err != nil || exists {
+		t.Errorf("Expected clip.mov to be gone, exists=%v err=%v", exists, err)
+	}
+}
+
+func TestWebDAV_CloseWithoutCommit(t *testing.T) {
+	fake, server := webdavtest.New(t)
+	d := newTestWebDAV(t, server.URL)
+
+	w, err := d.Create("Client/clip.mov")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	w.Write([]byte("partial"))
+	w.Close()
+
+	if len(fake.Files) != 0 {
+		t.Errorf("Expected no files after an uncommitted upload, got %v", fake.Files)
+	}
+}
+
+func TestWebDAV_FileInPlaceOfCollection(t *testing.T) {
+	fake, server := webdavtest.New(t)
+	d := newTestWebDAV(t, server.URL)
+	fake.Files["/Client"] = []byte("not a folder")
+
+	if _, err := d.Create("Client/clip.mov"); err == nil {
+		t.Error("Expected an error when a file blocks the folder structure")
+	}
+}
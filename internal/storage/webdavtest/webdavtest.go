@@ -0,0 +1,158 @@
+// Package webdavtest provides an in-memory WebDAV server for tests of
+// WebDAV destinations.
+package webdavtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// Prefix is the path the server serves WebDAV under
+	Prefix = "/dav"
+	// Username and Password are the basic auth credentials it accepts
+	Username = "ingest"
+	Password = "secret"
+)
+
+// Server is an in-memory WebDAV server with the methods the destination
+// uses. Its fields may be read and changed while no request is running.
+type Server struct {
+	mu sync.Mutex
+	// Files holds file contents by path below Prefix
+	Files map[string][]byte
+	// Collections holds the paths of existing collections
+	Collections map[string]bool
+	// ChunkedPuts counts the uploads sent with chunked encoding
+	ChunkedPuts int
+	// Methods counts the requests of each method
+	Methods map[string]int
+}
+
+// New starts a server holding an empty root collection, closed when the
+// test ends
+func New(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	s := &Server{
+		Files:       map[string][]byte{},
+		Collections: map[string]bool{"/": true},
+		Methods:     map[string]int{},
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, pass, ok := r.BasicAuth(); !ok || user != Username || pass != Password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Methods[r.Method]++
+
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, Prefix))
+	parentExists := s.Collections[path.Dir(name)]
+
+	switch r.Method {
+	case "PROPFIND":
+		data, isFile := s.Files[name]
+		if !isFile && !s.Collections[name] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		resourceType := "<d:collection/>"
+		if isFile {
+			resourceType = ""
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:"><d:response><d:href>%s</d:href>`+
+			`<d:propstat><d:prop><d:resourcetype>%s</d:resourcetype><d:getcontentlength>%d</d:getcontentlength>`+
+			`<d:getlastmodified>%s</d:getlastmodified></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>`+
+			`</d:response></d:multistatus>`, r.URL.Path, resourceType, len(data), time.Now().UTC().Format(http.TimeFormat))
+
+	case "MKCOL":
+		switch {
+		case s.Collections[name] || s.Files[name] != nil:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case !parentExists:
+			w.WriteHeader(http.StatusConflict)
+		default:
+			s.Collections[name] = true
+			w.WriteHeader(http.StatusCreated)
+		}
+
+	case http.MethodPut:
+		if !parentExists {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		for _, te := range r.TransferEncoding {
+			if te == "chunked" {
+				s.ChunkedPuts++
+			}
+		}
+		s.Files[name] = body
+		w.WriteHeader(http.StatusCreated)
+
+	case "MOVE":
+		data, ok := s.Files[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		dest, err := url.Parse(r.Header.Get("Destination"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		target := path.Clean("/" + strings.TrimPrefix(dest.Path, Prefix))
+		_, exists := s.Files[target]
+		if exists && r.Header.Get("Overwrite") == "F" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		s.Files[target] = data
+		delete(s.Files, name)
+		if exists {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+
+	case http.MethodGet:
+		data, ok := s.Files[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+
+	case http.MethodDelete:
+		if _, ok := s.Files[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(s.Files, name)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
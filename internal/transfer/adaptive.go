@@ -0,0 +1,144 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// adaptGain is the speedup a worker count needs over the best one so far
+// to be worth its extra load on the source
+const adaptGain = 0.1
+
+// workerTuner searches for the worker count with the highest aggregate
+// throughput. It doubles the count while that pays off, then tries the
+// count halfway back before settling on the best one measured.
+type workerTuner struct {
+	max     int
+	workers int
+	// upper is the first count that didn't pay off
+	upper    int
+	best     int
+	bestRate float64
+}
+
+func newWorkerTuner(max int) *workerTuner {
+	if max < 1 {
+		max = 1
+	}
+	return &workerTuner{max: max, workers: 1}
+}
+
+// observe records the bytes per second measured with the current worker
+// count and returns the count to use next; done is set once it's settled
+func (t *workerTuner) observe(rate float64) (workers int, done bool) {
+	if t.best == 0 || rate > t.bestRate*(1+adaptGain) {
+		t.best, t.bestRate = t.workers, rate
+	} else if t.upper == 0 || t.workers < t.upper {
+		t.upper = t.workers
+	}
+
+	next := t.workers * 2
+	if t.upper != 0 {
+		next = (t.best + t.upper) / 2
+	}
+	if next > t.max {
+		next = t.max
+	}
+
+	if next == t.best || next == t.workers || (t.upper != 0 && next >= t.upper) {
+		t.workers = t.best
+		return t.best, true
+	}
+	t.workers = next
+	return next, false
+}
+
+// workerPool runs a resizable set of workers on the jobs of an ingest
+type workerPool struct {
+	m          *Manager
+	deviceName string
+	jobs       <-chan FileTransfer
+	results    chan<- error
+
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	stops []chan struct{}
+}
+
+// resize starts or stops workers until n are running. Stopped workers
+// finish the file they're copying first.
+func (p *workerPool) resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.stops) < n {
+		stop := make(chan struct{})
+		p.stops = append(p.stops, stop)
+		p.wg.Add(1)
+		go p.m.worker(p.deviceName, p.jobs, p.results, stop, &p.wg)
+	}
+	for len(p.stops) > n {
+		close(p.stops[len(p.stops)-1])
+		p.stops = p.stops[:len(p.stops)-1]
+	}
+}
+
+// size returns the number of running workers
+func (p *workerPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.stops)
+}
+
+// adaptWorkers measures the throughput of each worker count the tuner
+// suggests and resizes the pool until it settles or the jobs run out
+func (m *Manager) adaptWorkers(deviceName string, pool *workerPool, jobs <-chan FileTransfer) {
+	tuner := newWorkerTuner(m.config.Transfer.MaxWorkers)
+	pool.resize(tuner.workers)
+
+	poll := time.NewTicker(m.adaptInterval / 10)
+	defer poll.Stop()
+
+	for {
+		start, startBytes := time.Now(), atomic.LoadInt64(&m.copiedBytes)
+		for time.Since(start) < m.adaptInterval {
+			<-poll.C
+			if len(jobs) == 0 {
+				m.logger.DeviceInfo(deviceName, "Adaptive workers: using %d, all files started before measuring finished", pool.size())
+				return
+			}
+		}
+
+		rate := float64(atomic.LoadInt64(&m.copiedBytes)-startBytes) / time.Since(start).Seconds()
+		workers := pool.size()
+		m.logger.Debug("Device %s: %d workers copied %s/s", deviceName, workers, formatRate(rate))
+
+		next, done := tuner.observe(rate)
+		pool.resize(next)
+		if done {
+			m.logger.DeviceInfo(deviceName, "Adaptive workers settled on %d (%s/s, %s/s per worker)",
+				next, formatRate(tuner.bestRate), formatRate(tuner.bestRate/float64(next)))
+			return
+		}
+	}
+}
+
+// countingReader adds the bytes read to a shared counter
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// formatRate formats a number of bytes per second in MB
+func formatRate(rate float64) string {
+	return fmt.Sprintf("%.1f MB", rate/(1024*1024))
+}
@@ -0,0 +1,73 @@
+package transfer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkerTuner(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		// speed returns the throughput measured with n workers
+		speed func(n int) float64
+		want  int
+	}{
+		{"sd card slows down with parallel readers", 8, func(n int) float64 { return 90 / float64(n) }, 1},
+		{"nvme scales to the limit", 8, func(n int) float64 { return 500 * float64(n) }, 8},
+		{"raid peaks at three", 16, func(n int) float64 {
+			return map[int]float64{1: 100, 2: 180, 3: 240, 4: 170}[n]
+		}, 3},
+		{"gain too small to keep", 8, func(n int) float64 { return 100 + float64(n) }, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tuner := newWorkerTuner(tt.max)
+			workers, tried := tuner.workers, []int{}
+			for i := 0; i < 20; i++ {
+				tried = append(tried, workers)
+				next, done := tuner.observe(tt.speed(workers))
+				workers = next
+				if done {
+					break
+				}
+			}
+			if workers != tt.want {
+				t.Errorf("Expected %d workers, got %d (tried %v)", tt.want, workers, tried)
+			}
+		})
+	}
+}
+
+func TestTransferManager_AdaptiveWorkers(t *testing.T) {
+	destDir := t.TempDir()
+	srcDir := t.TempDir()
+
+	var files []string
+	for i := 1; i <= 40; i++ {
+		name := fmt.Sprintf("Adapt_Client_ACam_%03d.mp4", i)
+		files = append(files, writeTestFile(t, filepath.Join(srcDir, name), strings.Repeat("a", 256*1024)))
+	}
+
+	cfg := newTestConfig(t, destDir)
+	cfg.Transfer.MaxWorkers = 4
+	cfg.Transfer.AdaptiveWorkers = true
+
+	mgr := newTestManager(t, cfg)
+	mgr.adaptInterval = 10 * time.Millisecond
+	if err := mgr.TransferFiles("test-device", files); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	stats := mgr.GetStats()
+	if stats.ProcessedFiles != 40 || stats.FailedFiles != 0 {
+		t.Errorf("Expected all 40 files transferred, got %d processed, %d failed", stats.ProcessedFiles, stats.FailedFiles)
+	}
+	if stats.Workers < 1 || stats.Workers > 4 {
+		t.Errorf("Expected between 1 and 4 workers, got %d", stats.Workers)
+	}
+}
@@ -0,0 +1,153 @@
+package transfer
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// defaultBufferSize matches the default applied by config.Validate
+	defaultBufferSize = 1048576
+
+	// pipelineDepth is the number of buffers each sink may have queued
+	pipelineDepth = 4
+)
+
+// bufferPool hands out fixed-size copy buffers shared by all workers
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// newBufferPool creates a pool of buffers of the given size
+func newBufferPool(size int) *bufferPool {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+
+	p := &bufferPool{size: size}
+	p.pool.New = func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+// Get returns a buffer from the pool
+func (p *bufferPool) Get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// Put returns a buffer to the pool
+func (p *bufferPool) Put(buf *[]byte) {
+	if buf == nil || len(*buf) != p.size {
+		return
+	}
+	p.pool.Put(buf)
+}
+
+// chunk is a filled buffer on its way from the reader to every sink
+type chunk struct {
+	buf  *[]byte
+	n    int
+	refs int32
+}
+
+// release drops one reference and recycles the buffer once all sinks are done
+func (c *chunk) release(pool *bufferPool) {
+	if atomic.AddInt32(&c.refs, -1) == 0 {
+		pool.Put(c.buf)
+	}
+}
+
+// pipelineCopy copies src to every sink, reading the next buffer while the
+// previous ones are still being written and hashed. Each sink runs on its own
+// goroutine so a slow destination never stalls hashing and vice versa.
+func pipelineCopy(pool *bufferPool, src io.Reader, sinks ...io.Writer) (int64, error) {
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		sinkErr  error
+		failed   = make(chan struct{})
+		channels = make([]chan *chunk, len(sinks))
+	)
+
+	fail := func(err error) {
+		errOnce.Do(func() {
+			sinkErr = err
+			close(failed)
+		})
+	}
+
+	for i, sink := range sinks {
+		ch := make(chan *chunk, pipelineDepth)
+		channels[i] = ch
+
+		wg.Add(1)
+		go func(w io.Writer, ch <-chan *chunk) {
+			defer wg.Done()
+
+			// Keep draining after an error so buffers return to the pool
+			// and the reader never blocks on a dead sink
+			broken := false
+			for c := range ch {
+				if !broken {
+					n, err := w.Write((*c.buf)[:c.n])
+					if err == nil && n < c.n {
+						err = io.ErrShortWrite
+					}
+					if err != nil {
+						broken = true
+						fail(err)
+					}
+				}
+				c.release(pool)
+			}
+		}(sink, ch)
+	}
+
+	var (
+		written int64
+		readErr error
+	)
+
+read:
+	for {
+		select {
+		case <-failed:
+			break read
+		default:
+		}
+
+		buf := pool.Get()
+		n, err := src.Read(*buf)
+		if n > 0 {
+			c := &chunk{buf: buf, n: n, refs: int32(len(channels))}
+			for _, ch := range channels {
+				ch <- c
+			}
+			written += int64(n)
+		} else {
+			pool.Put(buf)
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+
+	for _, ch := range channels {
+		close(ch)
+	}
+	wg.Wait()
+
+	if readErr != nil {
+		return written, readErr
+	}
+	return written, sinkErr
+}
@@ -0,0 +1,131 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// onlyReader hides WriterTo/ReaderFrom so io.Copy cannot bypass its buffer
+type onlyReader struct{ io.Reader }
+
+type failingWriter struct{ after int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.after <= 0 {
+		return 0, errors.New("disk full")
+	}
+	w.after--
+	return len(p), nil
+}
+
+func TestPipelineCopy(t *testing.T) {
+	data := make([]byte, 5*4096+123)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("Failed to generate data: %v", err)
+	}
+
+	pool := newBufferPool(4096)
+	var dest bytes.Buffer
+	hash := sha256.New()
+
+	n, err := pipelineCopy(pool, onlyReader{bytes.NewReader(data)}, &dest, hash)
+	if err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("Expected %d bytes copied, got %d", len(data), n)
+	}
+	if !bytes.Equal(dest.Bytes(), data) {
+		t.Errorf("Destination content does not match source")
+	}
+
+	expected := sha256.Sum256(data)
+	if !bytes.Equal(hash.Sum(nil), expected[:]) {
+		t.Errorf("Hash computed during copy does not match source")
+	}
+}
+
+func TestPipelineCopy_SinkError(t *testing.T) {
+	data := make([]byte, 64*1024)
+	pool := newBufferPool(1024)
+
+	_, err := pipelineCopy(pool, onlyReader{bytes.NewReader(data)}, &failingWriter{after: 3}, io.Discard)
+	if err == nil || err.Error() != "disk full" {
+		t.Errorf("Expected sink error to be returned, got %v", err)
+	}
+}
+
+func TestBufferPool_DefaultSize(t *testing.T) {
+	pool := newBufferPool(0)
+	buf := pool.Get()
+	if len(*buf) != defaultBufferSize {
+		t.Errorf("Expected default buffer size %d, got %d", defaultBufferSize, len(*buf))
+	}
+	pool.Put(buf)
+}
+
+// benchmarkSource writes a temporary source file used by the copy benchmarks
+func benchmarkSource(b *testing.B, size int) string {
+	b.Helper()
+
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		b.Fatalf("Failed to generate data: %v", err)
+	}
+
+	path := filepath.Join(b.TempDir(), "source.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		b.Fatalf("Failed to write source: %v", err)
+	}
+	return path
+}
+
+func benchmarkCopy(b *testing.B, copyFn func(dst io.Writer, src io.Reader, hash io.Writer) error) {
+	const size = 32 * 1024 * 1024
+	srcPath := benchmarkSource(b, size)
+	destPath := filepath.Join(b.TempDir(), "dest.bin")
+
+	b.SetBytes(size)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		src, err := os.Open(srcPath)
+		if err != nil {
+			b.Fatalf("Failed to open source: %v", err)
+		}
+		dst, err := os.Create(destPath)
+		if err != nil {
+			b.Fatalf("Failed to create destination: %v", err)
+		}
+
+		if err := copyFn(dst, onlyReader{src}, sha256.New()); err != nil {
+			b.Fatalf("Copy failed: %v", err)
+		}
+
+		src.Close()
+		dst.Close()
+	}
+}
+
+// BenchmarkCopy_IOCopy measures the previous io.Copy + MultiWriter path
+func BenchmarkCopy_IOCopy(b *testing.B) {
+	benchmarkCopy(b, func(dst io.Writer, src io.Reader, hash io.Writer) error {
+		_, err := io.Copy(io.MultiWriter(dst, hash), src)
+		return err
+	})
+}
+
+// BenchmarkCopy_Pipelined measures the pooled, pipelined copier
+func BenchmarkCopy_Pipelined(b *testing.B) {
+	pool := newBufferPool(defaultBufferSize)
+	benchmarkCopy(b, func(dst io.Writer, src io.Reader, hash io.Writer) error {
+		_, err := pipelineCopy(pool, src, dst, hash)
+		return err
+	})
+}
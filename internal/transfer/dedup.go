@@ -0,0 +1,229 @@
+package transfer
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/dedup"
+	"github.com/autofileingest/internal/storage"
+)
+
+// SkippedFile is a file left out of an ingest because its content had
+// already been ingested
+type SkippedFile struct {
+	SourcePath string
+	// Existing is the location of the earlier copy on the primary destination
+	Existing string
+	// Linked is set when the planned paths were hard linked to the earlier copies
+	Linked bool
+	// Size and Checksums describe the content, and Copies holds the
+	// linked paths when Linked is set
+	Size      int64
+	Checksums checksum.Digests
+	Copies    []DestinationCopy
+}
+
+// openIndex opens the dedup index for an ingest. Without it every file is
+// copied, so a broken index only costs time.
+func (m *Manager) openIndex(deviceName string) {
+	m.index = nil
+	m.contentKeys = map[string]contentKey{}
+	if !m.config.Dedup.Enabled {
+		return
+	}
+
+	index, err := dedup.Open(m.config.DedupIndexPath())
+	if err != nil {
+		m.logger.DeviceError(deviceName, "Failed to open dedup index, copying every file: %v", err)
+		return
+	}
+	m.index = index
+}
+
+// closeIndex closes the dedup index at the end of an ingest
+func (m *Manager) closeIndex() {
+	if m.index != nil {
+		m.index.Close()
+		m.index = nil
+	}
+}
+
+// contentKey holds the hashes of a source file computed during the
+// duplicate check, so they needn't be computed again when it's indexed
+type contentKey struct {
+	prehash string
+	digest  string
+}
+
+// skipDuplicates removes files whose content is already on every
+// destination. Candidates are found by size and prehash, then confirmed
+// with a full hash and by checking that the earlier copies still exist.
+func (m *Manager) skipDuplicates(deviceName string, transfers []FileTransfer) []FileTransfer {
+	if m.index == nil {
+		return transfers
+	}
+
+	remaining := transfers[:0]
+	for _, transfer := range transfers {
+		existing, ok := m.findDuplicate(deviceName, &transfer)
+		if !ok {
+			remaining = append(remaining, transfer)
+			continue
+		}
+
+		skipped := SkippedFile{
+			SourcePath: transfer.SourcePath,
+			Existing:   existing.Copies[0].Location,
+			Size:       transfer.Size,
+			Checksums:  checksum.Digests{dedup.Algorithm: existing.Digest},
+		}
+		if m.config.Dedup.Action == config.DedupHardlink {
+			skipped.Linked = m.linkDuplicate(deviceName, &transfer, existing)
+		}
+		if skipped.Linked {
+			skipped.Copies = transfer.Copies
+		}
+		if skipped.Linked {
+			m.logger.DeviceInfo(deviceName, "Linked duplicate %s to existing copy %s",
+				filepath.Base(transfer.SourcePath), skipped.Existing)
+		} else {
+			m.logger.DeviceInfo(deviceName, "Skipping %s, already ingested as %s",
+				filepath.Base(transfer.SourcePath), skipped.Existing)
+		}
+
+		m.stats.Skipped = append(m.stats.Skipped, skipped)
+		m.stats.SkippedFiles++
+		m.stats.TotalFiles--
+		m.stats.TotalBytes -= transfer.Size
+	}
+
+	if m.stats.SkippedFiles > 0 {
+		m.logger.DeviceInfo(deviceName, "Skipped %d files already ingested", m.stats.SkippedFiles)
+	}
+	return remaining
+}
+
+// findDuplicate returns the index entry of an earlier ingest of the same
+// content whose copies are still on every destination
+func (m *Manager) findDuplicate(deviceName string, transfer *FileTransfer) (dedup.Entry, bool) {
+	prehash, err := dedup.Prehash(transfer.SourcePath)
+	if err != nil {
+		m.logger.DeviceError(deviceName, "Failed to prehash %s: %v", transfer.SourcePath, err)
+		return dedup.Entry{}, false
+	}
+	m.contentKeys[transfer.SourcePath] = contentKey{prehash: prehash}
+
+	candidates := m.index.Lookup(transfer.Size, prehash)
+	if len(candidates) == 0 {
+		return dedup.Entry{}, false
+	}
+
+	digest, err := dedup.Digest(transfer.SourcePath)
+	if err != nil {
+		m.logger.DeviceError(deviceName, "Failed to hash %s: %v", transfer.SourcePath, err)
+		return dedup.Entry{}, false
+	}
+	m.contentKeys[transfer.SourcePath] = contentKey{prehash: prehash, digest: digest}
+
+	for _, entry := range candidates {
+		if entry.Digest == digest && m.copiesPresent(entry) {
+			return entry, true
+		}
+	}
+	return dedup.Entry{}, false
+}
+
+// copiesPresent reports whether an entry has an intact-looking copy on
+// every destination of this ingest
+func (m *Manager) copiesPresent(entry dedup.Entry) bool {
+	if len(entry.Copies) == 0 {
+		return false
+	}
+	for _, dest := range m.destinations {
+		c, ok := entryCopy(entry, dest.config.Name)
+		if !ok {
+			return false
+		}
+		info, err := dest.store.Stat(c.RelPath)
+		if err != nil || info.Size != entry.Size {
+			return false
+		}
+	}
+	return true
+}
+
+// entryCopy returns an entry's copy on the named destination
+func entryCopy(entry dedup.Entry, destination string) (dedup.Copy, bool) {
+	for _, c := range entry.Copies {
+		if c.Destination == destination {
+			return c, true
+		}
+	}
+	return dedup.Copy{}, false
+}
+
+// linkDuplicate hard links the planned path of every copy to the earlier
+// copy on the same destination. It reports false when any destination
+// can't link, leaving the duplicate skipped at its existing location.
+func (m *Manager) linkDuplicate(deviceName string, transfer *FileTransfer, existing dedup.Entry) bool {
+	for i, dest := range m.destinations {
+		c, _ := entryCopy(existing, dest.config.Name)
+		planned := transfer.Copies[i].RelPath
+		if c.RelPath == planned {
+			continue
+		}
+
+		linker, ok := dest.store.(storage.Linker)
+		if !ok {
+			m.logger.DeviceInfo(deviceName, "Destination %s can't hard link, skipping duplicate %s",
+				dest.config.Name, filepath.Base(transfer.SourcePath))
+			return false
+		}
+		if err := linker.Link(c.RelPath, planned); err != nil {
+			m.logger.DeviceError(deviceName, "Failed to link %s to %s: %v", transfer.Copies[i].Path, c.Location, err)
+			return false
+		}
+	}
+	return true
+}
+
+// indexTransfer records a successfully copied file in the dedup index
+func (m *Manager) indexTransfer(deviceName string, transfer *FileTransfer) {
+	if m.index == nil {
+		return
+	}
+
+	content := m.contentKeys[transfer.SourcePath]
+	var err error
+	if content.prehash == "" {
+		if content.prehash, err = dedup.Prehash(transfer.SourcePath); err != nil {
+			m.logger.DeviceError(deviceName, "Failed to index %s: %v", transfer.SourcePath, err)
+			return
+		}
+	}
+	if content.digest == "" {
+		content.digest = transfer.Checksums[dedup.Algorithm]
+	}
+	if content.digest == "" {
+		if content.digest, err = dedup.Digest(transfer.SourcePath); err != nil {
+			m.logger.DeviceError(deviceName, "Failed to index %s: %v", transfer.SourcePath, err)
+			return
+		}
+	}
+
+	entry := dedup.Entry{
+		Size:     transfer.Size,
+		Prehash:  content.prehash,
+		Digest:   content.digest,
+		Source:   transfer.SourcePath,
+		Ingested: time.Now(),
+	}
+	for _, c := range transfer.Copies {
+		entry.Copies = append(entry.Copies, dedup.Copy{Destination: c.Destination, RelPath: c.RelPath, Location: c.Path})
+	}
+	if err := m.index.Add(entry); err != nil {
+		m.logger.DeviceError(deviceName, "Failed to index %s: %v", transfer.SourcePath, err)
+	}
+}
@@ -0,0 +1,114 @@
+package transfer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/autofileingest/internal/config"
+)
+
+func newDedupManager(t *testing.T, destDir, indexPath, action string) *Manager {
+	t.Helper()
+	cfg := newTestConfig(t, destDir)
+	cfg.Dedup = config.DedupConfig{Enabled: true, Action: action, IndexPath: indexPath}
+	return newTestManager(t, cfg)
+}
+
+func TestTransferManager_SkipsIngestedContent(t *testing.T) {
+	destDir := t.TempDir()
+	indexPath := filepath.Join(t.TempDir(), "dedup-index.jsonl")
+	content := strings.Repeat("clip", 50000)
+
+	first := writeTestFile(t, filepath.Join(t.TempDir(), "Nike_Ad_ACam_001.mp4"), content)
+	mgr := newDedupManager(t, destDir, indexPath, config.DedupSkip)
+	if err := mgr.TransferFiles("card-1", []string{first}); err != nil {
+		t.Fatalf("First ingest failed: %v", err)
+	}
+
+	// The same clip under another name, plus a new one
+	srcDir := t.TempDir()
+	again := writeTestFile(t, filepath.Join(srcDir, "Nike_Ad_ACam_001_copy.mp4"), content)
+	fresh := writeTestFile(t, filepath.Join(srcDir, "Nike_Ad_ACam_002.mp4"), strings.Repeat("clap", 50000))
+
+	mgr = newDedupManager(t, destDir, indexPath, config.DedupSkip)
+	if err := mgr.TransferFiles("card-1", []string{again, fresh}); err != nil {
+		t.Fatalf("Second ingest failed: %v", err)
+	}
+
+	stats := mgr.GetStats()
+	if stats.SkippedFiles != 1 || len(stats.Skipped) != 1 {
+		t.Fatalf("Expected 1 skipped file, got %d (%+v)", stats.SkippedFiles, stats.Skipped)
+	}
+	existing := filepath.Join(destDir, "Ad", "Nike", "ACam", "001.mp4")
+	if stats.Skipped[0].SourcePath != again || stats.Skipped[0].Existing != existing {
+		t.Errorf("Expected %s to be skipped as %s, got %+v", again, existing, stats.Skipped[0])
+	}
+	if completed := mgr.GetCompletedTransfers(); len(completed) != 1 || completed[0].SourcePath != fresh {
+		t.Errorf("Expected only the new clip to be copied, got %d transfers", len(completed))
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "Ad", "Nike", "ACam", "001_copy.mp4")); !os.IsNotExist(err) {
+		t.Errorf("Expected no second copy of the duplicate, got %v", err)
+	}
+	if stats.TotalFiles != 1 || stats.ProcessedFiles != 1 {
+		t.Errorf("Expected the skipped file left out of the total, got %d/%d", stats.ProcessedFiles, stats.TotalFiles)
+	}
+	if stats.TotalBytes != int64(len("clap")*50000) {
+		t.Errorf("Expected skipped bytes left out of the total, got %d", stats.TotalBytes)
+	}
+}
+
+func TestTransferManager_CopiesWhenEarlierCopyIsGone(t *testing.T) {
+	destDir := t.TempDir()
+	indexPath := filepath.Join(t.TempDir(), "dedup-index.jsonl")
+	src := writeTestFile(t, filepath.Join(t.TempDir(), "Nike_Ad_ACam_001.mp4"), "clip data")
+
+	mgr := newDedupManager(t, destDir, indexPath, config.DedupSkip)
+	if err := mgr.TransferFiles("card-1", []string{src}); err != nil {
+		t.Fatalf("First ingest failed: %v", err)
+	}
+	os.Remove(filepath.Join(destDir, "Ad", "Nike", "ACam", "001.mp4"))
+
+	mgr = newDedupManager(t, destDir, indexPath, config.DedupSkip)
+	if err := mgr.TransferFiles("card-1", []string{src}); err != nil {
+		t.Fatalf("Second ingest failed: %v", err)
+	}
+	if stats := mgr.GetStats(); stats.SkippedFiles != 0 || stats.ProcessedFiles != 1 {
+		t.Errorf("Expected the missing copy to be ingested again, got %+v", stats)
+	}
+}
+
+func TestTransferManager_HardlinksDuplicates(t *testing.T) {
+	destDir := t.TempDir()
+	indexPath := filepath.Join(t.TempDir(), "dedup-index.jsonl")
+
+	first := writeTestFile(t, filepath.Join(t.TempDir(), "Nike_Ad_ACam_001.mp4"), "clip data")
+	mgr := newDedupManager(t, destDir, indexPath, config.DedupHardlink)
+	if err := mgr.TransferFiles("card-1", []string{first}); err != nil {
+		t.Fatalf("First ingest failed: %v", err)
+	}
+
+	again := writeTestFile(t, filepath.Join(t.TempDir(), "Puma_Promo_BCam_007.mp4"), "clip data")
+	mgr = newDedupManager(t, destDir, indexPath, config.DedupHardlink)
+	if err := mgr.TransferFiles("card-2", []string{again}); err != nil {
+		t.Fatalf("Second ingest failed: %v", err)
+	}
+
+	stats := mgr.GetStats()
+	if len(stats.Skipped) != 1 || !stats.Skipped[0].Linked {
+		t.Fatalf("Expected a linked duplicate, got %+v", stats.Skipped)
+	}
+
+	original, err := os.Stat(filepath.Join(destDir, "Ad", "Nike", "ACam", "001.mp4"))
+	if err != nil {
+		t.Fatalf("Original copy missing: %v", err)
+	}
+	linked, err := os.Stat(filepath.Join(destDir, "Promo", "Puma", "BCam", "007.mp4"))
+	if err != nil {
+		t.Fatalf("Expected a link at the planned path: %v", err)
+	}
+	if !os.SameFile(original, linked) {
+		t.Error("Expected the planned path to be a hard link to the earlier copy")
+	}
+}
@@ -0,0 +1,64 @@
+// +build linux
+
+package transfer
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsIocFiemap is FS_IOC_FIEMAP, _IOWR('f', 11, struct fiemap)
+const fsIocFiemap = 0xC020660B
+
+// fiemapFlagSync flushes delayed allocations so new files have extents
+const fiemapFlagSync = 0x1
+
+// fiemapExtent mirrors struct fiemap_extent
+type fiemapExtent struct {
+	logical    uint64
+	physical   uint64
+	length     uint64
+	reserved64 [2]uint64
+	flags      uint32
+	reserved   [3]uint32
+}
+
+// fiemapRequest mirrors struct fiemap with room for a single extent
+type fiemapRequest struct {
+	start         uint64
+	length        uint64
+	flags         uint32
+	mappedExtents uint32
+	extentCount   uint32
+	reserved      uint32
+	extent        fiemapExtent
+}
+
+// physicalOrderSupported reports whether physicalOffset can work on this platform
+const physicalOrderSupported = true
+
+// physicalOffset returns the physical offset of the first extent of a file
+// on its device. ok is false for files without extents, such as empty ones.
+func physicalOffset(path string) (offset uint64, ok bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	req := fiemapRequest{
+		length:      ^uint64(0),
+		flags:       fiemapFlagSync,
+		extentCount: 1,
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(&req)))
+	if errno != 0 {
+		return 0, false, errno
+	}
+	if req.mappedExtents == 0 {
+		return 0, false, nil
+	}
+	return req.extent.physical, true, nil
+}
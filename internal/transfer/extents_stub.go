@@ -0,0 +1,13 @@
+// +build !linux
+
+package transfer
+
+import "errors"
+
+// physicalOrderSupported reports whether physicalOffset can work on this platform
+const physicalOrderSupported = false
+
+// physicalOffset stub for non-Linux platforms
+func physicalOffset(path string) (uint64, bool, error) {
+	return 0, false, errors.New("file extents not available on this platform")
+}
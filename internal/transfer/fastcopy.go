@@ -0,0 +1,83 @@
+// +build linux
+
+package transfer
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// kernelCopyChunk caps a single copy_file_range/sendfile call, small
+// enough that progress is counted between adaptive worker samples
+const kernelCopyChunk = 64 << 20
+
+// kernelCopySupported reports whether kernelCopy can be used on this platform
+const kernelCopySupported = true
+
+// kernelCopy copies size bytes from src to dst inside the kernel using
+// copy_file_range, falling back to sendfile when the filesystems involved
+// don't support it (e.g. cross-device copies on older kernels). Bytes are
+// added to copied as each chunk completes.
+func kernelCopy(dst, src *os.File, size int64, copied *int64) (int64, error) {
+	var written int64
+	useSendfile := false
+
+	for written < size {
+		chunk := size - written
+		if chunk > kernelCopyChunk {
+			chunk = kernelCopyChunk
+		}
+
+		var n int
+		var err error
+		if useSendfile {
+			n, err = unix.Sendfile(int(dst.Fd()), int(src.Fd()), nil, int(chunk))
+		} else {
+			n, err = unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, int(chunk), 0)
+			if err != nil && written == 0 && copyFileRangeUnsupported(err) {
+				useSendfile = true
+				continue
+			}
+		}
+
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			// Source shrank underneath us
+			return written, io.ErrUnexpectedEOF
+		}
+		written += int64(n)
+		atomic.AddInt64(copied, int64(n))
+	}
+
+	return written, nil
+}
+
+// copyFileRangeUnsupported reports errors that mean copy_file_range can't be
+// used for this pair of files at all, rather than a genuine I/O failure
+func copyFileRangeUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOSYS) ||
+		errors.Is(err, unix.EXDEV) ||
+		errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.EOPNOTSUPP)
+}
+
+// adviseSequential tells the kernel a file will be read front to back so it
+// can read ahead aggressively
+func adviseSequential(f *os.File) {
+	unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_SEQUENTIAL)
+}
+
+// adviseDontNeed drops a file's pages from the page cache. Dirty pages can't
+// be dropped, so callers flush destination files before calling this.
+func adviseDontNeed(f *os.File) {
+	unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
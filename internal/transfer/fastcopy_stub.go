@@ -0,0 +1,22 @@
+// +build !linux
+
+package transfer
+
+import (
+	"errors"
+	"os"
+)
+
+// kernelCopySupported reports whether kernelCopy can be used on this platform
+const kernelCopySupported = false
+
+// kernelCopy stub for non-Linux platforms
+func kernelCopy(dst, src *os.File, size int64, copied *int64) (int64, error) {
+	return 0, errors.New("kernel copy not available on this platform")
+}
+
+// adviseSequential stub for non-Linux platforms
+func adviseSequential(f *os.File) {}
+
+// adviseDontNeed stub for non-Linux platforms
+func adviseDontNeed(f *os.File) {}
@@ -0,0 +1,77 @@
+package transfer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKernelCopy(t *testing.T) {
+	if !kernelCopySupported {
+		t.Skip("kernel copy not available on this platform")
+	}
+
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("kernel copy "), 100000)
+
+	srcPath := filepath.Join(dir, "src.bin")
+	if err := os.WriteFile(srcPath, content, 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		t.Fatalf("Failed to open source: %v", err)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(filepath.Join(dir, "dst.bin"))
+	if err != nil {
+		t.Fatalf("Failed to create destination: %v", err)
+	}
+	defer dst.Close()
+
+	var copied int64
+	n, err := kernelCopy(dst, src, int64(len(content)), &copied)
+	if err != nil {
+		t.Fatalf("Kernel copy failed: %v", err)
+	}
+	if n != int64(len(content)) || copied != n {
+		t.Errorf("Expected %d bytes copied and counted, got %d and %d", len(content), n, copied)
+	}
+
+	got, err := os.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatalf("Failed to read destination: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Destination content does not match source")
+	}
+}
+
+func TestTransferManager_FastCopy(t *testing.T) {
+	destDir := t.TempDir()
+	testFile := writeTestFile(t, filepath.Join(t.TempDir(), "Fast_Client_ACam_001.mp4"), "fast copy content")
+
+	cfg := newTestConfig(t, destDir)
+	cfg.Transfer.FastCopy = true
+	cfg.Transfer.CacheHints = true
+
+	mgr := newTestManager(t, cfg)
+	if err := mgr.TransferFiles("test-device", []string{testFile}); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	if stats := mgr.GetStats(); stats.FailedFiles != 0 {
+		t.Errorf("Expected 0 failed files, got %d", stats.FailedFiles)
+	}
+
+	got, err := os.ReadFile(filepath.Join(destDir, "Client", "Fast", "ACam", "001.mp4"))
+	if err != nil {
+		t.Fatalf("Failed to read destination file: %v", err)
+	}
+	if string(got) != "fast copy content" {
+		t.Errorf("Content mismatch: got %s", got)
+	}
+}
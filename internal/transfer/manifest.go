@@ -0,0 +1,200 @@
+package transfer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/autofileingest/internal/mhl"
+	"github.com/autofileingest/internal/storage"
+)
+
+// writeManifests records the files of this ingest in an MHL at the root of
+// every destination. Failures are logged but don't fail the ingest, since
+// the files themselves were copied and verified.
+func (m *Manager) writeManifests(deviceName string) {
+	m.statsMu.RLock()
+	processed := make([]FileTransfer, len(m.processed))
+	copy(processed, m.processed)
+	m.statsMu.RUnlock()
+
+	for _, dest := range m.destinations {
+		local, ok := dest.store.(*storage.Local)
+		if !ok {
+			m.logger.DeviceInfo(deviceName, "Skipping MHL for %s: only local destinations hold an MHL history", dest.config.Name)
+			continue
+		}
+		m.writeManifest(deviceName, local, processed)
+	}
+}
+
+// writeManifest writes the MHL of one destination from the copies that
+// were verified there
+func (m *Manager) writeManifest(deviceName string, dest *storage.Local, processed []FileTransfer) {
+	manifest := &mhl.Manifest{
+		Creator:  m.mhlCreator(),
+		Started:  m.stats.StartTime,
+		Finished: time.Now(),
+	}
+
+	for _, transfer := range processed {
+		if len(transfer.Checksums) == 0 {
+			continue
+		}
+
+		for _, c := range transfer.Copies {
+			if c.Destination != dest.Name() || c.Err != nil {
+				continue
+			}
+
+			info, err := dest.Stat(c.RelPath)
+			if err != nil {
+				m.logger.DeviceError(deviceName, "Failed to add %s to MHL: %v", c.Path, err)
+				continue
+			}
+
+			manifest.Entries = append(manifest.Entries, mhl.Entry{
+				Path:     c.RelPath,
+				Size:     info.Size,
+				ModTime:  info.ModTime,
+				HashDate: transfer.Completed,
+				Digests:  transfer.Checksums,
+			})
+		}
+	}
+
+	if len(manifest.Entries) == 0 {
+		return
+	}
+
+	path, err := mhl.Write(m.config.MHL.Format, dest.Root(), manifest)
+	if err != nil {
+		m.logger.DeviceError(deviceName, "Failed to write MHL for %s: %v", dest.Name(), err)
+		return
+	}
+
+	m.logger.DeviceSuccess(deviceName, "Wrote MHL with %d files: %s", len(manifest.Entries), path)
+}
+
+// errSourceCorruption marks a file whose source no longer matches the hash
+// recorded on the media; the copy itself is faithful to what was read
+var errSourceCorruption = errors.New("source does not match recorded hash")
+
+// sourceCheck is a file verified against a manifest found on the source
+type sourceCheck struct {
+	historyRoot string
+	entry       mhl.Entry
+}
+
+// LoadSourceManifests reads MHL histories, MHL files and checksum sidecars
+// from the source media so transfers can be verified against them
+func (m *Manager) LoadSourceManifests(deviceName, root string) {
+	ref, err := mhl.LoadReference(root)
+	if err != nil {
+		m.logger.DeviceError(deviceName, "Failed to read source manifests: %v", err)
+		return
+	}
+
+	for _, skipped := range ref.Skipped {
+		m.logger.DeviceError(deviceName, "Skipped unreadable source manifest: %v", skipped)
+	}
+
+	m.reference = ref
+	if ref.Len() > 0 {
+		m.logger.DeviceInfo(deviceName, "Found %d source hashes in %d manifests (%s)",
+			ref.Len(), len(ref.Manifests), strings.Join(ref.Algorithms(), ", "))
+	}
+}
+
+// checkSourceManifest compares the digests read from the source with those
+// recorded on the media. A mismatch there, after the copy itself verified,
+// means the source changed since it was hashed rather than the copy failing.
+func (m *Manager) checkSourceManifest(deviceName string, transfer *FileTransfer, srcFile *os.File, expected *mhl.Expected) error {
+	compared, equal := transfer.Checksums.Match(expected.Digests)
+	if !compared {
+		m.logger.DeviceInfo(deviceName, "No common hash algorithm to verify %s against %s",
+			filepath.Base(transfer.SourcePath), expected.Manifest)
+		return nil
+	}
+
+	if expected.HistoryRoot != "" {
+		if info, err := srcFile.Stat(); err == nil {
+			relPath, _ := filepath.Rel(expected.HistoryRoot, transfer.SourcePath)
+			action := mhl.ActionVerified
+			if !equal {
+				action = mhl.ActionFailed
+			}
+
+			m.statsMu.Lock()
+			m.sourceChecks = append(m.sourceChecks, sourceCheck{
+				historyRoot: expected.HistoryRoot,
+				entry: mhl.Entry{
+					Path:     relPath,
+					Size:     info.Size(),
+					ModTime:  info.ModTime(),
+					HashDate: time.Now(),
+					Digests:  transfer.Checksums,
+					Action:   action,
+				},
+			})
+			m.statsMu.Unlock()
+		}
+	}
+
+	m.statsMu.Lock()
+	if equal {
+		m.stats.ManifestVerified++
+	} else {
+		m.stats.SourceMismatches++
+	}
+	m.statsMu.Unlock()
+
+	if !equal {
+		m.logger.DeviceError(deviceName, "Source corruption: %s does not match %s (expected %s, read %s); copy kept at %s",
+			transfer.SourcePath, filepath.Base(expected.Manifest), expected.Digests, transfer.Checksums, transfer.DestinationPath)
+		return errSourceCorruption
+	}
+
+	m.logger.DeviceInfo(deviceName, "Verified %s against %s", filepath.Base(transfer.SourcePath), filepath.Base(expected.Manifest))
+	return nil
+}
+
+// extendSourceHistories appends an in-place generation to every ascmhl
+// history found on the source, recording which files were verified, so the
+// existing chain is continued rather than replaced
+func (m *Manager) extendSourceHistories(deviceName string) {
+	byRoot := map[string][]mhl.Entry{}
+	for _, check := range m.sourceChecks {
+		byRoot[check.historyRoot] = append(byRoot[check.historyRoot], check.entry)
+	}
+
+	for root, entries := range byRoot {
+		manifest := &mhl.Manifest{
+			Creator:  m.mhlCreator(),
+			Process:  mhl.ProcessInPlace,
+			Started:  m.stats.StartTime,
+			Finished: time.Now(),
+			Entries:  entries,
+		}
+
+		path, err := mhl.Write(mhl.FormatASC, root, manifest)
+		if err != nil {
+			m.logger.DeviceError(deviceName, "Failed to extend source MHL history in %s: %v", root, err)
+			continue
+		}
+		m.logger.DeviceSuccess(deviceName, "Extended source MHL history: %s", path)
+	}
+}
+
+// mhlCreator returns the creator info configured for manifests
+func (m *Manager) mhlCreator() mhl.Creator {
+	return mhl.Creator{
+		Name:     m.config.MHL.CreatorName,
+		Email:    m.config.MHL.CreatorEmail,
+		Role:     m.config.MHL.CreatorRole,
+		Location: m.config.MHL.Location,
+		Comment:  m.config.MHL.Comment,
+	}
+}
@@ -0,0 +1,37 @@
+package transfer
+
+// Notifier is told about problems that stop or hold an ingest
+type Notifier interface {
+	// NotifyLowSpace reports an ingest refused, queued or paused for lack
+	// of space
+	NotifyLowSpace(deviceName, event string, shortfalls []SpaceShortfall) error
+	// NotifyDestinationUnavailable reports an ingest refused because a
+	// destination failed its mount guard
+	NotifyDestinationUnavailable(deviceName, destination string, reason error) error
+}
+
+// SetNotifier sets who is told about problems during an ingest
+func (m *Manager) SetNotifier(n Notifier) {
+	m.notifier = n
+}
+
+// notifyLowSpace passes a low-space event to the notifier, if any
+func (m *Manager) notifyLowSpace(deviceName, event string, shortfalls []SpaceShortfall) {
+	if m.notifier == nil {
+		return
+	}
+	if err := m.notifier.NotifyLowSpace(deviceName, event, shortfalls); err != nil {
+		m.logger.DeviceError(deviceName, "Failed to send low space notification: %v", err)
+	}
+}
+
+// notifyDestinationUnavailable passes a failed destination check to the
+// notifier, if any
+func (m *Manager) notifyDestinationUnavailable(deviceName, destination string, reason error) {
+	if m.notifier == nil {
+		return
+	}
+	if err := m.notifier.NotifyDestinationUnavailable(deviceName, destination, reason); err != nil {
+		m.logger.DeviceError(deviceName, "Failed to send destination notification: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package transfer
+
+import (
+	"sort"
+
+	"github.com/autofileingest/internal/config"
+)
+
+// orderJobs sorts files into the configured read order, with the segments
+// of each spanned clip together. The job queue keeps this order among files
+// of equal priority.
+func (m *Manager) orderJobs(deviceName string, transfers []FileTransfer) {
+	switch m.config.Transfer.ReadOrder {
+	case config.ReadOrderPath:
+		sortByPath(transfers)
+	case config.ReadOrderPhysical:
+		if err := sortPhysical(transfers, physicalOffset); err != nil {
+			m.logger.DeviceInfo(deviceName, "Physical read order unavailable, using path order: %v", err)
+			sortByPath(transfers)
+		}
+	}
+	groupSegments(transfers)
+}
+
+// sortByPath sorts files by source path
+func sortByPath(transfers []FileTransfer) {
+	sort.SliceStable(transfers, func(i, j int) bool {
+		return transfers[i].SourcePath < transfers[j].SourcePath
+	})
+}
+
+// sortPhysical sorts files by the physical offset of their first extent so
+// a spinning disk reads them in one sweep. Files without extents follow in
+// path order. An error means the source filesystem can't report extents
+// and transfers is left untouched.
+func sortPhysical(transfers []FileTransfer, offsetOf func(string) (uint64, bool, error)) error {
+	type placed struct {
+		offset uint64
+		mapped bool
+	}
+	offsets := make(map[string]placed, len(transfers))
+	for _, t := range transfers {
+		offset, ok, err := offsetOf(t.SourcePath)
+		if err != nil {
+			return err
+		}
+		offsets[t.SourcePath] = placed{offset: offset, mapped: ok}
+	}
+
+	sort.SliceStable(transfers, func(i, j int) bool {
+		a, b := offsets[transfers[i].SourcePath], offsets[transfers[j].SourcePath]
+		if a.mapped != b.mapped {
+			return a.mapped
+		}
+		if a.mapped && a.offset != b.offset {
+			return a.offset < b.offset
+		}
+		return transfers[i].SourcePath < transfers[j].SourcePath
+	})
+	return nil
+}
@@ -0,0 +1,99 @@
+package transfer
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/autofileingest/internal/config"
+)
+
+func sourcePaths(transfers []FileTransfer) string {
+	paths := make([]string, len(transfers))
+	for i, t := range transfers {
+		paths[i] = t.SourcePath
+	}
+	return strings.Join(paths, " ")
+}
+
+func TestSortPhysical(t *testing.T) {
+	transfers := []FileTransfer{
+		{SourcePath: "/card/a.mov"},
+		{SourcePath: "/card/b.mov"},
+		{SourcePath: "/card/empty.txt"},
+		{SourcePath: "/card/c.mov"},
+		{SourcePath: "/card/aa-empty.txt"},
+	}
+	offsets := map[string]uint64{
+		"/card/a.mov": 9000,
+		"/card/b.mov": 100,
+		"/card/c.mov": 4000,
+	}
+
+	err := sortPhysical(transfers, func(path string) (uint64, bool, error) {
+		offset, ok := offsets[path]
+		return offset, ok, nil
+	})
+	if err != nil {
+		t.Fatalf("sortPhysical failed: %v", err)
+	}
+
+	want := "/card/b.mov /card/c.mov /card/a.mov /card/aa-empty.txt /card/empty.txt"
+	if got := sourcePaths(transfers); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestSortPhysical_Unsupported(t *testing.T) {
+	transfers := []FileTransfer{{SourcePath: "/card/b.mov"}, {SourcePath: "/card/a.mov"}}
+	err := sortPhysical(transfers, func(string) (uint64, bool, error) {
+		return 0, false, errors.New("inappropriate ioctl for device")
+	})
+	if err == nil {
+		t.Fatal("Expected an error when extents are unsupported")
+	}
+	if got := sourcePaths(transfers); got != "/card/b.mov /card/a.mov" {
+		t.Errorf("Expected the order to be left alone, got %s", got)
+	}
+}
+
+func TestPhysicalOffset(t *testing.T) {
+	if !physicalOrderSupported {
+		t.Skip("file extents are only available on Linux")
+	}
+
+	path := writeTestFile(t, filepath.Join(t.TempDir(), "clip.mov"), strings.Repeat("x", 65536))
+	if _, ok, err := physicalOffset(path); err != nil {
+		t.Skipf("filesystem doesn't report extents: %v", err)
+	} else if !ok {
+		t.Error("Expected a written file to have an extent")
+	}
+}
+
+func TestTransferManager_PhysicalOrderKeepsPriority(t *testing.T) {
+	destDir := t.TempDir()
+	srcDir := t.TempDir()
+	files := []string{
+		writeTestFile(t, filepath.Join(srcDir, "Order_Client_BCam_002.mp4"), "b2"),
+		writeTestFile(t, filepath.Join(srcDir, "Order_Client_ACam_001.mp4"), "a1"),
+		writeTestFile(t, filepath.Join(srcDir, "1_Order_Client_CCam_003.mp4"), "c3"),
+	}
+
+	cfg := newTestConfig(t, destDir)
+	cfg.Transfer.ReadOrder = config.ReadOrderPhysical
+	cfg.Transfer.PriorityPrefixes = []string{"1_"}
+
+	mgr := newTestManager(t, cfg)
+	if err := mgr.TransferFiles("test-device", files); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	completed := mgr.GetCompletedTransfers()
+	if len(completed) != 3 {
+		t.Fatalf("Expected 3 completed transfers, got %d", len(completed))
+	}
+	if filepath.Base(completed[0].SourcePath) != "1_Order_Client_CCam_003.mp4" {
+		t.Errorf("Expected the priority file first, got %s", completed[0].SourcePath)
+	}
+}
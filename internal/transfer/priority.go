@@ -0,0 +1,173 @@
+package transfer
+
+import (
+	"container/heap"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/parser"
+)
+
+// PrefixPriority is the priority of files matching priority_prefixes
+const PrefixPriority = 100
+
+// mediaExtensions classifies files for priority_rules media_type
+var mediaExtensions = map[string]string{
+	".mp4": "video", ".mov": "video", ".mxf": "video", ".avi": "video",
+	".mts": "video", ".m2ts": "video", ".mkv": "video", ".insv": "video",
+	".wav": "audio", ".bwf": "audio", ".mp3": "audio", ".aac": "audio",
+	".m4a": "audio", ".aif": "audio", ".aiff": "audio", ".flac": "audio",
+	".jpg": "image", ".jpeg": "image", ".png": "image", ".tif": "image",
+	".tiff": "image", ".heic": "image",
+	".r3d": "raw", ".braw": "raw", ".ari": "raw", ".crm": "raw",
+	".cr2": "raw", ".cr3": "raw", ".nef": "raw", ".arw": "raw",
+	".dng": "raw", ".raf": "raw", ".rw2": "raw", ".orf": "raw",
+	".lrv": "proxy", ".lrf": "proxy",
+	".xml": "sidecar", ".xmp": "sidecar", ".thm": "sidecar", ".srt": "sidecar",
+	".ale": "sidecar", ".cdl": "sidecar", ".rmd": "sidecar", ".bim": "sidecar",
+}
+
+// MediaType classifies a file by extension. Video in a Proxy folder or
+// with a _Proxy suffix is a proxy.
+func MediaType(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	kind := mediaExtensions[ext]
+	if kind == "video" {
+		dir := strings.ToLower(filepath.Base(filepath.Dir(path)))
+		stem := strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		if dir == "proxy" || dir == "proxies" || strings.HasSuffix(stem, "_proxy") {
+			return "proxy"
+		}
+	}
+	return kind
+}
+
+// priorityRule is a priority rule with its regex compiled
+type priorityRule struct {
+	config.PriorityRuleConfig
+	regex *regexp.Regexp
+}
+
+// compilePriorityRules compiles the configured rules. Rules with an
+// invalid regex are dropped; Validate reports them.
+func compilePriorityRules(rules []config.PriorityRuleConfig) []priorityRule {
+	compiled := make([]priorityRule, 0, len(rules))
+	for _, rule := range rules {
+		r := priorityRule{PriorityRuleConfig: rule}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				continue
+			}
+			r.regex = re
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled
+}
+
+// matches reports whether a file meets every condition of the rule
+func (r priorityRule) matches(path string, size int64, info *parser.FileInfo) bool {
+	name := filepath.Base(path)
+	if len(r.Extensions) > 0 {
+		ext := filepath.Ext(name)
+		found := false
+		for _, e := range r.Extensions {
+			if strings.EqualFold("."+strings.TrimPrefix(e, "."), ext) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Glob != "" {
+		if ok, _ := filepath.Match(r.Glob, name); !ok {
+			return false
+		}
+	}
+	if r.regex != nil && !r.regex.MatchString(path) {
+		return false
+	}
+	if size < r.MinSize || (r.MaxSize > 0 && size > r.MaxSize) {
+		return false
+	}
+	if r.MediaType != "" && !strings.EqualFold(r.MediaType, MediaType(path)) {
+		return false
+	}
+	if r.Camera != "" && (info == nil || !strings.EqualFold(r.Camera, info.Camera)) {
+		return false
+	}
+	return true
+}
+
+// filePriority returns the priority of a file: PrefixPriority for
+// priority_prefixes, else the first matching rule, else 0
+func (m *Manager) filePriority(rules []priorityRule, path string, size int64, info *parser.FileInfo) int {
+	if m.isPriorityFile(filepath.Base(path)) {
+		return PrefixPriority
+	}
+	for _, rule := range rules {
+		if rule.matches(path, size, info) {
+			return rule.Priority
+		}
+	}
+	return 0
+}
+
+// queuedTransfer is a file waiting in the job queue
+type queuedTransfer struct {
+	transfer FileTransfer
+	modTime  time.Time
+	seq      int
+}
+
+// jobQueue orders files by priority, then optionally newest first, then
+// in the order they were pushed
+type jobQueue struct {
+	items       []queuedTransfer
+	newestFirst bool
+	pushed      int
+}
+
+func (q *jobQueue) Len() int { return len(q.items) }
+
+func (q *jobQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if a.transfer.Priority != b.transfer.Priority {
+		return a.transfer.Priority > b.transfer.Priority
+	}
+	if q.newestFirst && !a.modTime.Equal(b.modTime) {
+		return a.modTime.After(b.modTime)
+	}
+	return a.seq < b.seq
+}
+
+func (q *jobQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *jobQueue) Push(x interface{}) { q.items = append(q.items, x.(queuedTransfer)) }
+
+func (q *jobQueue) Pop() interface{} {
+	last := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return last
+}
+
+// push adds a file to the queue
+func (q *jobQueue) push(transfer FileTransfer, modTime time.Time) {
+	heap.Push(q, queuedTransfer{transfer: transfer, modTime: modTime, seq: q.pushed})
+	q.pushed++
+}
+
+// drain returns the queued files in priority order and empties the queue
+func (q *jobQueue) drain() []FileTransfer {
+	ordered := make([]FileTransfer, 0, len(q.items))
+	for q.Len() > 0 {
+		ordered = append(ordered, heap.Pop(q).(queuedTransfer).transfer)
+	}
+	return ordered
+}
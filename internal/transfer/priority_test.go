@@ -0,0 +1,133 @@
+package transfer
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/autofileingest/internal/config"
+)
+
+func TestMediaType(t *testing.T) {
+	tests := map[string]string{
+		"/card/A001.R3D":       "raw",
+		"/card/GX010042.MP4":   "video",
+		"/card/GL010042.LRV":   "proxy",
+		"/card/Proxy/A001.mov": "proxy",
+		"/card/A001_Proxy.mp4": "proxy",
+		"/card/ZOOM0001.WAV":   "audio",
+		"/card/A001.xml":       "sidecar",
+		"/card/IMG_0001.JPG":   "image",
+		"/card/unknown.bin":    "",
+	}
+	for path, want := range tests {
+		if got := MediaType(path); got != want {
+			t.Errorf("MediaType(%s) = %q, expected %q", path, got, want)
+		}
+	}
+}
+
+func TestTransferManager_FilePriority(t *testing.T) {
+	cfg := newTestConfig(t, t.TempDir())
+	cfg.Transfer.PriorityPrefixes = []string{"1_"}
+	cfg.Transfer.PriorityRules = []config.PriorityRuleConfig{
+		{Priority: 50, MediaType: "audio"},
+		{Priority: 40, Extensions: []string{"lrv", ".LRF"}},
+		{Priority: 30, Camera: "BCam", MaxSize: 1000},
+		{Priority: 20, Glob: "Interview_*"},
+		{Priority: 10, Regex: `/DCIM/1\d\dGOPRO/`, MinSize: 100},
+		{Priority: -10, MediaType: "raw"},
+	}
+	mgr := newTestManager(t, cfg)
+	rules := compilePriorityRules(cfg.Transfer.PriorityRules)
+
+	tests := []struct {
+		path string
+		size int64
+		want int
+	}{
+		{"/card/1_Nike_Ad_ACam_001.R3D", 5000, PrefixPriority},
+		{"/card/ZOOM0001.WAV", 5000, 50},
+		{"/card/GL010042.lrv", 5000, 40},
+		{"/card/Nike_Ad_BCam_002.mp4", 500, 30},
+		{"/card/Nike_Ad_BCam_003.mp4", 5000, 0},
+		{"/card/Interview_01.mov", 5000, 20},
+		{"/card/DCIM/100GOPRO/GX010042.MP4", 5000, 10},
+		{"/card/DCIM/100GOPRO/GX010043.MP4", 50, 0},
+		{"/card/A001C002.R3D", 5000, -10},
+	}
+	for _, tt := range tests {
+		info := mgr.parser.Parse(tt.path)
+		if got := mgr.filePriority(rules, tt.path, tt.size, info); got != tt.want {
+			t.Errorf("Priority of %s (%d bytes) = %d, expected %d", tt.path, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestJobQueue(t *testing.T) {
+	now := time.Now()
+	push := func(q *jobQueue) {
+		q.push(FileTransfer{SourcePath: "old-raw", Priority: 0}, now.Add(-2*time.Hour))
+		q.push(FileTransfer{SourcePath: "new-raw", Priority: 0}, now)
+		q.push(FileTransfer{SourcePath: "audio", Priority: 50}, now.Add(-time.Hour))
+		q.push(FileTransfer{SourcePath: "old-proxy", Priority: 40}, now.Add(-time.Hour))
+		q.push(FileTransfer{SourcePath: "new-proxy", Priority: 40}, now)
+		q.push(FileTransfer{SourcePath: "sidecar", Priority: -5}, now)
+	}
+
+	q := &jobQueue{}
+	push(q)
+	want := "audio old-proxy new-proxy old-raw new-raw sidecar"
+	if got := sourcePaths(q.drain()); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	q = &jobQueue{newestFirst: true}
+	push(q)
+	want = "audio new-proxy old-proxy new-raw old-raw sidecar"
+	if got := sourcePaths(q.drain()); got != want {
+		t.Errorf("Expected newest first %s, got %s", want, got)
+	}
+}
+
+func TestTransferManager_PriorityRules(t *testing.T) {
+	srcDir := t.TempDir()
+	files := []string{
+		writeTestFile(t, filepath.Join(srcDir, "Nike_Ad_ACam_A001.R3D"), strings.Repeat("r", 4096)),
+		writeTestFile(t, filepath.Join(srcDir, "Nike_Ad_ACam_A001.xml"), "<clip/>"),
+		writeTestFile(t, filepath.Join(srcDir, "Nike_Ad_ACam_A001.lrv"), "proxy"),
+		writeTestFile(t, filepath.Join(srcDir, "Nike_Ad_ACam_A001.wav"), "audio"),
+	}
+
+	cfg := newTestConfig(t, t.TempDir())
+	cfg.Transfer.ReadOrder = config.ReadOrderPath
+	cfg.Transfer.PriorityRules = []config.PriorityRuleConfig{
+		{Priority: 20, MediaType: "audio"},
+		{Priority: 10, MediaType: "proxy"},
+		{Priority: -1, MediaType: "sidecar"},
+	}
+
+	mgr := newTestManager(t, cfg)
+	if err := mgr.TransferFiles("test-device", files); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	var order []string
+	for _, transfer := range mgr.GetCompletedTransfers() {
+		order = append(order, filepath.Ext(transfer.SourcePath))
+	}
+	if got := strings.Join(order, " "); got != ".wav .lrv .R3D .xml" {
+		t.Errorf("Expected audio, proxy, RAW then sidecar, got %s", got)
+	}
+}
+
+func TestCompilePriorityRules_DropsInvalidRegex(t *testing.T) {
+	rules := compilePriorityRules([]config.PriorityRuleConfig{
+		{Priority: 1, Regex: "("},
+		{Priority: 2, Regex: `\.wav$`},
+	})
+	if len(rules) != 1 || rules[0].Priority != 2 {
+		t.Errorf("Expected only the valid rule, got %+v", rules)
+	}
+}
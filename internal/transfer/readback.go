@@ -0,0 +1,47 @@
+// +build linux
+
+package transfer
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// directIOAlignment is the buffer and offset alignment required by O_DIRECT
+const directIOAlignment = 4096
+
+// openUncached opens a file for reading straight from storage. With direct
+// set it tries O_DIRECT first; filesystems that refuse it (tmpfs, some FUSE
+// mounts) fall back to a normal open whose cached pages are dropped.
+func openUncached(path string, direct bool) (*os.File, bool, error) {
+	if direct {
+		f, err := os.OpenFile(path, os.O_RDONLY|unix.O_DIRECT, 0)
+		if err == nil {
+			return f, true, nil
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	adviseDontNeed(f)
+	return f, false, nil
+}
+
+// alignedBuffer returns a buffer whose start and length satisfy O_DIRECT
+func alignedBuffer(size int) []byte {
+	if size < directIOAlignment {
+		size = directIOAlignment
+	}
+	size -= size % directIOAlignment
+
+	raw := make([]byte, size+directIOAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&raw[0])) % directIOAlignment); rem != 0 {
+		offset = directIOAlignment - rem
+	}
+	return raw[offset : offset+size]
+}
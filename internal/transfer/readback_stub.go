@@ -0,0 +1,20 @@
+// +build !linux
+
+package transfer
+
+import "os"
+
+// directIOAlignment is unused without O_DIRECT but kept for symmetry
+const directIOAlignment = 4096
+
+// openUncached stub for non-Linux platforms. There is no portable way to drop
+// cached pages, so the file is simply reopened.
+func openUncached(path string, direct bool) (*os.File, bool, error) {
+	f, err := os.Open(path)
+	return f, false, err
+}
+
+// alignedBuffer stub for non-Linux platforms
+func alignedBuffer(size int) []byte {
+	return make([]byte, size)
+}
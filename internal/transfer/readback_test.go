@@ -0,0 +1,58 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+)
+
+func TestAlignedBuffer(t *testing.T) {
+	if !kernelCopySupported {
+		t.Skip("direct I/O alignment only applies on Linux")
+	}
+
+	for _, size := range []int{1, 4096, 1048576, 1048577} {
+		buf := alignedBuffer(size)
+		if uintptr(unsafe.Pointer(&buf[0]))%directIOAlignment != 0 {
+			t.Errorf("Buffer for size %d is not aligned", size)
+		}
+		if len(buf)%directIOAlignment != 0 {
+			t.Errorf("Buffer length %d for size %d is not a multiple of %d", len(buf), size, directIOAlignment)
+		}
+	}
+}
+
+func TestRereadChecksum(t *testing.T) {
+	content := []byte("bytes that must really be on disk")
+	path := filepath.Join(t.TempDir(), "clip.mov")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	expected := fmt.Sprintf("%x", sha256.Sum256(content))
+
+	for _, direct := range []bool{false, true} {
+		mgr := &Manager{
+			config: &config.Config{
+				Transfer: config.TransferConfig{
+					VerifyMode:     config.VerifyModeReadback,
+					VerifyDirectIO: direct,
+				},
+			},
+			buffers: newBufferPool(4096),
+		}
+
+		digests, err := mgr.rereadDigests(path, []string{checksum.SHA256})
+		if err != nil {
+			t.Fatalf("Re-read failed (direct=%v): %v", direct, err)
+		}
+		if digests[checksum.SHA256] != expected {
+			t.Errorf("Expected checksum %s (direct=%v), got %s", expected, direct, digests[checksum.SHA256])
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/storage"
+)
+
+// ErrInsufficientSpace is returned when an ingest doesn't fit on its
+// destinations and transfer.when_full is refuse
+var ErrInsufficientSpace = errors.New("insufficient space on destination")
+
+// Low-space events passed to a Notifier
+const (
+	// SpaceRefused means the ingest was refused before copying anything
+	SpaceRefused = "refused"
+	// SpaceQueued means the ingest waits for space before it starts
+	SpaceQueued = "queued"
+	// SpacePaused means a running ingest waits for space
+	SpacePaused = "paused"
+)
+
+// SpaceShortfall describes a volume without room for the files headed to it
+type SpaceShortfall struct {
+	// Destinations lists the destinations stored on the volume
+	Destinations []string
+	// Required is the number of bytes still to be written to the volume
+	Required int64
+	// Available is the free space left above the configured reserve
+	Available int64
+}
+
+func (s SpaceShortfall) String() string {
+	return fmt.Sprintf("%s needs %d bytes, %d available",
+		strings.Join(s.Destinations, "+"), s.Required, s.Available)
+}
+
+// checkSpace returns the volumes that can't hold the bytes required by each
+// destination, in the order of m.destinations, on top of the bytes already
+// reserved by running copies. Destinations sharing a volume are counted
+// together. Backends that can't report free space are skipped. It runs
+// before the workers start or under spaceMu.
+func (m *Manager) checkSpace(deviceName string, required []int64) []SpaceShortfall {
+	volumes := map[string]*SpaceShortfall{}
+	var order []string
+
+	for i, dest := range m.destinations {
+		need := required[i] + atomic.LoadInt64(&m.reserved[i])
+		if need == 0 {
+			continue
+		}
+
+		reporter, ok := dest.store.(storage.SpaceReporter)
+		if !ok {
+			continue
+		}
+		space, err := reporter.Space()
+		if err != nil {
+			if !errors.Is(err, storage.ErrSpaceUnknown) {
+				m.logger.DeviceError(deviceName, "Failed to check free space on %s: %v", dest.config.Name, err)
+			} else if !m.spaceUnknown[dest.config.Name] {
+				if m.spaceUnknown == nil {
+					m.spaceUnknown = map[string]bool{}
+				}
+				m.spaceUnknown[dest.config.Name] = true
+				m.logger.DeviceInfo(deviceName, "Free space of %s can't be checked on this platform, space checks are off for it", dest.config.Name)
+			}
+			continue
+		}
+
+		v := volumes[space.Volume]
+		if v == nil {
+			v = &SpaceShortfall{Available: space.Free - m.config.Transfer.SpaceReserve}
+			volumes[space.Volume] = v
+			order = append(order, space.Volume)
+		}
+		v.Destinations = append(v.Destinations, dest.config.Name)
+		v.Required += need
+	}
+
+	var shortfalls []SpaceShortfall
+	for _, volume := range order {
+		if v := volumes[volume]; v.Required > v.Available {
+			shortfalls = append(shortfalls, *v)
+		}
+	}
+	return shortfalls
+}
+
+// preflightSpace checks that the whole ingest fits before anything is
+// copied. Ingests that don't fit are refused or queued until space is freed,
+// for at most spaceWait.
+func (m *Manager) preflightSpace(deviceName string) error {
+	required := make([]int64, len(m.destinations))
+	for i := range required {
+		required[i] = m.stats.TotalBytes
+	}
+
+	queued := false
+	deadline := time.Now().Add(m.spaceWait)
+	for {
+		shortfalls := m.checkSpace(deviceName, required)
+		if len(shortfalls) == 0 {
+			if queued {
+				m.logger.DeviceInfo(deviceName, "Enough space is available, starting queued ingest")
+			}
+			return nil
+		}
+
+		if m.config.Transfer.WhenFull != config.WhenFullQueue {
+			m.logShortfalls(deviceName, "Refusing ingest", shortfalls)
+			m.notifyLowSpace(deviceName, SpaceRefused, shortfalls)
+			return fmt.Errorf("%w: %s", ErrInsufficientSpace, describeShortfalls(shortfalls))
+		}
+
+		if !queued {
+			queued = true
+			m.logShortfalls(deviceName, "Ingest queued until space is freed", shortfalls)
+			m.notifyLowSpace(deviceName, SpaceQueued, shortfalls)
+		} else if time.Now().After(deadline) {
+			m.logShortfalls(deviceName, "Gave up waiting for space", shortfalls)
+			return fmt.Errorf("%w after waiting %v: %s", ErrInsufficientSpace, m.spaceWait, describeShortfalls(shortfalls))
+		}
+		time.Sleep(m.spaceCheckInterval)
+	}
+}
+
+// reserveSpace waits until every destination has room for the file, then
+// reserves it until releaseSpace. While one worker waits the others can't
+// reserve space either, so the whole ingest pauses. After waiting spaceWait
+// the file fails, and so do later files that don't fit.
+func (m *Manager) reserveSpace(deviceName string, transfer *FileTransfer) error {
+	m.spaceMu.Lock()
+	defer m.spaceMu.Unlock()
+
+	required := make([]int64, len(m.destinations))
+	for i := range required {
+		required[i] = transfer.Size
+	}
+
+	paused := false
+	deadline := time.Now().Add(m.spaceWait)
+	for {
+		shortfalls := m.checkSpace(deviceName, required)
+		if len(shortfalls) == 0 {
+			break
+		}
+		if m.spaceTimedOut || (paused && time.Now().After(deadline)) {
+			if !m.spaceTimedOut {
+				m.spaceTimedOut = true
+				m.logShortfalls(deviceName, "Gave up waiting for space, failing files that don't fit", shortfalls)
+			}
+			return fmt.Errorf("%w: %s", ErrInsufficientSpace, describeShortfalls(shortfalls))
+		}
+		if !paused {
+			paused = true
+			m.logShortfalls(deviceName, "Ingest paused, destination is running out of space", shortfalls)
+			m.notifyLowSpace(deviceName, SpacePaused, shortfalls)
+		}
+		time.Sleep(m.spaceCheckInterval)
+	}
+	if paused {
+		m.logger.DeviceInfo(deviceName, "Space is available again, resuming ingest")
+	}
+
+	for i := range m.destinations {
+		atomic.AddInt64(&m.reserved[i], transfer.Size)
+	}
+	return nil
+}
+
+// releaseSpace returns the space reserved for a file once it's written
+func (m *Manager) releaseSpace(transfer *FileTransfer) {
+	for i := range m.destinations {
+		atomic.AddInt64(&m.reserved[i], -transfer.Size)
+	}
+}
+
+// logShortfalls logs every volume that is short of space
+func (m *Manager) logShortfalls(deviceName, message string, shortfalls []SpaceShortfall) {
+	for _, s := range shortfalls {
+		m.logger.DeviceError(deviceName, "%s: %s", message, s)
+	}
+}
+
+// describeShortfalls joins shortfalls for an error message
+func describeShortfalls(shortfalls []SpaceShortfall) string {
+	parts := make([]string, len(shortfalls))
+	for i, s := range shortfalls {
+		parts[i] = s.String()
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,221 @@
+package transfer
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/storage"
+)
+
+// spaceDestination is a memDestination reporting free space from a function
+type spaceDestination struct {
+	*memDestination
+	volume string
+
+	mu    sync.Mutex
+	calls int
+	free  func(call int) int64
+}
+
+func (d *spaceDestination) Space() (storage.Space, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls++
+	return storage.Space{Free: d.free(d.calls), Volume: d.volume}, nil
+}
+
+func registerSpaceDestination(t *testing.T, kind, volume string, free func(call int) int64) *spaceDestination {
+	t.Helper()
+	dest := &spaceDestination{
+		memDestination: &memDestination{files: map[string][]byte{}},
+		volume:         volume,
+		free:           free,
+	}
+	storage.Register(kind, func(cfg config.DestinationConfig) (storage.Destination, error) {
+		dest.name = cfg.Name
+		return dest, nil
+	})
+	return dest
+}
+
+// testNotifier records notifications
+type testNotifier struct {
+	mu     sync.Mutex
+	events []string
+	names  []string
+}
+
+func (s *testNotifier) NotifyDestinationUnavailable(deviceName, destination string, reason error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, "unavailable")
+	s.names = append(s.names, destination)
+	return nil
+}
+
+func (s *testNotifier) NotifyLowSpace(deviceName, event string, shortfalls []SpaceShortfall) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	for _, sf := range shortfalls {
+		s.names = append(s.names, strings.Join(sf.Destinations, "+"))
+	}
+	return nil
+}
+
+func TestTransferManager_RefusesIngestWithoutSpace(t *testing.T) {
+	dest := registerSpaceDestination(t, "space-refuse", "vol1", func(int) int64 { return 1000 })
+	testFile := writeTestFile(t, filepath.Join(t.TempDir(), "Space_Client_ACam_001.mp4"), strings.Repeat("x", 100))
+
+	cfg := newTestConfig(t, "")
+	cfg.Transfer.SpaceReserve = 950
+	cfg.Destinations = []config.DestinationConfig{{Name: "raid", Type: "space-refuse", Path: "unused"}}
+
+	events := &testNotifier{}
+	mgr := newTestManager(t, cfg)
+	mgr.SetNotifier(events)
+
+	err := mgr.TransferFiles("test-device", []string{testFile})
+	if !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("Expected ErrInsufficientSpace, got %v", err)
+	}
+	if len(dest.files) != 0 {
+		t.Errorf("Expected nothing to be copied, got %v", dest.files)
+	}
+	if len(events.events) != 1 || events.events[0] != SpaceRefused {
+		t.Errorf("Expected a refused notification, got %v", events.events)
+	}
+}
+
+func TestTransferManager_SharedVolumeCountsMirrors(t *testing.T) {
+	registerSpaceDestination(t, "space-shared-a", "shared", func(int) int64 { return 150 })
+	registerSpaceDestination(t, "space-shared-b", "shared", func(int) int64 { return 150 })
+	testFile := writeTestFile(t, filepath.Join(t.TempDir(), "Space_Client_ACam_001.mp4"), strings.Repeat("x", 100))
+
+	cfg := newTestConfig(t, "")
+	cfg.Destinations = []config.DestinationConfig{
+		{Name: "raid", Type: "space-shared-a", Path: "unused"},
+		{Name: "mirror", Type: "space-shared-b", Path: "unused"},
+	}
+
+	events := &testNotifier{}
+	mgr := newTestManager(t, cfg)
+	mgr.SetNotifier(events)
+
+	// Each copy fits on its own but not both on the same volume
+	if err := mgr.TransferFiles("test-device", []string{testFile}); !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("Expected ErrInsufficientSpace, got %v", err)
+	}
+	if len(events.names) != 1 || events.names[0] != "raid+mirror" {
+		t.Errorf("Expected one shortfall for raid+mirror, got %v", events.names)
+	}
+}
+
+func TestTransferManager_QueuesIngestUntilSpaceIsFreed(t *testing.T) {
+	dest := registerSpaceDestination(t, "space-queue", "vol1", func(call int) int64 {
+		if call < 3 {
+			return 10
+		}
+		return 1 << 30
+	})
+	testFile := writeTestFile(t, filepath.Join(t.TempDir(), "Space_Client_ACam_001.mp4"), strings.Repeat("x", 100))
+
+	cfg := newTestConfig(t, "")
+	cfg.Transfer.WhenFull = config.WhenFullQueue
+	cfg.Destinations = []config.DestinationConfig{{Name: "raid", Type: "space-queue", Path: "unused"}}
+
+	events := &testNotifier{}
+	mgr := newTestManager(t, cfg)
+	mgr.SetNotifier(events)
+	mgr.spaceCheckInterval = time.Millisecond
+
+	if err := mgr.TransferFiles("test-device", []string{testFile}); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+	if len(dest.files) != 1 {
+		t.Errorf("Expected the queued file to be copied, got %d files", len(dest.files))
+	}
+	if len(events.events) != 1 || events.events[0] != SpaceQueued {
+		t.Errorf("Expected a single queued notification, got %v", events.events)
+	}
+}
+
+func TestTransferManager_PausesWhenSpaceRunsLow(t *testing.T) {
+	// The preflight passes, then space runs out before the second file
+	dest := registerSpaceDestination(t, "space-pause", "vol1", func(call int) int64 {
+		if call == 3 || call == 4 {
+			return 0
+		}
+		return 1 << 30
+	})
+	dir := t.TempDir()
+	files := []string{
+		writeTestFile(t, filepath.Join(dir, "Space_Client_ACam_001.mp4"), "first"),
+		writeTestFile(t, filepath.Join(dir, "Space_Client_ACam_002.mp4"), "second"),
+	}
+
+	cfg := newTestConfig(t, "")
+	cfg.Destinations = []config.DestinationConfig{{Name: "raid", Type: "space-pause", Path: "unused"}}
+
+	events := &testNotifier{}
+	mgr := newTestManager(t, cfg)
+	mgr.SetNotifier(events)
+	mgr.spaceCheckInterval = time.Millisecond
+
+	if err := mgr.TransferFiles("test-device", files); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+	if len(dest.files) != 2 {
+		t.Errorf("Expected both files after resuming, got %d", len(dest.files))
+	}
+	if len(events.events) != 1 || events.events[0] != SpacePaused {
+		t.Errorf("Expected a single paused notification, got %v", events.events)
+	}
+	if mgr.reserved[0] != 0 {
+		t.Errorf("Expected all reserved space to be released, got %d", mgr.reserved[0])
+	}
+}
+
+func TestTransferManager_FailsAfterWaitingForSpace(t *testing.T) {
+	// The preflight passes, then space never frees up
+	dest := registerSpaceDestination(t, "space-timeout", "vol1", func(call int) int64 {
+		if call == 1 {
+			return 1 << 30
+		}
+		return 0
+	})
+	dir := t.TempDir()
+	files := []string{
+		writeTestFile(t, filepath.Join(dir, "Space_Client_ACam_001.mp4"), "first"),
+		writeTestFile(t, filepath.Join(dir, "Space_Client_ACam_002.mp4"), "second"),
+	}
+
+	cfg := newTestConfig(t, "")
+	cfg.Destinations = []config.DestinationConfig{{Name: "raid", Type: "space-timeout", Path: "unused"}}
+
+	mgr := newTestManager(t, cfg)
+	mgr.spaceCheckInterval = time.Millisecond
+	mgr.spaceWait = 20 * time.Millisecond
+
+	start := time.Now()
+	if err := mgr.TransferFiles("test-device", files); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+	if stats := mgr.GetStats(); stats.FailedFiles != 2 {
+		t.Errorf("Expected both files to fail, got %d failed", stats.FailedFiles)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the wait to be bounded, took %v", elapsed)
+	}
+	if len(dest.files) != 0 {
+		t.Errorf("Expected nothing to be copied, got %d files", len(dest.files))
+	}
+	if mgr.reserved[0] != 0 {
+		t.Errorf("Expected no space to stay reserved, got %d", mgr.reserved[0])
+	}
+}
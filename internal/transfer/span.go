@@ -0,0 +1,139 @@
+package transfer
+
+import (
+	"sort"
+	"time"
+
+	"github.com/autofileingest/internal/parser"
+)
+
+// SpanGroup is a clip the camera split over several files, reported as
+// one asset
+type SpanGroup struct {
+	// Clip is the file name of the first segment
+	Clip string
+	// Segments are the source paths in order
+	Segments []string
+	Size     int64
+	// Copied counts the segments verified on every destination or already
+	// ingested earlier
+	Copied int
+}
+
+// Complete reports whether every segment of the clip is safely stored
+func (g SpanGroup) Complete() bool {
+	return g.Copied == len(g.Segments)
+}
+
+// parseFile parses a file, with the tokens of the first segment for the
+// segments of spanned clips
+func (m *Manager) parseFile(filePath string, spans map[string]parser.Span) *parser.FileInfo {
+	if span, ok := spans[filePath]; ok {
+		return m.parser.ParseSegment(filePath, span)
+	}
+	return m.parser.Parse(filePath)
+}
+
+// newSpanGroups returns the groups of spanned clips, by first segment
+func newSpanGroups(spans map[string]parser.Span) map[string]*SpanGroup {
+	groups := map[string]*SpanGroup{}
+	for path, span := range spans {
+		group, ok := groups[span.Lead]
+		if !ok {
+			group = &SpanGroup{Clip: span.Clip, Segments: make([]string, span.Parts)}
+			groups[span.Lead] = group
+		}
+		group.Segments[span.Part-1] = path
+	}
+	return groups
+}
+
+// alignSpans gives the segments of each clip the highest priority and
+// latest modification time among them, so the job queue can't separate
+// what groupSegments put together
+func alignSpans(transfers []FileTransfer, modTimes map[string]time.Time) {
+	priorities := map[string]int{}
+	latest := map[string]time.Time{}
+	for _, t := range transfers {
+		if t.FileInfo == nil || t.FileInfo.Span == nil {
+			continue
+		}
+		lead := t.FileInfo.Span.Lead
+		if p, ok := priorities[lead]; !ok || t.Priority > p {
+			priorities[lead] = t.Priority
+		}
+		if modTimes[t.SourcePath].After(latest[lead]) {
+			latest[lead] = modTimes[t.SourcePath]
+		}
+	}
+
+	for i, t := range transfers {
+		if t.FileInfo == nil || t.FileInfo.Span == nil {
+			continue
+		}
+		lead := t.FileInfo.Span.Lead
+		transfers[i].Priority = priorities[lead]
+		modTimes[t.SourcePath] = latest[lead]
+	}
+}
+
+// groupSegments moves the segments of each spanned clip next to each
+// other, in part order, where the first of them falls in the read order
+func groupSegments(transfers []FileTransfer) {
+	segments := map[string][]FileTransfer{}
+	for _, t := range transfers {
+		if t.FileInfo != nil && t.FileInfo.Span != nil {
+			lead := t.FileInfo.Span.Lead
+			segments[lead] = append(segments[lead], t)
+		}
+	}
+	if len(segments) == 0 {
+		return
+	}
+
+	ordered := make([]FileTransfer, 0, len(transfers))
+	for _, t := range transfers {
+		if t.FileInfo == nil || t.FileInfo.Span == nil {
+			ordered = append(ordered, t)
+			continue
+		}
+		group, ok := segments[t.FileInfo.Span.Lead]
+		if !ok {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool { return group[i].FileInfo.Span.Part < group[j].FileInfo.Span.Part })
+		ordered = append(ordered, group...)
+		delete(segments, t.FileInfo.Span.Lead)
+	}
+	copy(transfers, ordered)
+}
+
+// verifySpans checks that every segment of each spanned clip was stored
+// and records the clips in the stats
+func (m *Manager) verifySpans(deviceName string, groups map[string]*SpanGroup) {
+	stored := map[string]bool{}
+	for _, t := range m.processed {
+		if t.Succeeded() {
+			stored[t.SourcePath] = true
+		}
+	}
+	for _, skipped := range m.stats.Skipped {
+		stored[skipped.SourcePath] = true
+	}
+
+	for _, group := range groups {
+		for _, path := range group.Segments {
+			if stored[path] {
+				group.Copied++
+			}
+		}
+		if group.Complete() {
+			m.logger.DeviceInfo(deviceName, "Spanned clip %s: %d segments stored", group.Clip, len(group.Segments))
+		} else {
+			m.logger.DeviceError(deviceName, "Spanned clip %s is incomplete: %d of %d segments stored",
+				group.Clip, group.Copied, len(group.Segments))
+		}
+		m.stats.Spans = append(m.stats.Spans, *group)
+	}
+	sort.Slice(m.stats.Spans, func(i, j int) bool { return m.stats.Spans[i].Segments[0] < m.stats.Spans[j].Segments[0] })
+}
@@ -0,0 +1,86 @@
+package transfer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/parser"
+)
+
+func TestTransferManager_GroupsSpannedClips(t *testing.T) {
+	destDir := t.TempDir()
+	gopro := filepath.Join(t.TempDir(), "DCIM", "100GOPRO")
+	chapters := []string{
+		writeTestFile(t, filepath.Join(gopro, "GX010042.MP4"), strings.Repeat("a", 1000)),
+		writeTestFile(t, filepath.Join(gopro, "GX020042.MP4"), strings.Repeat("b", 1000)),
+		writeTestFile(t, filepath.Join(gopro, "GX030042.MP4"), strings.Repeat("c", 500)),
+	}
+	other := writeTestFile(t, filepath.Join(gopro, "GX010043.MP4"), "other")
+	// The card lost the middle chapter of another clip
+	broken := []string{
+		writeTestFile(t, filepath.Join(gopro, "GX010044.MP4"), "first"),
+		filepath.Join(gopro, "GX020044.MP4"),
+	}
+
+	cfg := newTestConfig(t, destDir)
+	cfg.Parsing.GroupSpans = true
+	// Only the last chapter matches, yet the whole clip is rushed
+	cfg.Transfer.PriorityRules = []config.PriorityRuleConfig{{Priority: 50, Glob: "GX03*"}}
+
+	mgr := newTestManager(t, cfg)
+	files := append(append([]string{other}, chapters...), broken...)
+	if err := mgr.TransferFiles("gopro", files); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	for _, transfer := range mgr.GetProcessedTransfers() {
+		isChapter := strings.Contains(transfer.SourcePath, "0042")
+		if isChapter != (transfer.Priority == 50) {
+			t.Errorf("Expected priority 50 only for the chapters of the rushed clip, %s has %d",
+				filepath.Base(transfer.SourcePath), transfer.Priority)
+		}
+	}
+	for _, chapter := range chapters {
+		if _, err := os.Stat(filepath.Join(destDir, "Unsorted", filepath.Base(chapter))); err != nil {
+			t.Errorf("Expected %s stored under its card name: %v", filepath.Base(chapter), err)
+		}
+	}
+
+	stats := mgr.GetStats()
+	if len(stats.Spans) != 2 {
+		t.Fatalf("Expected 2 spanned clips, got %+v", stats.Spans)
+	}
+	clip := stats.Spans[0]
+	if clip.Clip != "GX010042.MP4" || len(clip.Segments) != 3 || clip.Size != 2500 || !clip.Complete() {
+		t.Errorf("Expected GX010042.MP4 complete with 3 segments of 2500 bytes, got %+v", clip)
+	}
+	if incomplete := stats.Spans[1]; incomplete.Complete() || incomplete.Copied != 1 {
+		t.Errorf("Expected GX010044.MP4 incomplete with 1 segment stored, got %+v", incomplete)
+	}
+}
+
+func TestGroupSegments(t *testing.T) {
+	segment := func(path string, part int) FileTransfer {
+		span := &parser.Span{Lead: "/card/GX010042.MP4", Part: part, Parts: 3}
+		return FileTransfer{SourcePath: path, FileInfo: &parser.FileInfo{Span: span}}
+	}
+	// As read in physical order, with other files between the chapters
+	transfers := []FileTransfer{
+		{SourcePath: "/card/GX010041.MP4"},
+		segment("/card/GX020042.MP4", 2),
+		{SourcePath: "/card/GX010043.MP4"},
+		segment("/card/GX030042.MP4", 3),
+		segment("/card/GX010042.MP4", 1),
+		{SourcePath: "/card/GX010044.MP4"},
+	}
+
+	groupSegments(transfers)
+
+	want := "/card/GX010041.MP4 /card/GX010042.MP4 /card/GX020042.MP4 /card/GX030042.MP4 /card/GX010043.MP4 /card/GX010044.MP4"
+	if got := sourcePaths(transfers); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
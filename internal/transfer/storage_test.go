@@ -0,0 +1,238 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/autofileingest/internal/checksum"
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/storage"
+)
+
+// memDestination is an in-memory backend for testing pluggable destinations
+type memDestination struct {
+	name    string
+	mu      sync.Mutex
+	files   map[string][]byte
+	corrupt bool
+	// unreachable makes Exists fail like a dropped connection
+	unreachable bool
+}
+
+func (d *memDestination) Name() string                { return d.name }
+func (d *memDestination) Location(path string) string { return "mem://" + path }
+
+func (d *memDestination) Create(path string) (storage.Writer, error) {
+	return &memWriter{dest: d, path: path}, nil
+}
+
+func (d *memDestination) Stat(path string) (storage.FileInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	data, ok := d.files[path]
+	if !ok {
+		return storage.FileInfo{}, storage.ErrNotExist
+	}
+	return storage.FileInfo{Size: int64(len(data)), ModTime: time.Now()}, nil
+}
+
+func (d *memDestination) Exists(path string) (bool, error) {
+	if d.unreachable {
+		return false, errors.New("connection reset")
+	}
+	_, err := d.Stat(path)
+	return err == nil, nil
+}
+
+func (d *memDestination) Hash(path string, algorithms []string) (checksum.Digests, error) {
+	d.mu.Lock()
+	data := d.files[path]
+	d.mu.Unlock()
+	if d.corrupt {
+		data = append([]byte("x"), data...)
+	}
+	return checksum.Sum(bytes.NewReader(data), algorithms)
+}
+
+func (d *memDestination) Remove(path string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.files, path)
+	return nil
+}
+
+type memWriter struct {
+	dest *memDestination
+	path string
+	buf  bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+func (w *memWriter) Close() error                { return nil }
+
+func (w *memWriter) Commit() error {
+	w.dest.mu.Lock()
+	defer w.dest.mu.Unlock()
+	w.dest.files[w.path] = append([]byte(nil), w.buf.Bytes()...)
+	return nil
+}
+
+func registerMemDestination(t *testing.T, kind string, dest *memDestination) {
+	t.Helper()
+	storage.Register(kind, func(cfg config.DestinationConfig) (storage.Destination, error) {
+		dest.name = cfg.Name
+		return dest, nil
+	})
+}
+
+func TestTransferManager_PluggableDestination(t *testing.T) {
+	mem := &memDestination{files: map[string][]byte{}}
+	registerMemDestination(t, "memory-ok", mem)
+
+	localDir := t.TempDir()
+	testFile := writeTestFile(t, filepath.Join(t.TempDir(), "Plug_Client_ACam_001.mp4"), "plugged")
+
+	cfg := newTestConfig(t, "")
+	cfg.Transfer.FastCopy = true
+	cfg.Destinations = []config.DestinationConfig{
+		{Name: "raid", Path: localDir},
+		{Name: "memory", Type: "memory-ok", Path: "unused"},
+	}
+
+	mgr := newTestManager(t, cfg)
+	if err := mgr.TransferFiles("test-device", []string{testFile}); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	if got := string(mem.files["Client/Plug/ACam/001.mp4"]); got != "plugged" {
+		t.Errorf("Expected plugged destination to hold the file, got %q (files: %v)", got, mem.files)
+	}
+	if completed := mgr.GetCompletedTransfers(); len(completed) != 1 {
+		t.Fatalf("Expected 1 completed transfer, got %d", len(completed))
+	}
+	if ds := mgr.GetStats().Destinations["memory"]; ds.Files != 1 {
+		t.Errorf("Expected 1 file on memory destination, got %+v", ds)
+	}
+}
+
+func TestTransferManager_PluggableDestinationVerifies(t *testing.T) {
+	mem := &memDestination{files: map[string][]byte{}, corrupt: true}
+	registerMemDestination(t, "memory-corrupt", mem)
+
+	testFile := writeTestFile(t, filepath.Join(t.TempDir(), "Plug_Client_BCam_001.mp4"), "plugged")
+
+	cfg := newTestConfig(t, "")
+	cfg.Destinations = []config.DestinationConfig{
+		{Name: "memory", Type: "memory-corrupt", Path: "unused"},
+	}
+
+	mgr := newTestManager(t, cfg)
+	if err := mgr.TransferFiles("test-device", []string{testFile}); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	if stats := mgr.GetStats(); stats.FailedFiles != 1 {
+		t.Errorf("Expected the corrupt copy to fail verification, got %d failures", stats.FailedFiles)
+	}
+	if len(mem.files) != 0 {
+		t.Errorf("Expected the corrupt copy to be removed, got %v", mem.files)
+	}
+}
+
+func TestTransferManager_UnknownDestinationType(t *testing.T) {
+	cfg := newTestConfig(t, "")
+	cfg.Destinations = []config.DestinationConfig{{Name: "tape", Type: "tape", Path: "/dev/st0"}}
+
+	mgr := newTestManager(t, cfg)
+	if err := mgr.TransferFiles("test-device", nil); err == nil {
+		t.Error("Expected an error for an unknown destination type")
+	}
+}
+
+func TestTransferManager_RefusesUnmountedDestination(t *testing.T) {
+	destDir := t.TempDir()
+	testFile := writeTestFile(t, filepath.Join(t.TempDir(), "Guard_Client_ACam_001.mp4"), "guarded")
+
+	cfg := newTestConfig(t, destDir)
+	cfg.DestinationGuard = config.MountGuardConfig{Sentinel: ".media-raid"}
+
+	events := &testNotifier{}
+	mgr := newTestManager(t, cfg)
+	mgr.SetNotifier(events)
+
+	err := mgr.TransferFiles("test-device", []string{testFile})
+	if !errors.Is(err, storage.ErrNotMounted) {
+		t.Fatalf("Expected ErrNotMounted, got %v", err)
+	}
+	if entries, _ := ioutil.ReadDir(destDir); len(entries) != 0 {
+		t.Errorf("Expected nothing written to the unmounted destination, got %d entries", len(entries))
+	}
+	if len(events.events) != 1 || events.events[0] != "unavailable" || events.names[0] != "primary" {
+		t.Errorf("Expected an unavailable notification for primary, got %v %v", events.events, events.names)
+	}
+}
+
+func TestTransferManager_VersionsCollisionsOnDestination(t *testing.T) {
+	mem := &memDestination{files: map[string][]byte{"Client/Plug/ACam/001.mp4": []byte("earlier take")}}
+	registerMemDestination(t, "memory-collide", mem)
+
+	srcDir := t.TempDir()
+	first := writeTestFile(t, filepath.Join(srcDir, "a", "Plug_Client_ACam_001.mp4"), "second take")
+	// Another card's clip with the same name in the same ingest
+	second := writeTestFile(t, filepath.Join(srcDir, "b", "Plug_Client_ACam_001.mp4"), "third take")
+
+	cfg := newTestConfig(t, "")
+	cfg.Destinations = []config.DestinationConfig{{Name: "memory", Type: "memory-collide", Path: "unused"}}
+
+	mgr := newTestManager(t, cfg)
+	if err := mgr.TransferFiles("test-device", []string{first, second}); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	want := map[string]string{
+		"Client/Plug/ACam/001.mp4":    "earlier take",
+		"Client/Plug/ACam/001_v2.mp4": "second take",
+		"Client/Plug/ACam/001_v3.mp4": "third take",
+	}
+	for path, content := range want {
+		if got := string(mem.files[path]); got != content {
+			t.Errorf("Expected %s to hold %q, got %q", path, content, got)
+		}
+	}
+}
+
+func TestTransferManager_FailsCopyWhenCollisionCheckFails(t *testing.T) {
+	mem := &memDestination{files: map[string][]byte{"Client/Plug/ACam/001.mp4": []byte("earlier take")}, unreachable: true}
+	registerMemDestination(t, "memory-unreachable", mem)
+
+	destDir := t.TempDir()
+	src := writeTestFile(t, filepath.Join(t.TempDir(), "Plug_Client_ACam_001.mp4"), "second take")
+
+	cfg := newTestConfig(t, destDir)
+	cfg.Destinations = []config.DestinationConfig{
+		{Name: "raid", Path: destDir},
+		{Name: "memory", Type: "memory-unreachable", Path: "unused"},
+	}
+
+	mgr := newTestManager(t, cfg)
+	mgr.TransferFiles("test-device", []string{src})
+
+	if got := string(mem.files["Client/Plug/ACam/001.mp4"]); got != "earlier take" {
+		t.Errorf("Expected the earlier file to be kept, got %q", got)
+	}
+	if len(mem.files) != 1 {
+		t.Errorf("Expected nothing written to the unreachable destination, got %d files", len(mem.files))
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "Client", "Plug", "ACam", "001.mp4")); err != nil {
+		t.Errorf("Expected the copy on the reachable destination: %v", err)
+	}
+	if ds := mgr.GetStats().Destinations["memory"]; ds.FailedFiles != 1 {
+		t.Errorf("Expected the copy to fail on the unreachable destination, got %+v", ds)
+	}
+}
@@ -0,0 +1,225 @@
+package transfer
+
+import (
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/autofileingest/internal/config"
+)
+
+// minBurst keeps low rates from sleeping for every small read
+const minBurst = 64 * 1024
+
+// RateLimiter is a token bucket limiting the bytes per second of the copies
+// sharing it. A rate of zero means unlimited. The rate and schedule can be
+// changed while copies are running.
+type RateLimiter struct {
+	mu       sync.Mutex
+	rate     int64
+	schedule []scheduleWindow
+	// override is a rate set at runtime, taking precedence over the
+	// schedule while overridden is set
+	override   int64
+	overridden bool
+	tokens     float64
+	last       time.Time
+	// current is the rate the bucket was last filled at
+	current int64
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// scheduleWindow is a parsed config.ThrottleWindow in minutes after midnight
+type scheduleWindow struct {
+	start, end int
+	rate       int64
+}
+
+// NewRateLimiter creates a limiter allowing rate bytes per second
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{rate: rate, now: time.Now, sleep: time.Sleep}
+}
+
+// NewScheduledRateLimiter creates a limiter whose rate follows a
+// time-of-day schedule, falling back to rate outside of it
+func NewScheduledRateLimiter(rate int64, schedule []config.ThrottleWindow) (*RateLimiter, error) {
+	l := NewRateLimiter(rate)
+	if err := l.SetSchedule(schedule); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// SetRate changes the rate used outside of scheduled windows
+func (l *RateLimiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+}
+
+// Override sets a rate that applies whatever the schedule, until
+// ClearOverride
+func (l *RateLimiter) Override(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.override, l.overridden = rate, true
+}
+
+// ClearOverride returns to the schedule and base rate
+func (l *RateLimiter) ClearOverride() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.override, l.overridden = 0, false
+}
+
+// SetSchedule replaces the time-of-day schedule
+func (l *RateLimiter) SetSchedule(schedule []config.ThrottleWindow) error {
+	windows := make([]scheduleWindow, len(schedule))
+	for i, w := range schedule {
+		start, err := config.ParseClock(w.Start)
+		if err != nil {
+			return err
+		}
+		end, err := config.ParseClock(w.End)
+		if err != nil {
+			return err
+		}
+		windows[i] = scheduleWindow{start: start, end: end, rate: w.Rate}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.schedule = windows
+	return nil
+}
+
+// Rate returns the rate in effect now
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rateAt(l.now())
+}
+
+// rateAt returns the override, else the rate of the first window
+// containing t, else the base rate
+func (l *RateLimiter) rateAt(t time.Time) int64 {
+	if l.overridden {
+		return l.override
+	}
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range l.schedule {
+		inside := minute >= w.start && minute < w.end
+		if w.end <= w.start {
+			inside = minute >= w.start || minute < w.end
+		}
+		if inside {
+			return w.rate
+		}
+	}
+	return l.rate
+}
+
+// WaitN blocks until n more bytes may be transferred
+func (l *RateLimiter) WaitN(n int) {
+	l.mu.Lock()
+	now := l.now()
+	rate := l.rateAt(now)
+
+	if rate <= 0 {
+		l.tokens, l.last, l.current = 0, now, 0
+		l.mu.Unlock()
+		return
+	}
+
+	burst := float64(rate) / 4
+	if burst < minBurst {
+		burst = minBurst
+	}
+
+	// Start full after being unlimited or idle for long
+	if l.current == 0 || l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+	}
+	if l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last, l.current = now, rate
+
+	// Going into debt queues later callers behind this one
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		l.sleep(wait)
+	}
+}
+
+// throttledReader waits on every limiter for the bytes it reads
+type throttledReader struct {
+	r        io.Reader
+	limiters []*RateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		for _, l := range t.limiters {
+			l.WaitN(n)
+		}
+	}
+	return n, err
+}
+
+// SetRateLimiters throttles the copies of this manager. Typically the
+// global limiter shared by every device followed by the device's own.
+func (m *Manager) SetRateLimiters(limiters ...*RateLimiter) {
+	m.limiters = nil
+	for _, l := range limiters {
+		if l != nil {
+			m.limiters = append(m.limiters, l)
+		}
+	}
+}
+
+// throttled reports whether any limiter currently limits the rate. It's
+// checked as each file starts, so a kernel copy that started unthrottled
+// isn't slowed by a window opening or a limit set while it runs.
+func (m *Manager) throttled() bool {
+	for _, l := range m.limiters {
+		if l.Rate() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// throttle wraps a source in the manager's rate limiters
+func (m *Manager) throttle(src io.Reader) io.Reader {
+	if len(m.limiters) == 0 {
+		return src
+	}
+	return &throttledReader{r: src, limiters: m.limiters}
+}
+
+// DeviceThrottle returns the first throttle profile matching a device's
+// name or label
+func DeviceThrottle(cfg config.ThrottleConfig, name, label string) (config.DeviceThrottleConfig, bool) {
+	for _, dev := range cfg.Devices {
+		if ok, _ := filepath.Match(dev.Match, name); ok {
+			return dev, true
+		}
+		if ok, _ := filepath.Match(dev.Match, label); ok && label != "" {
+			return dev, true
+		}
+	}
+	return config.DeviceThrottleConfig{}, false
+}
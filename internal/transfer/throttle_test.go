@@ -0,0 +1,170 @@
+package transfer
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/autofileingest/internal/config"
+)
+
+// fakeClock drives a RateLimiter without sleeping
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.slept += d
+}
+
+func newFakeLimiter(rate int64, at time.Time) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{now: at}
+	l := NewRateLimiter(rate)
+	l.now, l.sleep = clock.Now, clock.Sleep
+	return l, clock
+}
+
+func TestRateLimiter_LimitsRate(t *testing.T) {
+	l, clock := newFakeLimiter(1000000, time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local))
+
+	// 10 MB at 1 MB/s, less the initial burst of 250 KB
+	for i := 0; i < 100; i++ {
+		l.WaitN(100000)
+	}
+	if want := 9750 * time.Millisecond; clock.slept < want-time.Millisecond || clock.slept > want+time.Millisecond {
+		t.Errorf("Expected about %v of waiting, got %v", want, clock.slept)
+	}
+}
+
+func TestRateLimiter_Unlimited(t *testing.T) {
+	l, clock := newFakeLimiter(0, time.Now())
+	for i := 0; i < 100; i++ {
+		l.WaitN(1 << 20)
+	}
+	if clock.slept != 0 {
+		t.Errorf("Expected no waiting without a rate, got %v", clock.slept)
+	}
+}
+
+func TestRateLimiter_SetRateAtRuntime(t *testing.T) {
+	l, clock := newFakeLimiter(0, time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local))
+	l.WaitN(10 << 20)
+
+	l.SetRate(100000)
+	for i := 0; i < 10; i++ {
+		l.WaitN(100000)
+	}
+	if clock.slept < 9*time.Second {
+		t.Errorf("Expected the new rate to apply at once, waited %v", clock.slept)
+	}
+
+	l.SetRate(0)
+	before := clock.slept
+	l.WaitN(10 << 20)
+	if clock.slept != before {
+		t.Errorf("Expected no waiting after removing the limit, got %v", clock.slept-before)
+	}
+}
+
+func TestRateLimiter_OverrideBeatsSchedule(t *testing.T) {
+	l, err := NewScheduledRateLimiter(50000000, []config.ThrottleWindow{{Start: "20:00", End: "07:00", Rate: 0}})
+	if err != nil {
+		t.Fatalf("Failed to create limiter: %v", err)
+	}
+	night, _ := time.ParseInLocation("15:04", "23:00", time.Local)
+
+	l.Override(1000000)
+	if got := l.rateAt(night); got != 1000000 {
+		t.Errorf("Expected the override to apply inside the window, got %d", got)
+	}
+	l.ClearOverride()
+	if got := l.rateAt(night); got != 0 {
+		t.Errorf("Expected the window rate once the override is cleared, got %d", got)
+	}
+}
+
+func TestRateLimiter_Schedule(t *testing.T) {
+	l, err := NewScheduledRateLimiter(50000000, []config.ThrottleWindow{
+		{Start: "22:00", End: "06:00", Rate: 0},
+		{Start: "12:00", End: "13:00", Rate: 200000000},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create limiter: %v", err)
+	}
+
+	for clock, want := range map[string]int64{
+		"23:30": 0,
+		"02:00": 0,
+		"06:00": 50000000,
+		"12:30": 200000000,
+		"21:59": 50000000,
+	} {
+		at, _ := time.ParseInLocation("15:04", clock, time.Local)
+		if got := l.rateAt(at); got != want {
+			t.Errorf("Expected rate %d at %s, got %d", want, clock, got)
+		}
+	}
+
+	if _, err := NewScheduledRateLimiter(0, []config.ThrottleWindow{{Start: "25:00", End: "06:00"}}); err == nil {
+		t.Error("Expected an error for an invalid time of day")
+	}
+}
+
+func TestDeviceThrottle(t *testing.T) {
+	cfg := config.ThrottleConfig{Devices: []config.DeviceThrottleConfig{
+		{Match: "GOPRO*", Rate: 1},
+		{Match: "sd*", Rate: 2},
+	}}
+
+	if dev, ok := DeviceThrottle(cfg, "sdb1", "GOPRO_HERO"); !ok || dev.Rate != 1 {
+		t.Errorf("Expected the label to match the first profile, got %+v", dev)
+	}
+	if dev, ok := DeviceThrottle(cfg, "sdc1", ""); !ok || dev.Rate != 2 {
+		t.Errorf("Expected the name to match the second profile, got %+v", dev)
+	}
+	if _, ok := DeviceThrottle(cfg, "nvme0n1", "CARD"); ok {
+		t.Error("Expected no profile to match")
+	}
+}
+
+func TestTransferManager_Throttled(t *testing.T) {
+	destDir := t.TempDir()
+	testFile := writeTestFile(t, filepath.Join(t.TempDir(), "Slow_Client_ACam_001.mp4"), strings.Repeat("x", 1<<20))
+
+	cfg := newTestConfig(t, destDir)
+	cfg.Transfer.FastCopy = true
+	cfg.Transfer.BufferSize = 64 * 1024
+
+	global, globalClock := newFakeLimiter(1<<20, time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local))
+	device, deviceClock := newFakeLimiter(512*1024, time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local))
+
+	mgr := newTestManager(t, cfg)
+	mgr.SetRateLimiters(global, device)
+	if err := mgr.TransferFiles("test-device", []string{testFile}); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+	if len(mgr.GetCompletedTransfers()) != 1 {
+		t.Fatal("Expected the throttled transfer to complete")
+	}
+
+	// 1 MB through both limiters, less their initial bursts
+	if globalClock.slept < 700*time.Millisecond {
+		t.Errorf("Expected the global limiter to wait about 0.75s, got %v", globalClock.slept)
+	}
+	if deviceClock.slept < 1700*time.Millisecond {
+		t.Errorf("Expected the device limiter to wait about 1.75s, got %v", deviceClock.slept)
+	}
+}
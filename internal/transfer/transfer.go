@@ -1,70 +1,259 @@
 package transfer
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
+	pathpkg "path"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/autofileingest/internal/checksum"
 	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/dedup"
 	"github.com/autofileingest/internal/logger"
+	"github.com/autofileingest/internal/mhl"
 	"github.com/autofileingest/internal/parser"
+	"github.com/autofileingest/internal/storage"
 )
 
 // FileTransfer represents a file to be transferred
 type FileTransfer struct {
-	SourcePath      string
+	SourcePath string
+	// DestinationPath is the path on the primary destination
 	DestinationPath string
+	Copies          []DestinationCopy
 	FileInfo        *parser.FileInfo
 	Size            int64
-	Priority        bool
-	Checksum        string
+	Priority        int
+	Checksums       checksum.Digests
+	Completed       time.Time
+}
+
+// DestinationCopy is one copy of a file on one destination
+type DestinationCopy struct {
+	Destination string
+	Root        string
+	// RelPath is the slash-separated path within the destination
+	RelPath string
+	// Path is the location of the copy, for logs and reports
+	Path string
+	Err  error
+}
+
+// Succeeded reports whether every copy of the file was written and verified
+func (t *FileTransfer) Succeeded() bool {
+	for _, c := range t.Copies {
+		if c.Err != nil {
+			return false
+		}
+	}
+	return len(t.Copies) > 0
 }
 
 // TransferStats holds transfer statistics
 type TransferStats struct {
-	TotalFiles      int
-	ProcessedFiles  int
-	TotalBytes      int64
+	TotalFiles       int
+	ProcessedFiles   int
+	TotalBytes       int64
 	TransferredBytes int64
-	FailedFiles     int
-	SkippedFiles    int
-	StartTime       time.Time
-	mu              sync.RWMutex
+	FailedFiles      int
+	SkippedFiles     int
+	StartTime        time.Time
+
+	// ManifestVerified counts files matching a hash recorded on the source
+	ManifestVerified int
+	// SourceMismatches counts files whose source no longer matches that hash
+	SourceMismatches int
+
+	// Destinations holds results per destination name
+	Destinations map[string]DestinationStats
+
+	// Workers is the number of workers used, as chosen in adaptive mode
+	Workers int
+
+	// Skipped lists the files counted in SkippedFiles, which had already
+	// been ingested and are left out of TotalFiles and TotalBytes
+	Skipped []SkippedFile
+
+	// Spans lists the clips split over several files
+	Spans []SpanGroup
+}
+
+// DestinationStats holds the results for one destination
+type DestinationStats struct {
+	Files       int
+	Bytes       int64
+	FailedFiles int
 }
 
 // Manager handles file transfers
 type Manager struct {
-	config  *config.Config
-	logger  *logger.Logger
-	parser  *parser.Parser
-	stats   *TransferStats
+	// copiedBytes counts the source bytes read, for adaptive workers. It's
+	// first to keep it 64-bit aligned for atomic access.
+	copiedBytes int64
+
+	config       *config.Config
+	logger       *logger.Logger
+	parser       *parser.Parser
+	destinations []destination
+	stats        *TransferStats
+	buffers      *bufferPool
+	statsMu      sync.RWMutex
+
+	// processed holds every attempted transfer of the current ingest
+	processed []FileTransfer
+
+	// reference holds hashes from manifests found on the source media
+	reference *mhl.Reference
+	// sourceChecks records files verified against those manifests
+	sourceChecks []sourceCheck
+
+	notifier           Notifier
+	spaceCheckInterval time.Duration
+	// spaceWait bounds how long an ingest waits for space
+	spaceWait time.Duration
+	// spaceMu is held while a worker waits for space, pausing the others
+	spaceMu sync.Mutex
+	// spaceTimedOut is set once a paused ingest gave up waiting, so later
+	// files fail at once instead of each waiting again
+	spaceTimedOut bool
+	// reserved holds the bytes of running copies per destination
+	reserved []int64
+	// spaceUnknown records destinations already logged as unable to
+	// report free space
+	spaceUnknown map[string]bool
+
+	// limiters throttle the copies, see SetRateLimiters
+	limiters []*RateLimiter
+
+	adaptInterval time.Duration
+
+	// index is the dedup index, open during an ingest when enabled
+	index       *dedup.Index
+	contentKeys map[string]contentKey
+
+	// roll is the camera roll of the card, expanded as {roll}
+	roll string
+
+	// planned holds the paths chosen during this ingest, by destination
+	planned map[string]bool
 }
 
 // NewManager creates a new transfer manager
 func NewManager(cfg *config.Config, log *logger.Logger, p *parser.Parser) *Manager {
+	interval := cfg.Transfer.SpaceCheckInterval
+	if interval < 1 {
+		interval = config.DefaultSpaceCheckInterval
+	}
+	wait := cfg.Transfer.SpaceWait
+	if wait < 1 {
+		wait = config.DefaultSpaceWait
+	}
+
 	return &Manager{
-		config: cfg,
-		logger: log,
-		parser: p,
-		stats: &TransferStats{
-			StartTime: time.Now(),
-		},
+		config:             cfg,
+		logger:             log,
+		parser:             p,
+		buffers:            newBufferPool(cfg.Transfer.BufferSize),
+		stats:              newTransferStats(),
+		spaceCheckInterval: time.Duration(interval) * time.Second,
+		spaceWait:          time.Duration(wait) * time.Second,
+		adaptInterval:      3 * time.Second,
+	}
+}
+
+// SetRoll sets the camera roll of the card, used for {roll} in templates
+func (m *Manager) SetRoll(roll string) {
+	m.roll = roll
+}
+
+// destination is a configured destination opened for an ingest
+type destination struct {
+	config config.DestinationConfig
+	store  storage.Destination
+}
+
+// openDestinations creates the storage backend of every destination
+func (m *Manager) openDestinations() error {
+	m.destinations = nil
+	for _, cfg := range m.config.GetDestinations() {
+		store, err := storage.New(cfg)
+		if err != nil {
+			m.closeDestinations()
+			return fmt.Errorf("destination %s: %w", cfg.Name, err)
+		}
+		m.destinations = append(m.destinations, destination{config: cfg, store: store})
+	}
+	m.reserved = make([]int64, len(m.destinations))
+	return nil
+}
+
+// checkDestinations refuses the ingest when a destination isn't ready,
+// such as a RAID that failed to mount
+func (m *Manager) checkDestinations(deviceName string) error {
+	for _, dest := range m.destinations {
+		checker, ok := dest.store.(storage.Checker)
+		if !ok {
+			continue
+		}
+		if err := checker.Check(); err != nil {
+			m.logger.DeviceError(deviceName, "Refusing ingest, destination %s is unavailable: %v", dest.config.Name, err)
+			m.notifyDestinationUnavailable(deviceName, dest.config.Name, err)
+			return fmt.Errorf("destination %s: %w", dest.config.Name, err)
+		}
+	}
+	return nil
+}
+
+// closeDestinations releases backends holding connections
+func (m *Manager) closeDestinations() {
+	for _, dest := range m.destinations {
+		if closer, ok := dest.store.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// newTransferStats returns empty statistics starting now
+func newTransferStats() *TransferStats {
+	return &TransferStats{
+		StartTime:    time.Now(),
+		Destinations: map[string]DestinationStats{},
 	}
 }
 
 // TransferFiles transfers files from source to destination
 func (m *Manager) TransferFiles(deviceName string, files []string) error {
-	m.stats = &TransferStats{
-		StartTime: time.Now(),
+	m.stats = newTransferStats()
+	m.processed = nil
+	m.sourceChecks = nil
+	m.planned = nil
+
+	if err := m.openDestinations(); err != nil {
+		return err
 	}
+	defer m.closeDestinations()
+
+	if err := m.checkDestinations(deviceName); err != nil {
+		return err
+	}
+
+	// Parse files and work out their priorities
+	rules := compilePriorityRules(m.config.Transfer.PriorityRules)
+	transfers := []FileTransfer{}
+	modTimes := map[string]time.Time{}
+	prioritized := 0
 
-	// Parse and categorize files
-	priorityFiles := []FileTransfer{}
-	normalFiles := []FileTransfer{}
+	// Segments of spanned clips are routed, queued and verified together
+	spans := map[string]parser.Span{}
+	if m.config.Parsing.GroupSpans {
+		spans = parser.GroupSpans(files)
+	}
+	groups := newSpanGroups(spans)
 
 	for _, filePath := range files {
 		fileInfo, err := os.Stat(filePath)
@@ -77,59 +266,73 @@ func (m *Manager) TransferFiles(deviceName string, files []string) error {
 			continue
 		}
 
-		parsedInfo := m.parser.Parse(filePath)
-		destPath, err := m.parser.GetUniqueDestinationPath(parsedInfo)
-		if err != nil {
-			m.logger.DeviceError(deviceName, "Failed to get destination path for %s: %v", filePath, err)
-			continue
-		}
+		parsedInfo := m.parseFile(filePath, spans)
+		parsedInfo.Roll = m.roll
+		copies := m.planCopies(parsedInfo)
 
 		transfer := FileTransfer{
 			SourcePath:      filePath,
-			DestinationPath: destPath,
+			DestinationPath: copies[0].Path,
+			Copies:          copies,
 			FileInfo:        parsedInfo,
 			Size:            fileInfo.Size(),
-			Priority:        m.isPriorityFile(filepath.Base(filePath)),
 		}
+		transfer.Priority = m.filePriority(rules, filePath, transfer.Size, parsedInfo)
 
 		m.stats.TotalFiles++
 		m.stats.TotalBytes += transfer.Size
+		if span := parsedInfo.Span; span != nil {
+			groups[span.Lead].Size += transfer.Size
+		}
 
-		if transfer.Priority {
-			priorityFiles = append(priorityFiles, transfer)
-		} else {
-			normalFiles = append(normalFiles, transfer)
+		if transfer.Priority > 0 {
+			prioritized++
 		}
+		transfers = append(transfers, transfer)
+		modTimes[filePath] = fileInfo.ModTime()
 	}
 
-	m.logger.DeviceInfo(deviceName, "Found %d files (%d priority, %d normal)", 
-		m.stats.TotalFiles, len(priorityFiles), len(normalFiles))
+	m.logger.DeviceInfo(deviceName, "Found %d files (%d priority, %d normal)",
+		m.stats.TotalFiles, prioritized, m.stats.TotalFiles-prioritized)
+	if len(groups) > 0 {
+		m.logger.DeviceInfo(deviceName, "Found %d spanned clips in %d segments", len(groups), len(spans))
+		alignSpans(transfers, modTimes)
+	}
 
-	// Create worker pool
-	jobs := make(chan FileTransfer, m.stats.TotalFiles)
-	results := make(chan error, m.stats.TotalFiles)
-	var wg sync.WaitGroup
+	m.openIndex(deviceName)
+	defer m.closeIndex()
+	transfers = m.skipDuplicates(deviceName, transfers)
 
-	// Start workers
-	for i := 0; i < m.config.Transfer.MaxWorkers; i++ {
-		wg.Add(1)
-		go m.worker(deviceName, jobs, results, &wg)
+	// Read order applies among files of equal priority
+	m.orderJobs(deviceName, transfers)
+	queue := &jobQueue{newestFirst: m.config.Transfer.NewestFirst}
+	for _, transfer := range transfers {
+		queue.push(transfer, modTimes[transfer.SourcePath])
 	}
 
-	// Send priority files first
-	for _, transfer := range priorityFiles {
-		jobs <- transfer
+	if err := m.preflightSpace(deviceName); err != nil {
+		return err
 	}
 
-	// Then send normal files
-	for _, transfer := range normalFiles {
+	// Queue files highest priority first
+	jobs := make(chan FileTransfer, m.stats.TotalFiles)
+	results := make(chan error, m.stats.TotalFiles)
+	for _, transfer := range queue.drain() {
 		jobs <- transfer
 	}
-
 	close(jobs)
 
+	// Start workers, either max_workers or as many as turn out fastest
+	pool := &workerPool{m: m, deviceName: deviceName, jobs: jobs, results: results}
+	if m.config.Transfer.AdaptiveWorkers && m.config.Transfer.MaxWorkers > 1 {
+		m.adaptWorkers(deviceName, pool, jobs)
+	} else {
+		pool.resize(m.config.Transfer.MaxWorkers)
+	}
+	m.stats.Workers = pool.size()
+
 	// Wait for all workers to finish
-	wg.Wait()
+	pool.wg.Wait()
 	close(results)
 
 	// Collect results
@@ -139,101 +342,583 @@ func (m *Manager) TransferFiles(deviceName string, files []string) error {
 		}
 	}
 
+	if len(groups) > 0 {
+		m.verifySpans(deviceName, groups)
+	}
+
+	if len(m.destinations) > 1 {
+		for _, dest := range m.destinations {
+			ds := m.stats.Destinations[dest.config.Name]
+			m.logger.DeviceInfo(deviceName, "Destination %s: %d files, %d failed",
+				dest.config.Name, ds.Files, ds.FailedFiles)
+		}
+	}
+
+	if m.config.MHL.Enabled {
+		m.writeManifests(deviceName)
+		if m.config.MHL.ExtendSourceHistory {
+			m.extendSourceHistories(deviceName)
+		}
+	}
+
 	return nil
 }
 
-// worker processes file transfers
-func (m *Manager) worker(deviceName string, jobs <-chan FileTransfer, results chan<- error, wg *sync.WaitGroup) {
+// planCopies resolves the path of a file on every destination. Copies are
+// in the same order as m.destinations. A copy whose path can't be resolved
+// keeps the unversioned path and its error, failing on that destination
+// alone.
+func (m *Manager) planCopies(info *parser.FileInfo) []DestinationCopy {
+	copies := make([]DestinationCopy, 0, len(m.destinations))
+	for _, dest := range m.destinations {
+		layout := parser.Layout{
+			Root:            m.parser.ExpandTemplate(dest.config.Prefix, info),
+			FolderStructure: dest.config.FolderStructure,
+			UnmatchedFolder: dest.config.UnmatchedFolder,
+		}
+
+		path := filepath.ToSlash(m.parser.GetFullDestinationPathFor(info, layout))
+		relPath, err := m.uniquePath(dest, path)
+		if err != nil {
+			relPath = path
+		}
+
+		copies = append(copies, DestinationCopy{
+			Destination: dest.config.Name,
+			Root:        dest.config.Path,
+			RelPath:     relPath,
+			Path:        dest.store.Location(relPath),
+			Err:         err,
+		})
+	}
+	return copies
+}
+
+// uniquePath returns path, or its first _vN version not stored on the
+// destination nor planned earlier in this ingest. It fails when the
+// destination can't be asked, rather than risk replacing an earlier file.
+func (m *Manager) uniquePath(dest destination, path string) (string, error) {
+	if m.planned == nil {
+		m.planned = map[string]bool{}
+	}
+	ext := pathpkg.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+
+	candidate := path
+	for version := 2; ; version++ {
+		key := dest.config.Name + "|" + candidate
+		if !m.planned[key] {
+			exists, err := dest.store.Exists(candidate)
+			if err != nil {
+				return "", fmt.Errorf("failed to check for %s: %w", candidate, err)
+			}
+			if !exists {
+				m.planned[key] = true
+				return candidate, nil
+			}
+		}
+		if version > 1000 {
+			return "", fmt.Errorf("too many versions of file: %s", path)
+		}
+		candidate = fmt.Sprintf("%s_v%d%s", base, version, ext)
+	}
+}
+
+// worker processes file transfers until the jobs run out or stop is closed
+func (m *Manager) worker(deviceName string, jobs <-chan FileTransfer, results chan<- error, stop <-chan struct{}, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	for transfer := range jobs {
-		err := m.transferFile(deviceName, transfer)
+	for {
+		var transfer FileTransfer
+		select {
+		case <-stop:
+			return
+		case next, ok := <-jobs:
+			if !ok {
+				return
+			}
+			transfer = next
+		}
+
+		err := m.reserveSpace(deviceName, &transfer)
+		if err == nil {
+			err = m.transferFile(deviceName, &transfer)
+			m.releaseSpace(&transfer)
+		} else {
+			failCopies(&transfer, err)
+		}
+		if err == nil {
+			m.indexTransfer(deviceName, &transfer)
+		}
 		results <- err
-		
-		m.stats.mu.Lock()
+
+		m.statsMu.Lock()
 		m.stats.ProcessedFiles++
 		if err == nil {
 			m.stats.TransferredBytes += transfer.Size
 		}
-		m.stats.mu.Unlock()
+		for _, c := range transfer.Copies {
+			ds := m.stats.Destinations[c.Destination]
+			if c.Err == nil {
+				ds.Files++
+				ds.Bytes += transfer.Size
+			} else {
+				ds.FailedFiles++
+			}
+			m.stats.Destinations[c.Destination] = ds
+		}
+		transfer.Completed = time.Now()
+		m.processed = append(m.processed, transfer)
+		m.statsMu.Unlock()
 	}
 }
 
-// transferFile transfers a single file
-func (m *Manager) transferFile(deviceName string, transfer FileTransfer) error {
-	// Create destination directory
-	destDir := filepath.Dir(transfer.DestinationPath)
-	if err := os.MkdirAll(destDir, 0755); err != nil {
-		m.logger.DeviceError(deviceName, "Failed to create directory %s: %v", destDir, err)
-		return err
+// copyTarget is an open destination file for one copy of a transfer
+type copyTarget struct {
+	copy      *DestinationCopy
+	store     storage.Destination
+	writer    storage.Writer
+	sink      *copyWriter
+	committed bool
+}
+
+// copyWriter isolates one destination's write errors so a failing mirror
+// doesn't abort the copies going to the other destinations. Once every
+// destination has failed the error is passed on to stop reading the source.
+type copyWriter struct {
+	w     io.Writer
+	err   error
+	alive *int32
+}
+
+func (c *copyWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return len(p), nil
 	}
 
+	n, err := c.w.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		c.err = err
+		if atomic.AddInt32(c.alive, -1) == 0 {
+			return n, err
+		}
+	}
+	return len(p), nil
+}
+
+// transferFile transfers a single file to every destination from one read
+// of the source, then verifies each copy independently
+func (m *Manager) transferFile(deviceName string, transfer *FileTransfer) error {
 	// Open source file
 	srcFile, err := os.Open(transfer.SourcePath)
 	if err != nil {
 		m.logger.DeviceError(deviceName, "Failed to open source file %s: %v", transfer.SourcePath, err)
+		failCopies(transfer, err)
 		return err
 	}
 	defer srcFile.Close()
 
-	// Create destination file
-	destFile, err := os.Create(transfer.DestinationPath)
+	targets := m.openTargets(deviceName, transfer)
+	defer func() {
+		for _, target := range targets {
+			target.writer.Close()
+		}
+	}()
+	if len(targets) == 0 {
+		for _, c := range transfer.Copies {
+			if c.Err != nil {
+				return c.Err
+			}
+		}
+	}
+
+	if m.config.Transfer.CacheHints {
+		adviseSequential(srcFile)
+	}
+
+	// Copy in the kernel when enabled; checksums are then computed in a
+	// separate pass over both files instead of during the copy. Mirrors
+	// always use the userspace pipeline so the source is read only once,
+	// and so do copies throttled when they start.
+	useKernelCopy := m.config.Transfer.FastCopy && kernelCopySupported && len(targets) == 1 && !m.throttled()
+	if _, ok := targets[0].writer.(storage.FileWriter); !ok {
+		useKernelCopy = false
+	}
+
+	var algorithms []string
+	if m.config.Transfer.VerifyChecksums {
+		algorithms = m.algorithmsFor(transfer.SourcePath)
+	}
+
+	srcDigests, err := m.copyToTargets(srcFile, targets, transfer.Size, algorithms, useKernelCopy)
 	if err != nil {
-		m.logger.DeviceError(deviceName, "Failed to create destination file %s: %v", transfer.DestinationPath, err)
+		m.logger.DeviceError(deviceName, "Failed to copy file %s: %v", transfer.SourcePath, err)
+		for _, target := range targets {
+			m.abortCopy(target, err)
+		}
 		return err
 	}
-	defer destFile.Close()
+	for _, target := range targets {
+		if target.sink.err != nil {
+			m.logger.DeviceError(deviceName, "Failed to copy file %s to %s: %v", transfer.SourcePath, target.copy.Destination, target.sink.err)
+			m.abortCopy(target, target.sink.err)
+			continue
+		}
+
+		if err := target.writer.Commit(); err != nil {
+			m.logger.DeviceError(deviceName, "Failed to commit %s: %v", target.copy.Path, err)
+			m.abortCopy(target, err)
+			continue
+		}
+		target.committed = true
+	}
 
-	// Calculate checksum while copying
-	var srcChecksum, destChecksum string
-	
 	if m.config.Transfer.VerifyChecksums {
-		srcHash := sha256.New()
-		_, err = io.Copy(io.MultiWriter(destFile, srcHash), srcFile)
-		if err != nil {
-			m.logger.DeviceError(deviceName, "Failed to copy file %s: %v", transfer.SourcePath, err)
-			return err
+		// Verify every destination file with the primary algorithm
+		primary := m.hashAlgorithms()[:1]
+		for _, target := range targets {
+			if target.copy.Err != nil {
+				continue
+			}
+
+			destDigests, err := m.verifyCopy(target, primary)
+			if err != nil {
+				m.logger.DeviceError(deviceName, "Failed to verify file %s: %v", target.copy.Path, err)
+				m.abortCopy(target, err)
+				continue
+			}
+
+			if _, equal := srcDigests.Match(destDigests); !equal {
+				m.logger.DeviceError(deviceName, "Checksum mismatch for %s on %s (%s): copy corruption",
+					transfer.SourcePath, target.copy.Destination, primary[0])
+				m.abortCopy(target, fmt.Errorf("checksum mismatch"))
+			}
 		}
-		srcChecksum = fmt.Sprintf("%x", srcHash.Sum(nil))
 
-		// Verify destination file
-		destFile.Seek(0, 0)
-		destHash := sha256.New()
-		if _, err := io.Copy(destHash, destFile); err != nil {
-			m.logger.DeviceError(deviceName, "Failed to verify file %s: %v", transfer.DestinationPath, err)
-			return err
+		// Read the card a second time to catch flaky readers and media
+		if m.config.Transfer.VerifySourceReread {
+			rereadDigests, err := m.rereadDigests(transfer.SourcePath, primary)
+			if err != nil {
+				m.logger.DeviceError(deviceName, "Failed to re-read source file %s: %v", transfer.SourcePath, err)
+				for _, target := range targets {
+					m.abortCopy(target, err)
+				}
+				return err
+			}
+			if _, equal := srcDigests.Match(rereadDigests); !equal {
+				m.logger.DeviceError(deviceName, "Source re-read mismatch for %s (unstable source media)", transfer.SourcePath)
+				for _, target := range targets {
+					m.abortCopy(target, fmt.Errorf("source re-read mismatch"))
+				}
+				return fmt.Errorf("source re-read mismatch")
+			}
 		}
-		destChecksum = fmt.Sprintf("%x", destHash.Sum(nil))
 
-		if srcChecksum != destChecksum {
-			m.logger.DeviceError(deviceName, "Checksum mismatch for %s", transfer.SourcePath)
-			os.Remove(transfer.DestinationPath)
-			return fmt.Errorf("checksum mismatch")
+		transfer.Checksums = srcDigests
+		m.logger.DeviceInfo(deviceName, "Checksums for %s: %s", filepath.Base(transfer.SourcePath), srcDigests)
+
+		// Compare with the hash the camera or offload tool recorded
+		if expected, ok := m.reference.Lookup(transfer.SourcePath); ok {
+			if err := m.checkSourceManifest(deviceName, transfer, srcFile, expected); err != nil {
+				for i := range transfer.Copies {
+					if transfer.Copies[i].Err == nil {
+						transfer.Copies[i].Err = err
+					}
+				}
+				return err
+			}
 		}
-	} else {
-		// Simple copy without verification
-		_, err = io.Copy(destFile, srcFile)
-		if err != nil {
-			m.logger.DeviceError(deviceName, "Failed to copy file %s: %v", transfer.SourcePath, err)
-			return err
+	}
+
+	m.preserveMetadata(deviceName, transfer, targets)
+
+	// Keep multi-hundred-GB ingests from evicting the whole page cache
+	if m.config.Transfer.CacheHints {
+		for _, target := range targets {
+			if fw, ok := target.writer.(storage.FileWriter); ok && target.copy.Err == nil {
+				fw.File().Sync()
+				adviseDontNeed(fw.File())
+			}
+		}
+		adviseDontNeed(srcFile)
+	}
+
+	if !transfer.Succeeded() {
+		for _, c := range transfer.Copies {
+			if c.Err != nil {
+				return c.Err
+			}
 		}
 	}
 
 	// Log successful transfer
 	if !transfer.FileInfo.Matched {
-		m.logger.DeviceInfo(deviceName, "Transferred (unmatched): %s -> %s", 
+		m.logger.DeviceInfo(deviceName, "Transferred (unmatched): %s -> %s",
 			filepath.Base(transfer.SourcePath), transfer.DestinationPath)
 	} else {
-		m.logger.DeviceSuccess(deviceName, "Transferred: %s -> %s/%s/%s", 
-			filepath.Base(transfer.SourcePath), 
-			transfer.FileInfo.Client, 
-			transfer.FileInfo.ProjectName, 
+		m.logger.DeviceSuccess(deviceName, "Transferred: %s -> %s/%s/%s",
+			filepath.Base(transfer.SourcePath),
+			transfer.FileInfo.Client,
+			transfer.FileInfo.ProjectName,
 			transfer.FileInfo.Camera)
 	}
 
 	return nil
 }
 
+// preserveMetadata gives the copies the timestamps of the source and,
+// when enabled, its mode and extended attributes. Failures are logged but
+// don't fail the copy, since the data itself is intact.
+func (m *Manager) preserveMetadata(deviceName string, transfer *FileTransfer, targets []*copyTarget) {
+	var meta *storage.Metadata
+	for _, target := range targets {
+		setter, ok := target.store.(storage.MetadataSetter)
+		if !ok || target.copy.Err != nil {
+			continue
+		}
+
+		if meta == nil {
+			source, err := storage.ReadMetadata(transfer.SourcePath, m.config.Transfer.PreserveXattrs)
+			if err != nil {
+				m.logger.DeviceError(deviceName, "Failed to read metadata of %s: %v", transfer.SourcePath, err)
+				return
+			}
+			if !m.config.Transfer.PreserveMode {
+				source.Mode = 0
+			}
+			meta = &source
+		}
+
+		if err := setter.SetMetadata(target.copy.RelPath, *meta); err != nil {
+			m.logger.DeviceError(deviceName, "Failed to preserve metadata on %s: %v", target.copy.Path, err)
+		}
+	}
+}
+
+// openTargets creates the destination file of every copy. Copies that can't
+// be created are marked failed and left out.
+func (m *Manager) openTargets(deviceName string, transfer *FileTransfer) []*copyTarget {
+	var targets []*copyTarget
+	alive := new(int32)
+
+	for i := range transfer.Copies {
+		c := &transfer.Copies[i]
+		store := m.destinations[i].store
+		if c.Err != nil {
+			m.logger.DeviceError(deviceName, "Failed to plan destination file %s: %v", c.Path, c.Err)
+			continue
+		}
+
+		writer, err := storage.CreateSized(store, c.RelPath, transfer.Size)
+		if err != nil {
+			m.logger.DeviceError(deviceName, "Failed to create destination file %s: %v", c.Path, err)
+			c.Err = err
+			continue
+		}
+
+		targets = append(targets, &copyTarget{
+			copy:   c,
+			store:  store,
+			writer: writer,
+			sink:   &copyWriter{w: writer, alive: alive},
+		})
+	}
+
+	atomic.StoreInt32(alive, int32(len(targets)))
+	return targets
+}
+
+// copyToTargets copies the source to every target and returns the source
+// digests when algorithms are given
+func (m *Manager) copyToTargets(src *os.File, targets []*copyTarget, size int64, algorithms []string, useKernelCopy bool) (checksum.Digests, error) {
+	if useKernelCopy {
+		dst := targets[0].writer.(storage.FileWriter).File()
+		if err := m.copyData(dst, src, size, true); err != nil {
+			return nil, err
+		}
+		if len(algorithms) == 0 {
+			return nil, nil
+		}
+		return m.hashFile(src, algorithms)
+	}
+
+	sinks := make([]io.Writer, 0, len(targets)+len(algorithms))
+	for _, target := range targets {
+		sinks = append(sinks, target.sink)
+	}
+
+	// Every algorithm hashes on its own goroutine alongside the writes
+	var hashes *checksum.Set
+	if len(algorithms) > 0 {
+		var err error
+		if hashes, err = checksum.NewSet(algorithms); err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, hashes.Writers()...)
+	}
+
+	if _, err := pipelineCopy(m.buffers, m.throttle(&countingReader{r: src, n: &m.copiedBytes}), sinks...); err != nil {
+		return nil, err
+	}
+
+	if hashes == nil {
+		return nil, nil
+	}
+	return hashes.Sums(), nil
+}
+
+// verifyCopy hashes a committed copy back from its destination. Local
+// files go through verifyDestination so the configured verify mode applies.
+func (m *Manager) verifyCopy(target *copyTarget, algorithms []string) (checksum.Digests, error) {
+	if fw, ok := target.writer.(storage.FileWriter); ok {
+		return m.verifyDestination(fw.File(), target.copy.Path, algorithms)
+	}
+	return target.store.Hash(target.copy.RelPath, algorithms)
+}
+
+// abortCopy marks a copy failed and discards its partial or corrupt file
+func (m *Manager) abortCopy(target *copyTarget, err error) {
+	if target.copy.Err == nil {
+		target.copy.Err = err
+	}
+
+	target.writer.Close()
+	if target.committed {
+		target.store.Remove(target.copy.RelPath)
+		target.committed = false
+	}
+}
+
+// failCopies marks every copy of a transfer failed
+func failCopies(transfer *FileTransfer, err error) {
+	for i := range transfer.Copies {
+		transfer.Copies[i].Err = err
+	}
+}
+
+// copyData copies size bytes from src to dst, in the kernel when requested.
+// If the kernel path fails before writing anything the copy falls back to
+// the userspace pipeline. Progress is counted in copiedBytes either way.
+func (m *Manager) copyData(dst, src *os.File, size int64, useKernelCopy bool) error {
+	if useKernelCopy {
+		written, err := kernelCopy(dst, src, size, &m.copiedBytes)
+		if err == nil || written > 0 {
+			return err
+		}
+		m.logger.Debug("Kernel copy unavailable for %s, using buffered copy: %v", src.Name(), err)
+	}
+
+	_, err := pipelineCopy(m.buffers, &countingReader{r: src, n: &m.copiedBytes}, dst)
+	return err
+}
+
+// hashAlgorithms returns the configured hash algorithms, primary first
+func (m *Manager) hashAlgorithms() []string {
+	if len(m.config.Transfer.HashAlgorithms) == 0 {
+		return []string{checksum.SHA256}
+	}
+	return m.config.Transfer.HashAlgorithms
+}
+
+// algorithmsFor returns the configured algorithms plus any the source
+// manifests used for this file, so both can be checked in the same pass
+func (m *Manager) algorithmsFor(sourcePath string) []string {
+	algorithms := m.hashAlgorithms()
+
+	expected, ok := m.reference.Lookup(sourcePath)
+	if !ok {
+		return algorithms
+	}
+
+	combined := append([]string{}, algorithms...)
+	for name := range expected.Digests {
+		if !containsString(combined, name) && checksum.IsSupported(name) {
+			combined = append(combined, name)
+		}
+	}
+	return combined
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// hashFile computes digests of a file from its start
+func (m *Manager) hashFile(f *os.File, algorithms []string) (checksum.Digests, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	set, err := checksum.NewSet(algorithms)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := pipelineCopy(m.buffers, f, set.Writers()...); err != nil {
+		return nil, err
+	}
+	return set.Sums(), nil
+}
+
+// verifyDestination hashes a freshly written destination file. In readback
+// mode the file is flushed and its cached pages dropped first, so the hash
+// reflects what is actually on disk rather than what is still in memory.
+// path is where the committed file is, since destFile was opened under a
+// temporary name.
+func (m *Manager) verifyDestination(destFile *os.File, path string, algorithms []string) (checksum.Digests, error) {
+	if m.config.Transfer.VerifyMode != config.VerifyModeReadback {
+		return m.hashFile(destFile, algorithms)
+	}
+
+	if err := destFile.Sync(); err != nil {
+		return nil, err
+	}
+	adviseDontNeed(destFile)
+
+	return m.rereadDigests(path, algorithms)
+}
+
+// rereadDigests reopens a file and hashes it from storage, bypassing the
+// page cache where the platform allows it
+func (m *Manager) rereadDigests(path string, algorithms []string) (checksum.Digests, error) {
+	f, direct, err := openUncached(path, m.config.Transfer.VerifyDirectIO)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if !direct {
+		return m.hashFile(f, algorithms)
+	}
+
+	set, err := checksum.NewSet(algorithms)
+	if err != nil {
+		return nil, err
+	}
+
+	// O_DIRECT needs aligned buffers, so the pool can't be used here
+	buf := alignedBuffer(m.buffers.size)
+	for {
+		n, err := f.Read(buf)
+		set.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return set.Sums(), nil
+}
+
 // isPriorityFile checks if a file should be transferred with priority
 func (m *Manager) isPriorityFile(fileName string) bool {
 	for _, prefix := range m.config.Transfer.PriorityPrefixes {
@@ -246,32 +931,63 @@ func (m *Manager) isPriorityFile(fileName string) bool {
 
 // GetStats returns current transfer statistics
 func (m *Manager) GetStats() TransferStats {
-	m.stats.mu.RLock()
-	defer m.stats.mu.RUnlock()
-	return *m.stats
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	stats := *m.stats
+	stats.Destinations = make(map[string]DestinationStats, len(m.stats.Destinations))
+	for name, ds := range m.stats.Destinations {
+		stats.Destinations[name] = ds
+	}
+	stats.Skipped = append([]SkippedFile(nil), m.stats.Skipped...)
+	stats.Spans = append([]SpanGroup(nil), m.stats.Spans...)
+	return stats
+}
+
+// GetCompletedTransfers returns the files transferred successfully to every
+// destination, including the digests recorded for each
+func (m *Manager) GetCompletedTransfers() []FileTransfer {
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	var completed []FileTransfer
+	for _, transfer := range m.processed {
+		if transfer.Succeeded() {
+			completed = append(completed, transfer)
+		}
+	}
+	return completed
+}
+
+// GetProcessedTransfers returns every file attempted, including failures
+func (m *Manager) GetProcessedTransfers() []FileTransfer {
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	return append([]FileTransfer(nil), m.processed...)
 }
 
 // GetProgress returns transfer progress as percentage
 func (m *Manager) GetProgress() float64 {
-	m.stats.mu.RLock()
-	defer m.stats.mu.RUnlock()
-	
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
 	if m.stats.TotalBytes == 0 {
 		return 0
 	}
-	
+
 	return float64(m.stats.TransferredBytes) / float64(m.stats.TotalBytes) * 100
 }
 
 // GetSpeed returns current transfer speed in bytes per second
 func (m *Manager) GetSpeed() float64 {
-	m.stats.mu.RLock()
-	defer m.stats.mu.RUnlock()
-	
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
 	elapsed := time.Since(m.stats.StartTime).Seconds()
 	if elapsed == 0 {
 		return 0
 	}
-	
+
 	return float64(m.stats.TransferredBytes) / elapsed
 }
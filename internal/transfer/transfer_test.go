@@ -4,10 +4,15 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/autofileingest/internal/checksum"
 	"github.com/autofileingest/internal/config"
 	"github.com/autofileingest/internal/logger"
+	"github.com/autofileingest/internal/mhl"
 	"github.com/autofileingest/internal/parser"
 )
 
@@ -257,3 +262,295 @@ func TestTransferManager_Checksums(t *testing.T) {
 		t.Errorf("Content mismatch: expected %s, got %s", string(testContent), string(destContent))
 	}
 }
+
+// newTestConfig returns a minimal config writing to destDir
+func newTestConfig(t *testing.T, destDir string) *config.Config {
+	t.Helper()
+	return &config.Config{
+		DestinationPath: destDir,
+		Logging: config.LoggingConfig{
+			ServerLogPath: t.TempDir(),
+			LogLevel:      "debug",
+		},
+		Transfer: config.TransferConfig{
+			MaxWorkers:      1,
+			VerifyChecksums: true,
+		},
+		Parsing: config.ParsingConfig{
+			Pattern:         "^([^_]+)_([^_]+)_(ACam|BCam|CCam)_(.+)$",
+			FolderStructure: "{client}/{project}/{camera}",
+			UnmatchedFolder: "Unsorted",
+		},
+	}
+}
+
+// newTestManager creates a transfer manager for cfg
+func newTestManager(t *testing.T, cfg *config.Config) *Manager {
+	t.Helper()
+
+	log, err := logger.NewLogger(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	t.Cleanup(func() { log.Close() })
+
+	p, err := parser.NewParser(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+
+	return NewManager(cfg, log, p)
+}
+
+// writeTestFile creates a source file with the given content
+func writeTestFile(t *testing.T, path, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	return path
+}
+
+func TestTransferManager_HashAlgorithms(t *testing.T) {
+	destDir := t.TempDir()
+	testFile := writeTestFile(t, filepath.Join(t.TempDir(), "Hash_Client_ACam_001.mp4"), "abc")
+
+	cfg := newTestConfig(t, destDir)
+	cfg.Transfer.VerifyMode = config.VerifyModeReadback
+	cfg.Transfer.HashAlgorithms = []string{checksum.XXH64, checksum.MD5}
+
+	mgr := newTestManager(t, cfg)
+	if err := mgr.TransferFiles("test-device", []string{testFile}); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	completed := mgr.GetCompletedTransfers()
+	if len(completed) != 1 {
+		t.Fatalf("Expected 1 completed transfer, got %d", len(completed))
+	}
+
+	digests := completed[0].Checksums
+	if digests[checksum.XXH64] != "44bc2cf5ad770999" {
+		t.Errorf("Unexpected xxh64 digest: %s", digests[checksum.XXH64])
+	}
+	if digests[checksum.MD5] != "900150983cd24fb0d6963f7d28e17f72" {
+		t.Errorf("Unexpected md5 digest: %s", digests[checksum.MD5])
+	}
+	if _, ok := digests[checksum.SHA256]; ok {
+		t.Errorf("Did not expect an unconfigured sha256 digest")
+	}
+}
+
+func TestTransferManager_WritesMHL(t *testing.T) {
+	destDir := t.TempDir()
+	testFile := writeTestFile(t, filepath.Join(t.TempDir(), "Manifest_Client_ACam_001.mp4"), "abc")
+
+	cfg := newTestConfig(t, destDir)
+	cfg.Transfer.HashAlgorithms = []string{checksum.XXH64}
+	cfg.MHL = config.MHLConfig{Enabled: true, Format: mhl.FormatASC}
+
+	mgr := newTestManager(t, cfg)
+	if err := mgr.TransferFiles("test-device", []string{testFile}); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	generations, err := filepath.Glob(filepath.Join(destDir, mhl.ASCHistoryDir, "0001_*.mhl"))
+	if err != nil || len(generations) != 1 {
+		t.Fatalf("Expected one MHL generation at the destination root, got %v", generations)
+	}
+
+	data, err := ioutil.ReadFile(generations[0])
+	if err != nil {
+		t.Fatalf("Failed to read MHL: %v", err)
+	}
+	if !strings.Contains(string(data), "Client/Manifest/ACam/001.mp4") ||
+		!strings.Contains(string(data), "44bc2cf5ad770999") {
+		t.Errorf("MHL does not list the transferred file and its hash:\n%s", data)
+	}
+}
+
+func TestTransferManager_SourceManifests(t *testing.T) {
+	card := t.TempDir()
+	destDir := t.TempDir()
+
+	good := writeTestFile(t, filepath.Join(card, "CLIPS", "Card_Client_ACam_001.mp4"), "abc")
+	bad := writeTestFile(t, filepath.Join(card, "CLIPS", "Card_Client_ACam_002.mp4"), "corrupted")
+	writeTestFile(t, filepath.Join(card, "CLIPS", "sums.md5"),
+		"900150983cd24fb0d6963f7d28e17f72  Card_Client_ACam_001.mp4\n"+
+			"900150983cd24fb0d6963f7d28e17f72  Card_Client_ACam_002.mp4\n")
+
+	// Camera history covering the first clip, to be extended on the card
+	_, err := mhl.Write(mhl.FormatASC, card, &mhl.Manifest{
+		Finished: time.Now(),
+		Entries: []mhl.Entry{{
+			Path:    "CLIPS/Card_Client_ACam_001.mp4",
+			Size:    3,
+			Digests: checksum.Digests{checksum.XXH64: "44bc2cf5ad770999"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to write card history: %v", err)
+	}
+
+	cfg := newTestConfig(t, destDir)
+	cfg.Transfer.HashAlgorithms = []string{checksum.SHA256}
+	cfg.MHL = config.MHLConfig{Enabled: true, Format: mhl.FormatASC, ExtendSourceHistory: true}
+
+	mgr := newTestManager(t, cfg)
+	mgr.LoadSourceManifests("test-device", card)
+	if err := mgr.TransferFiles("test-device", []string{good, bad}); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	stats := mgr.GetStats()
+	if stats.ManifestVerified != 1 {
+		t.Errorf("Expected 1 file verified against the card, got %d", stats.ManifestVerified)
+	}
+	if stats.SourceMismatches != 1 {
+		t.Errorf("Expected 1 source mismatch, got %d", stats.SourceMismatches)
+	}
+
+	// Source corruption keeps the faithful copy for the operator to inspect
+	if _, err := os.Stat(filepath.Join(destDir, "Client", "Card", "ACam", "002.mp4")); err != nil {
+		t.Errorf("Expected copy of corrupted source to be kept: %v", err)
+	}
+
+	// Card algorithms are computed alongside the configured ones
+	completed := mgr.GetCompletedTransfers()
+	if len(completed) != 1 || completed[0].Checksums[checksum.MD5] == "" || completed[0].Checksums[checksum.XXH64] == "" {
+		t.Fatalf("Expected md5 and xxh64 to be computed for the verified file, got %+v", completed)
+	}
+
+	generations, _ := filepath.Glob(filepath.Join(card, mhl.ASCHistoryDir, "*.mhl"))
+	if len(generations) != 2 {
+		t.Fatalf("Expected the card history to gain a generation, got %v", generations)
+	}
+	data, _ := ioutil.ReadFile(generations[1])
+	if !strings.Contains(string(data), "in-place") || !strings.Contains(string(data), `action="verified"`) {
+		t.Errorf("Expected an in-place verification generation:\n%s", data)
+	}
+}
+
+func TestTransferManager_MultipleDestinations(t *testing.T) {
+	primaryDir := t.TempDir()
+	mirrorDir := t.TempDir()
+	testFile := writeTestFile(t, filepath.Join(t.TempDir(), "Mirror_Client_ACam_001.mp4"), "mirrored content")
+
+	cfg := newTestConfig(t, "")
+	cfg.Transfer.MaxWorkers = 2
+	cfg.MHL = config.MHLConfig{Enabled: true, Format: mhl.FormatASC}
+	cfg.Transfer.HashAlgorithms = []string{checksum.XXH64}
+	cfg.Destinations = []config.DestinationConfig{
+		{Name: "raid", Path: primaryDir},
+		{Name: "shuttle", Path: mirrorDir, FolderStructure: "{client}"},
+	}
+
+	mgr := newTestManager(t, cfg)
+	if err := mgr.TransferFiles("test-device", []string{testFile}); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"raid":    filepath.Join(primaryDir, "Client", "Mirror", "ACam", "001.mp4"),
+		"shuttle": filepath.Join(mirrorDir, "Client", "001.mp4"),
+	}
+	for name, path := range expected {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Copy on %s missing: %v", name, err)
+		}
+		if string(content) != "mirrored content" {
+			t.Errorf("Copy on %s has content %q", name, content)
+		}
+	}
+
+	stats := mgr.GetStats()
+	for name := range expected {
+		ds := stats.Destinations[name]
+		if ds.Files != 1 || ds.FailedFiles != 0 || ds.Bytes != int64(len("mirrored content")) {
+			t.Errorf("Unexpected stats for %s: %+v", name, ds)
+		}
+	}
+
+	completed := mgr.GetCompletedTransfers()
+	if len(completed) != 1 || len(completed[0].Copies) != 2 {
+		t.Fatalf("Expected 1 completed transfer with 2 copies, got %+v", completed)
+	}
+	if completed[0].DestinationPath != expected["raid"] {
+		t.Errorf("Expected primary path %s, got %s", expected["raid"], completed[0].DestinationPath)
+	}
+
+	for _, root := range []string{primaryDir, mirrorDir} {
+		if _, err := os.Stat(filepath.Join(root, mhl.ASCHistoryDir, mhl.ASCChainFile)); err != nil {
+			t.Errorf("Expected MHL history in %s: %v", root, err)
+		}
+	}
+}
+
+func TestTransferManager_FailedMirror(t *testing.T) {
+	primaryDir := t.TempDir()
+	// A file where the mirror root should be makes every write there fail
+	brokenRoot := writeTestFile(t, filepath.Join(t.TempDir(), "not-a-dir"), "")
+	testFile := writeTestFile(t, filepath.Join(t.TempDir(), "Mirror_Client_BCam_001.mp4"), "content")
+
+	cfg := newTestConfig(t, "")
+	cfg.Destinations = []config.DestinationConfig{
+		{Name: "raid", Path: primaryDir},
+		{Name: "broken", Path: brokenRoot},
+	}
+
+	mgr := newTestManager(t, cfg)
+	if err := mgr.TransferFiles("test-device", []string{testFile}); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	primaryPath := filepath.Join(primaryDir, "Client", "Mirror", "BCam", "001.mp4")
+	if _, err := os.Stat(primaryPath); err != nil {
+		t.Errorf("Expected the healthy destination to receive the file: %v", err)
+	}
+
+	stats := mgr.GetStats()
+	if stats.FailedFiles != 1 {
+		t.Errorf("Expected the file to count as failed, got %d", stats.FailedFiles)
+	}
+	if ds := stats.Destinations["raid"]; ds.Files != 1 {
+		t.Errorf("Expected 1 file on raid, got %+v", ds)
+	}
+	if ds := stats.Destinations["broken"]; ds.FailedFiles != 1 {
+		t.Errorf("Expected 1 failed file on broken, got %+v", ds)
+	}
+	if completed := mgr.GetCompletedTransfers(); len(completed) != 0 {
+		t.Errorf("Expected no fully completed transfers, got %d", len(completed))
+	}
+}
+
+func TestTransferManager_PreservesMetadata(t *testing.T) {
+	destDir := t.TempDir()
+	testFile := writeTestFile(t, filepath.Join(t.TempDir(), "Meta_Client_ACam_001.mp4"), "recorded")
+	os.Chmod(testFile, 0600)
+	recorded := time.Date(2022, 3, 4, 9, 15, 0, 0, time.UTC)
+	os.Chtimes(testFile, recorded, recorded)
+
+	cfg := newTestConfig(t, destDir)
+	cfg.Transfer.VerifyMode = config.VerifyModeReadback
+	cfg.Transfer.PreserveMode = true
+
+	mgr := newTestManager(t, cfg)
+	if err := mgr.TransferFiles("test-device", []string{testFile}); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(destDir, "Client", "Meta", "ACam", "001.mp4"))
+	if err != nil {
+		t.Fatalf("Expected the copy to exist: %v", err)
+	}
+	if !info.ModTime().Equal(recorded) {
+		t.Errorf("Expected the recording time %v as mtime, got %v", recorded, info.ModTime())
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the source mode 0600, got %v", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,47 @@
+package transfer
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/autofileingest/internal/config"
+	"github.com/autofileingest/internal/storage/webdavtest"
+)
+
+func TestTransferManager_VersionsOnWebDAV(t *testing.T) {
+	dav, server := webdavtest.New(t)
+
+	cfg := newTestConfig(t, "")
+	cfg.Destinations = []config.DestinationConfig{{
+		Name: "delivery",
+		Type: config.DestinationWebDAV,
+		WebDAV: config.WebDAVConfig{
+			URL:      server.URL + webdavtest.Prefix + "/",
+			Username: webdavtest.Username,
+			Password: webdavtest.Password,
+		},
+	}}
+
+	for _, content := range []string{"first ingest", "second ingest"} {
+		clip := writeTestFile(t, filepath.Join(t.TempDir(), "Dav_Client_ACam_001.mp4"), content)
+		mgr := newTestManager(t, cfg)
+		if err := mgr.TransferFiles("test-device", []string{clip}); err != nil {
+			t.Fatalf("Transfer failed: %v", err)
+		}
+		if stats := mgr.GetStats(); stats.FailedFiles != 0 {
+			t.Fatalf("Expected the upload to succeed, got %d failures", stats.FailedFiles)
+		}
+	}
+
+	if got := string(dav.Files["/Client/Dav/ACam/001.mp4"]); got != "first ingest" {
+		t.Errorf("Expected the first ingest kept, got %q", got)
+	}
+	if got := string(dav.Files["/Client/Dav/ACam/001_v2.mp4"]); got != "second ingest" {
+		var names []string
+		for name := range dav.Files {
+			names = append(names, name)
+		}
+		t.Errorf("Expected the second ingest as 001_v2.mp4, got %q (files: %s)", got, strings.Join(names, ", "))
+	}
+}